@@ -2,16 +2,17 @@
 package capab
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"sync"
+	"time"
 
+	"awesome-dragon.science/go/irc/logger"
 	"awesome-dragon.science/go/irc/numerics"
 	"github.com/ergochat/irc-go/ircmsg"
-	"github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger("irc-capab") //nolint:gochecknoglobals // logger
-
 type eventManager interface {
 	AddCallback(string, func(*ircmsg.Message) error) int
 	RemoveCallback(int)
@@ -26,9 +27,34 @@ type Config struct {
 	SASLPassword string
 	SASLMech     string
 
+	// ListCapabilities causes capabilities to be listed even if there are none to request
+	ListCapabilities bool
+	// OnOffered, if set, is called with every capability the server offered, once they have all been received,
+	// and before anything is requested. If it returns false, negotiation is aborted without ending
+	// registration, as the connection is about to be dropped. This is used for things like STS.
+	OnOffered func(offered []Capability) bool
+
+	// Timeout is how long to wait for the server to finish negotiation, and SASL, before assuming it does not
+	// support capabilities. DefaultNegotiationTimeout if 0
+	Timeout time.Duration
+
+	// Logger receives the Negotiator's logs. If nil, logger.Default() is used
+	Logger logger.Logger
+
 	// TODO: keys
 }
 
+// DefaultNegotiationTimeout is used when Config.Timeout is not set
+const DefaultNegotiationTimeout = time.Second * 30
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultNegotiationTimeout
+	}
+
+	return c.Timeout
+}
+
 // Capability represents a single IRCv3 capability
 type Capability struct {
 	Name         string
@@ -49,11 +75,13 @@ type Negotiator struct {
 	eventManager eventManager
 	writeIRC     func(string, ...string) error
 	config       *Config
+	log          logger.Logger
 
 	capabilities []*Capability
 	incomingCaps []string
 
 	doingNegotiation bool
+	aborted          bool
 	requestsSent     int
 }
 
@@ -63,6 +91,7 @@ func New(conf *Config, writeToIRC func(string, ...string) error, eventManager ev
 		config:       conf,
 		writeIRC:     writeToIRC,
 		eventManager: eventManager,
+		log:          logger.Or(conf.Logger),
 	}
 
 	for _, c := range conf.ToRequest {
@@ -75,14 +104,31 @@ func New(conf *Config, writeToIRC func(string, ...string) error, eventManager ev
 // Negotiate negotiates IRCv3 capabilities with a server, and optionally performs
 // sasl authentication
 func (n *Negotiator) Negotiate() {
-	if len(n.capabilities) == 0 {
+	_ = n.NegotiateContext(context.Background())
+}
+
+// NegotiateContext is like Negotiate, but gives up if ctx is done first, such as when the connection is lost, and
+// returns its error. Registration is not ended in that case.
+func (n *Negotiator) NegotiateContext(ctx context.Context) error {
+	if len(n.capabilities) == 0 && !n.config.ListCapabilities {
 		// None to request, dont do anything
-		return
+		return nil
 	}
-	n.doNegotiation()
 
-	if err := n.doSasl(); err != nil {
-		log.Errorf("Failed SASL: %s", err)
+	if err := n.doNegotiation(ctx); err != nil {
+		return err
+	}
+
+	if n.aborted {
+		return nil
+	}
+
+	if err := n.doSasl(ctx); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("capab.negotiate: %w", ctx.Err())
+		}
+
+		n.log.Error("Failed SASL", "error", err)
 	}
 
 	// Add NEW/DEL
@@ -100,6 +146,8 @@ func (n *Negotiator) Negotiate() {
 	})
 
 	_ = n.writeIRC("CAP", "END")
+
+	return nil
 }
 
 // AvailableCaps returns a list of capabilities that have been requested and acknowledged by the server
@@ -118,18 +166,64 @@ func (n *Negotiator) AvailableCaps() []Capability {
 	return out
 }
 
-func (n *Negotiator) doNegotiation() {
-	msgChan := make(chan *ircmsg.Message)
+// OfferedCaps returns a list of every capability the server has offered, whether or not it was requested
+func (n *Negotiator) OfferedCaps() []Capability {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	out := []Capability{}
+
+	for _, c := range n.capabilities {
+		if c.Available {
+			out = append(out, *c)
+		}
+	}
+
+	return out
+}
+
+func (n *Negotiator) doNegotiation(ctx context.Context) error {
+	// Callbacks may be called straight from a connection's read loop, so they must never block for long
+	msgChan := make(chan *ircmsg.Message, 16)
+	done := make(chan struct{})
+
+	defer close(done)
+
+	send := func(msg *ircmsg.Message) {
+		select {
+		case msgChan <- msg:
+		case <-done:
+		}
+	}
 
 	capCallback := n.eventManager.AddCallback("CAP", func(msg *ircmsg.Message) error {
-		msgChan <- msg
+		send(msg)
 
 		return nil
 	})
 	welcomeCallback := n.eventManager.AddCallback(
 		numerics.RPL_WELCOME,
 		func(msg *ircmsg.Message) error {
-			msgChan <- msg
+			send(msg)
+
+			return nil
+		},
+	)
+	unknownCallback := n.eventManager.AddCallback(
+		numerics.ERR_UNKNOWNCOMMAND,
+		func(msg *ircmsg.Message) error {
+			if len(msg.Params) > 1 && strings.EqualFold(msg.Params[1], "CAP") {
+				send(msg)
+			}
+
+			return nil
+		},
+	)
+	// Some servers without CAP refuse everything before NICK and USER
+	notRegisteredCallback := n.eventManager.AddCallback(
+		numerics.ERR_NOTREGISTERED,
+		func(msg *ircmsg.Message) error {
+			send(msg)
 
 			return nil
 		},
@@ -137,16 +231,32 @@ func (n *Negotiator) doNegotiation() {
 
 	defer n.eventManager.RemoveCallback(capCallback)
 	defer n.eventManager.RemoveCallback(welcomeCallback)
+	defer n.eventManager.RemoveCallback(unknownCallback)
+	defer n.eventManager.RemoveCallback(notRegisteredCallback)
 	n.doingNegotiation = true
 	_ = n.writeIRC("CAP", "LS", "302")
 
+	timeout := time.NewTimer(n.config.timeout())
+	defer timeout.Stop()
+
 	for n.doingNegotiation {
-		msg := <-msgChan
+		var msg *ircmsg.Message
 
-		if msg.Command == numerics.RPL_WELCOME {
-			log.Warning("Got unexpected 001. Assuming the server does not support capabilities")
+		select {
+		case msg = <-msgChan:
+		case <-ctx.Done():
+			return fmt.Errorf("capab.negotiate: %w", ctx.Err())
+		case <-timeout.C:
+			n.log.Warn("Timed out waiting for the server, assuming it does not support capabilities")
 
-			break
+			return nil
+		}
+
+		switch msg.Command {
+		case numerics.RPL_WELCOME, numerics.ERR_UNKNOWNCOMMAND, numerics.ERR_NOTREGISTERED:
+			n.log.Warn("Got unexpected reply, assuming the server does not support capabilities", "command", msg.Command)
+
+			return nil
 		}
 
 		split := strings.Split(msg.Params[len(msg.Params)-1], " ")
@@ -168,9 +278,11 @@ func (n *Negotiator) doNegotiation() {
 		case "NEW":
 			n.onCapNEW(split)
 		default:
-			log.Infof("Unknown CAP command %q. ignoring", cmd)
+			n.log.Info("Ignoring unknown CAP subcommand", "command", msg.Command, "subcommand", cmd)
 		}
 	}
+
+	return nil
 }
 
 func (n *Negotiator) onCapLS(caps []string, moreComing bool) {
@@ -181,9 +293,19 @@ func (n *Negotiator) onCapLS(caps []string, moreComing bool) {
 	}
 
 	// No more coming
-	log.Infof("Server offered caps %v", n.incomingCaps)
+	n.log.Info("Server offered capabilities", "caps", n.incomingCaps)
 	n.parseCaps()
 	n.incomingCaps = nil // clear this for use in ACK later
+
+	if n.config.OnOffered != nil && !n.config.OnOffered(n.OfferedCaps()) {
+		n.log.Info("Capability negotiation aborted")
+
+		n.aborted = true
+		n.doingNegotiation = false
+
+		return
+	}
+
 	n.requestCaps()
 }
 
@@ -213,7 +335,15 @@ func (n *Negotiator) requestCaps() {
 
 	lines = append(lines, strings.TrimSpace(builder.String()))
 
-	log.Infof("Requesting capabilities %v", toRequest)
+	if len(toRequest) == 0 {
+		n.log.Info("No capabilities to request")
+
+		n.doingNegotiation = false
+
+		return
+	}
+
+	n.log.Info("Requesting capabilities", "caps", toRequest)
 
 	n.requestsSent += len(lines)
 
@@ -223,6 +353,9 @@ func (n *Negotiator) requestCaps() {
 }
 
 func (n *Negotiator) parseCaps() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
 	for _, capab := range n.incomingCaps {
 		name := capab
 		value := ""
@@ -272,6 +405,9 @@ func (n *Negotiator) onCapACK(caps []string) {
 	// no more coming
 	ackedCaps := make([]*Capability, 0, len(n.incomingCaps))
 
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
 	for _, cName := range n.incomingCaps {
 		c := n.capByName(cName)
 		ackedCaps = append(ackedCaps, c)
@@ -279,11 +415,11 @@ func (n *Negotiator) onCapACK(caps []string) {
 		if c != nil {
 			c.Acknowledged = true
 		} else {
-			log.Warningf("Got an ACK for a CAP %q we dont know about! ignoring!", cName)
+			n.log.Warn("Ignoring ACK for an unknown capability", "cap", cName)
 		}
 	}
 
-	log.Infof("Server ack'd caps: %v", ackedCaps)
+	n.log.Info("Server acknowledged capabilities", "caps", ackedCaps)
 
 	n.doingNegotiation = false
 }
@@ -309,7 +445,7 @@ func (n *Negotiator) onCapDEL(caps []string) {
 			c.Available = false
 			c.Acknowledged = false
 		} else {
-			log.Warningf("unknown cap %q DELeted", v)
+			n.log.Warn("Unknown capability deleted", "cap", v)
 		}
 	}
 }
@@ -0,0 +1,149 @@
+package capab
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/logger"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+type fakeCallback struct {
+	command  string
+	callback func(*ircmsg.Message) error
+}
+
+// fakeEvents is an eventManager that lets tests send lines to a Negotiator, and records what it writes
+type fakeEvents struct {
+	mu        sync.Mutex
+	callbacks map[int]fakeCallback
+	nextID    int
+	written   chan string
+}
+
+func newFakeEvents() *fakeEvents {
+	return &fakeEvents{callbacks: make(map[int]fakeCallback), written: make(chan string, 10)}
+}
+
+func (e *fakeEvents) AddCallback(command string, callback func(*ircmsg.Message) error) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.nextID++
+	e.callbacks[e.nextID] = fakeCallback{command: command, callback: callback}
+
+	return e.nextID
+}
+
+func (e *fakeEvents) RemoveCallback(id int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.callbacks, id)
+}
+
+func (e *fakeEvents) writeIRC(command string, params ...string) error {
+	e.written <- strings.Join(append([]string{command}, params...), " ")
+
+	return nil
+}
+
+// send gives line to every callback for its command
+func (e *fakeEvents) send(t *testing.T, line string) {
+	t.Helper()
+
+	msg, err := ircmsg.ParseLine(line)
+	if err != nil {
+		t.Fatalf("could not parse %q: %s", line, err)
+	}
+
+	e.mu.Lock()
+	callbacks := []func(*ircmsg.Message) error{}
+
+	for _, c := range e.callbacks {
+		if c.command == msg.Command {
+			callbacks = append(callbacks, c.callback)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, callback := range callbacks {
+		_ = callback(&msg)
+	}
+}
+
+func (e *fakeEvents) expect(t *testing.T, want string) {
+	t.Helper()
+
+	select {
+	case line := <-e.written:
+		if line != want {
+			t.Errorf("wrote %q, want %q", line, want)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func TestNegotiator_NegotiateContext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		reply   string // Sent after CAP LS, if not empty
+		timeout time.Duration
+		cancel  bool
+		wantErr error
+		wantEnd bool
+	}{
+		{name: "not registered", reply: ":irc.test 451 * :You have not registered", wantEnd: true},
+		{name: "unknown command", reply: ":irc.test 421 * CAP :Unknown command", wantEnd: true},
+		{name: "timeout", timeout: time.Millisecond * 10, wantEnd: true},
+		{name: "cancelled", cancel: true, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			events := newFakeEvents()
+			n := New(&Config{ListCapabilities: true, Timeout: tt.timeout, Logger: logger.Discard},
+				events.writeIRC, events)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			result := make(chan error, 1)
+
+			go func() { result <- n.NegotiateContext(ctx) }()
+
+			events.expect(t, "CAP LS 302")
+
+			if tt.reply != "" {
+				events.send(t, tt.reply)
+			}
+
+			if tt.cancel {
+				cancel()
+			}
+
+			select {
+			case err := <-result:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("NegotiateContext() = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("NegotiateContext() did not return")
+			}
+
+			if tt.wantEnd {
+				events.expect(t, "CAP END")
+			}
+		})
+	}
+}
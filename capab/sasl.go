@@ -1,17 +1,19 @@
 package capab
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"awesome-dragon.science/go/irc/numerics"
 	"awesome-dragon.science/go/irc/util"
 	"github.com/ergochat/irc-go/ircmsg"
 )
 
-func (n *Negotiator) doSasl() error {
+func (n *Negotiator) doSasl(ctx context.Context) error {
 	saslCap := n.capByName("sasl")
 
 	if !n.config.SASL {
@@ -36,11 +38,7 @@ func (n *Negotiator) doSasl() error {
 
 	switch mech {
 	case "PLAIN":
-		c := make(chan error)
-
-		go func() { c <- n.doSaslPLAIN(n.config.SASLUsername, n.config.SASLPassword) }()
-
-		return <-c
+		return n.doSaslPLAIN(ctx, n.config.SASLUsername, n.config.SASLPassword)
 
 	default:
 		return ErrSASLMechNotSupported
@@ -60,12 +58,13 @@ var (
 	ErrSASLMechNotSupported = errors.New("SASL mechanism not supported by server")
 )
 
-func (n *Negotiator) doSaslPLAIN(username, password string) error {
+func (n *Negotiator) doSaslPLAIN(ctx context.Context, username, password string) error {
 	if username == "" || password == "" {
 		return fmt.Errorf("cannot authenticate with empty username or password: %w", ErrSASLFailed)
 	}
 
-	authChan := make(chan string)
+	// Each callback sends at most once, so this never blocks the caller
+	authChan := make(chan string, 3)
 
 	var authenticateID, authGoodID, authBadID int
 
@@ -102,15 +101,40 @@ func (n *Negotiator) doSaslPLAIN(username, password string) error {
 	defer n.eventManager.RemoveCallback(authGoodID)
 	defer n.eventManager.RemoveCallback(authBadID)
 
+	timeout := time.NewTimer(n.config.timeout())
+	defer timeout.Stop()
+
+	// next waits for the server's next reply, or gives up
+	next := func() (string, error) {
+		select {
+		case res := <-authChan:
+			return res, nil
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %s", ErrSASLFailed, ctx.Err())
+		case <-timeout.C:
+			return "", fmt.Errorf("timed out waiting for the server: %w", ErrSASLFailed)
+		}
+	}
+
 	_ = n.writeIRC("AUTHENTICATE", "PLAIN")
 
-	if res := <-authChan; res != "+" {
+	res, err := next()
+	if err != nil {
+		return err
+	}
+
+	if res != "+" {
 		return fmt.Errorf("server returned unexpected data %q: %w", res, ErrSASLFailed)
 	}
 
 	_ = n.writeIRC("AUTHENTICATE", makePlainAuth(username, password))
 
-	switch res := <-authChan; res {
+	res, err = next()
+	if err != nil {
+		return err
+	}
+
+	switch res {
 	case "GOOD":
 		return nil
 	case "BAD":
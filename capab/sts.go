@@ -0,0 +1,251 @@
+package capab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// STS is the name of the IRCv3 Strict Transport Security capability
+// https://ircv3.net/specs/extensions/sts
+const STS = "sts"
+
+// STSValue is a parsed sts capability value
+type STSValue struct {
+	Port        int           // Port to upgrade to, only meaningful on insecure connections
+	Duration    time.Duration // How long the policy should be kept for, only meaningful on secure connections
+	HasDuration bool
+	Preload     bool
+}
+
+// ErrInvalidSTS is returned when an sts capability value cannot be parsed
+var ErrInvalidSTS = errors.New("invalid sts value")
+
+// ParseSTS parses the value of an sts capability. Unknown keys are ignored, as required by the spec
+func ParseSTS(value string) (STSValue, error) {
+	out := STSValue{}
+
+	for _, pair := range strings.Split(value, ",") {
+		split := strings.SplitN(pair, "=", 2)
+		key, val := split[0], ""
+
+		if len(split) > 1 {
+			val = split[1]
+		}
+
+		switch key {
+		case "port":
+			port, err := strconv.Atoi(val)
+			if err != nil || port <= 0 || port > 65535 {
+				return STSValue{}, fmt.Errorf("%w: bad port %q", ErrInvalidSTS, val)
+			}
+
+			out.Port = port
+
+		case "duration":
+			seconds, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || seconds < 0 {
+				return STSValue{}, fmt.Errorf("%w: bad duration %q", ErrInvalidSTS, val)
+			}
+
+			out.Duration = time.Duration(seconds) * time.Second
+			out.HasDuration = true
+
+		case "preload":
+			out.Preload = true
+		}
+	}
+
+	return out, nil
+}
+
+// STSPolicy is a persisted STS policy for a single host
+type STSPolicy struct {
+	Host    string
+	Port    int // The secure port the policy was set on
+	Expires time.Time
+	Preload bool
+}
+
+// Expired returns whether or not the policy has expired
+func (p *STSPolicy) Expired() bool {
+	return time.Now().After(p.Expires)
+}
+
+// STSStore stores STS policies. Implementations must be safe for concurrent use
+type STSStore interface {
+	// Get returns the policy for the given host, if one exists and has not expired
+	Get(host string) (*STSPolicy, error)
+	// Set stores the given policy, replacing any existing one for the same host
+	Set(policy *STSPolicy) error
+	// Delete removes the policy for the given host, if any
+	Delete(host string) error
+}
+
+// MemorySTSStore is an in-memory STSStore. Policies do not survive restarts
+type MemorySTSStore struct {
+	mu       sync.Mutex
+	policies map[string]STSPolicy
+}
+
+var _ STSStore = (*MemorySTSStore)(nil)
+
+// Get implements STSStore
+func (m *MemorySTSStore) Get(host string) (*STSPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policy, exists := m.policies[strings.ToLower(host)]
+	if !exists || policy.Expired() {
+		return nil, nil //nolint:nilnil // No policy is not an error
+	}
+
+	return &policy, nil
+}
+
+// Set implements STSStore
+func (m *MemorySTSStore) Set(policy *STSPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.policies == nil {
+		m.policies = make(map[string]STSPolicy)
+	}
+
+	m.policies[strings.ToLower(policy.Host)] = *policy
+
+	return nil
+}
+
+// Delete implements STSStore
+func (m *MemorySTSStore) Delete(host string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.policies, strings.ToLower(host))
+
+	return nil
+}
+
+// FileSTSStore is an STSStore that keeps its policies in a JSON file. The file is reread on every Get,
+// so that multiple processes can share it
+type FileSTSStore struct {
+	mu   sync.Mutex
+	Path string
+}
+
+var _ STSStore = (*FileSTSStore)(nil)
+
+// DefaultSTSStorePath returns the default location for a FileSTSStore, in the user's cache directory
+func DefaultSTSStorePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find cache directory: %w", err)
+	}
+
+	return filepath.Join(dir, "awesome-dragon.science-irc", "sts.json"), nil
+}
+
+func (f *FileSTSStore) load() (map[string]STSPolicy, error) {
+	out := make(map[string]STSPolicy)
+
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read STS policies: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("could not parse STS policies: %w", err)
+	}
+
+	return out, nil
+}
+
+func (f *FileSTSStore) save(policies map[string]STSPolicy) error {
+	for host, policy := range policies {
+		if policy.Expired() {
+			delete(policies, host)
+		}
+	}
+
+	data, err := json.MarshalIndent(policies, "", "\t")
+	if err != nil {
+		return fmt.Errorf("could not marshal STS policies: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return fmt.Errorf("could not create STS policy directory: %w", err)
+	}
+
+	// Write then rename, so that a crash cant leave us with half a file
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("could not write STS policies: %w", err)
+	}
+
+	if err := os.Rename(tmp, f.Path); err != nil {
+		return fmt.Errorf("could not write STS policies: %w", err)
+	}
+
+	return nil
+}
+
+// Get implements STSStore
+func (f *FileSTSStore) Get(host string) (*STSPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policies, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	policy, exists := policies[strings.ToLower(host)]
+	if !exists || policy.Expired() {
+		return nil, nil //nolint:nilnil // No policy is not an error
+	}
+
+	return &policy, nil
+}
+
+// Set implements STSStore
+func (f *FileSTSStore) Set(policy *STSPolicy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policies, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	policies[strings.ToLower(policy.Host)] = *policy
+
+	return f.save(policies)
+}
+
+// Delete implements STSStore
+func (f *FileSTSStore) Delete(host string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policies, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	if _, exists := policies[strings.ToLower(host)]; !exists {
+		return nil
+	}
+
+	delete(policies, strings.ToLower(host))
+
+	return f.save(policies)
+}
@@ -0,0 +1,104 @@
+package capab
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSTS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    STSValue
+		wantErr bool
+	}{
+		{name: "port", value: "port=6697", want: STSValue{Port: 6697}},
+		{
+			name:  "duration and preload",
+			value: "duration=60,preload",
+			want:  STSValue{Duration: time.Minute, HasDuration: true, Preload: true},
+		},
+		{name: "zero duration", value: "duration=0", want: STSValue{HasDuration: true}},
+		{name: "unknown keys", value: "port=6697,foo=bar,baz", want: STSValue{Port: 6697}},
+		{name: "bad port", value: "port=lots", wantErr: true},
+		{name: "port out of range", value: "port=70000", wantErr: true},
+		{name: "negative duration", value: "duration=-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseSTS(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSTS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidSTS) {
+				t.Errorf("ParseSTS() error = %v, want %v", err, ErrInvalidSTS)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSTS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testSTSStore(t *testing.T, store STSStore) {
+	t.Helper()
+
+	if policy, err := store.Get("irc.example.com"); policy != nil || err != nil {
+		t.Fatalf("Get() on empty store = %v, %v", policy, err)
+	}
+
+	if err := store.Set(&STSPolicy{Host: "IRC.example.com", Port: 6697, Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Set() error = %s", err)
+	}
+
+	if err := store.Set(&STSPolicy{Host: "old.example.com", Port: 6697, Expires: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Set() error = %s", err)
+	}
+
+	if policy, err := store.Get("irc.example.com"); err != nil || policy == nil || policy.Port != 6697 {
+		t.Errorf("Get() = %+v, %v, want a policy for port 6697", policy, err)
+	}
+
+	if policy, _ := store.Get("old.example.com"); policy != nil {
+		t.Errorf("Get() returned expired policy %+v", policy)
+	}
+
+	if err := store.Delete("irc.example.com"); err != nil {
+		t.Fatalf("Delete() error = %s", err)
+	}
+
+	if policy, _ := store.Get("irc.example.com"); policy != nil {
+		t.Errorf("Get() returned deleted policy %+v", policy)
+	}
+}
+
+func TestMemorySTSStore(t *testing.T) {
+	t.Parallel()
+	testSTSStore(t, &MemorySTSStore{})
+}
+
+func TestFileSTSStore(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sts", "policies.json")
+	testSTSStore(t, &FileSTSStore{Path: path})
+
+	if err := (&FileSTSStore{Path: path}).Set(&STSPolicy{Host: "a", Port: 1, Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Set() error = %s", err)
+	}
+
+	if policy, _ := (&FileSTSStore{Path: path}).Get("a"); policy == nil {
+		t.Error("policy was not persisted between stores")
+	}
+}
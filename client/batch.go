@@ -0,0 +1,64 @@
+package client
+
+import (
+	"awesome-dragon.science/go/irc/event"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// batchCollector collects the lines in IRCv3 batches as they arrive, so that handlers can be given whole batches.
+// It is only used from listenLoop, so it has no locking.
+type batchCollector struct {
+	open map[string]*event.Batch // Batches that have started but not yet ended, by reference tag
+}
+
+func newBatchCollector() *batchCollector {
+	return &batchCollector{open: make(map[string]*event.Batch)}
+}
+
+// add adds msg to the batch it is part of, and returns that batch, or nil if it is not part of one. For the BATCH
+// lines that start and end a batch, that batch is returned. finished is true if msg ended a top level batch.
+func (b *batchCollector) add(msg *ircmsg.Message) (batch *event.Batch, finished bool) {
+	var parent *event.Batch
+	if ok, ref := msg.GetTag("batch"); ok {
+		parent = b.open[ref]
+	}
+
+	if msg.Command != "BATCH" || len(msg.Params) == 0 || len(msg.Params[0]) < 2 {
+		if parent != nil {
+			parent.Messages = append(parent.Messages, msg)
+		}
+
+		return parent, false
+	}
+
+	ref := msg.Params[0][1:]
+
+	switch msg.Params[0][0] {
+	case '+':
+		batch = &event.Batch{Ref: ref, Tags: msg.AllTags(), Parent: parent}
+		if len(msg.Params) > 1 {
+			batch.Type = msg.Params[1]
+			batch.Params = append([]string{}, msg.Params[2:]...)
+		}
+
+		if parent != nil {
+			parent.Batches = append(parent.Batches, batch)
+		}
+
+		b.open[ref] = batch
+
+		return batch, false
+
+	case '-':
+		batch, ok := b.open[ref]
+		if !ok {
+			return parent, false
+		}
+
+		delete(b.open, ref)
+
+		return batch, batch.Parent == nil
+	}
+
+	return parent, false
+}
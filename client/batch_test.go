@@ -0,0 +1,195 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/event/function"
+	"awesome-dragon.science/go/irc/event/multi"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestBatchCollector(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{
+		":irc.test BATCH +outer chathistory #chan",
+		"@batch=outer :a!u@h PRIVMSG #chan :one",
+		"@batch=outer :irc.test BATCH +inner netsplit hub.test leaf.test",
+		"@batch=inner :b!u@h QUIT :hub.test leaf.test",
+		":irc.test BATCH -inner",
+		"@batch=outer :a!u@h PRIVMSG #chan :two",
+		"@batch=unknown :a!u@h PRIVMSG #chan :three",
+		":irc.test BATCH -outer",
+	}
+
+	collector := newBatchCollector()
+
+	var (
+		batches  []*event.Batch
+		finished []bool
+	)
+
+	for _, line := range lines {
+		msg, err := ircmsg.ParseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		batch, done := collector.add(&msg)
+		batches, finished = append(batches, batch), append(finished, done)
+	}
+
+	outer, inner := batches[0], batches[2]
+	wantBatches := []*event.Batch{outer, outer, inner, inner, inner, outer, nil, outer}
+
+	for i, want := range wantBatches {
+		if batches[i] != want {
+			t.Errorf("line %d: batch = %+v, want %+v", i, batches[i], want)
+		}
+
+		if finished[i] != (i == len(lines)-1) {
+			t.Errorf("line %d: finished = %t", i, finished[i])
+		}
+	}
+
+	if outer.Type != event.BatchChathistory || len(outer.Params) != 1 || outer.Params[0] != "#chan" {
+		t.Errorf("outer = %+v", outer)
+	}
+
+	if inner.Type != event.BatchNetsplit || inner.Parent != outer || len(outer.Batches) != 1 {
+		t.Errorf("inner = %+v", inner)
+	}
+
+	if len(outer.Messages) != 2 || len(inner.Messages) != 1 || len(outer.AllMessages()) != 3 {
+		t.Errorf("messages = %d, %d, %d", len(outer.Messages), len(inner.Messages), len(outer.AllMessages()))
+	}
+
+	if len(collector.open) != 0 {
+		t.Errorf("%d batches still open", len(collector.open))
+	}
+}
+
+func TestClient_Batches(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	server.Expect("USER")
+
+	lines := make(chan *event.Message, 10)
+	batches := make(chan *event.Batch, 1)
+
+	handler := &multi.Handler{}
+	handler.AddHandlers(
+		function.FuncHandler(func(msg *event.Message) error {
+			if msg.Raw.Command == "QUIT" || msg.Raw.Command == "PRIVMSG" {
+				lines <- msg
+			}
+
+			return nil
+		}),
+		function.BatchFuncHandler(func(msg *event.Message) error {
+			batches <- msg.Batch
+
+			return nil
+		}),
+	)
+	c.SetMessageHandler(handler)
+
+	server.Send(
+		":irc.test BATCH +split netsplit hub.test leaf.test",
+		"@batch=split :a!u@h QUIT :hub.test leaf.test",
+		"@batch=split :b!u@h QUIT :hub.test leaf.test",
+		":irc.test BATCH -split",
+		":a!u@h PRIVMSG #chan :not in a batch",
+	)
+
+	var batch *event.Batch
+	select {
+	case batch = <-batches:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the batch")
+	}
+
+	if batch.Type != event.BatchNetsplit || len(batch.Messages) != 2 || batch.Params[1] != "leaf.test" {
+		t.Errorf("batch = %+v", batch)
+	}
+
+	// Individual lines are still delivered, marked with their batch
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-lines:
+			if inBatch := msg.Raw.Command == "QUIT"; msg.InBatch() != inBatch {
+				t.Errorf("%q: InBatch() = %t, want %t", msg.Raw.Command, msg.InBatch(), inBatch)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for lines")
+		}
+	}
+}
+
+// lineAndBatchHandler records the commands it is given with OnMessage, and the batches it is given with OnBatch
+type lineAndBatchHandler struct {
+	lines   chan string
+	batches chan *event.Batch
+}
+
+func (h *lineAndBatchHandler) OnMessage(msg *event.Message) error {
+	h.lines <- msg.Raw.Command
+
+	return nil
+}
+
+func (h *lineAndBatchHandler) OnBatch(msg *event.Message) error {
+	h.batches <- msg.Batch
+
+	return nil
+}
+
+func TestClient_BatchLinesOnlyOnce(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	server.Expect("USER")
+
+	handler := &lineAndBatchHandler{lines: make(chan string, 100), batches: make(chan *event.Batch, 1)}
+	c.SetMessageHandler(handler)
+
+	server.Send(
+		":irc.test BATCH +split netsplit hub.test leaf.test",
+		"@batch=split :a!u@h QUIT :hub.test leaf.test",
+		":irc.test BATCH -split",
+		":a!u@h PRIVMSG #chan :not in a batch",
+	)
+
+	var batch *event.Batch
+	select {
+	case batch = <-handler.batches:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the batch")
+	}
+
+	want := []string{"BATCH", "QUIT", "BATCH"}
+	if len(batch.Lines) != len(want) {
+		t.Fatalf("batch has %d lines, want %d", len(batch.Lines), len(want))
+	}
+
+	for i, line := range batch.Lines {
+		if line.Raw.Command != want[i] {
+			t.Errorf("line %d = %q, want %q", i, line.Raw.Command, want[i])
+		}
+	}
+
+	for command := ""; command != "PRIVMSG"; {
+		select {
+		case command = <-handler.lines:
+			if command == "QUIT" || command == "BATCH" {
+				t.Errorf("OnMessage was given %s from inside the batch", command)
+			}
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for the line after the batch")
+		}
+	}
+}
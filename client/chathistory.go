@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/user"
+)
+
+// ErrNoChathistory is returned by the Chathistory methods when the chathistory capability has not been negotiated
+var ErrNoChathistory = errors.New("chathistory is not available")
+
+// chathistoryTargetsBatch is the batch type used for the reply to CHATHISTORY TARGETS
+const chathistoryTargetsBatch = "draft/chathistory-targets"
+
+// defaultHistoryLimit is the number of messages asked for when neither the caller nor the server set a limit
+const defaultHistoryLimit = 100
+
+// historyTimeFormat is the format of timestamps in CHATHISTORY commands, and server-time tags
+const historyTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// HistorySelector picks a point in a target's history for the Chathistory methods
+type HistorySelector string
+
+// HistoryAny asks for the latest messages with no bound. It is only valid for ChathistoryLatest
+const HistoryAny HistorySelector = "*"
+
+// HistoryMsgID selects the message with the given msgid
+func HistoryMsgID(msgid string) HistorySelector { return HistorySelector("msgid=" + msgid) }
+
+// HistoryTime selects the given time
+func HistoryTime(t time.Time) HistorySelector {
+	return HistorySelector("timestamp=" + t.UTC().Format(historyTimeFormat))
+}
+
+// HistoryTarget is a target with history, from ChathistoryTargets
+type HistoryTarget struct {
+	Name   string    // The channel or nick
+	Latest time.Time // When the latest message to or from the target was sent
+}
+
+// ChathistoryLatest returns up to limit of the latest messages in target's history, newer than after. Use
+// HistoryAny to get the latest messages with no bound.
+func (c *Client) ChathistoryLatest(
+	ctx context.Context, target string, after HistorySelector, limit int,
+) ([]*event.Message, error) {
+	return c.chathistory(ctx, target, "LATEST", target, string(after), c.historyLimit(limit))
+}
+
+// ChathistoryBefore returns up to limit of the messages in target's history before the selected one
+func (c *Client) ChathistoryBefore(
+	ctx context.Context, target string, before HistorySelector, limit int,
+) ([]*event.Message, error) {
+	return c.chathistory(ctx, target, "BEFORE", target, string(before), c.historyLimit(limit))
+}
+
+// ChathistoryAfter returns up to limit of the messages in target's history after the selected one
+func (c *Client) ChathistoryAfter(
+	ctx context.Context, target string, after HistorySelector, limit int,
+) ([]*event.Message, error) {
+	return c.chathistory(ctx, target, "AFTER", target, string(after), c.historyLimit(limit))
+}
+
+// ChathistoryAround returns up to limit of the messages in target's history around the selected one
+func (c *Client) ChathistoryAround(
+	ctx context.Context, target string, around HistorySelector, limit int,
+) ([]*event.Message, error) {
+	return c.chathistory(ctx, target, "AROUND", target, string(around), c.historyLimit(limit))
+}
+
+// ChathistoryBetween returns up to limit of the messages in target's history between start and end. If end is
+// before start, the messages closest to start are returned.
+func (c *Client) ChathistoryBetween(
+	ctx context.Context, target string, start, end HistorySelector, limit int,
+) ([]*event.Message, error) {
+	return c.chathistory(ctx, target, "BETWEEN", target, string(start), string(end), c.historyLimit(limit))
+}
+
+// ChathistoryTargets returns up to limit of the channels and users we have history with, that have messages
+// between start and end
+func (c *Client) ChathistoryTargets(ctx context.Context, start, end time.Time, limit int) ([]HistoryTarget, error) {
+	res, err := c.doChathistory(
+		ctx, "TARGETS", string(HistoryTime(start)), string(HistoryTime(end)), c.historyLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []HistoryTarget{}
+
+	for _, msg := range historyMessages(res, chathistoryTargetsBatch, func(*event.Batch) bool { return true }) {
+		// CHATHISTORY TARGETS target timestamp
+		p := msg.Raw.Params
+		if msg.Raw.Command != "CHATHISTORY" || len(p) < 3 || p[0] != "TARGETS" {
+			continue
+		}
+
+		latest, _ := time.Parse(historyTimeFormat, strings.TrimPrefix(p[2], "timestamp="))
+		out = append(out, HistoryTarget{Name: p[1], Latest: latest})
+	}
+
+	return out, nil
+}
+
+// historyLimit returns limit, capped to the server's CHATHISTORY token. If limit is not positive, the server's
+// limit, or defaultHistoryLimit is used
+func (c *Client) historyLimit(limit int) string {
+	serverLimit := c.conn().ISupport.MaxChathistory()
+
+	switch {
+	case limit <= 0 && serverLimit > 0:
+		limit = serverLimit
+	case limit <= 0:
+		limit = defaultHistoryLimit
+	case serverLimit > 0 && limit > serverLimit:
+		limit = serverLimit
+	}
+
+	return strconv.Itoa(limit)
+}
+
+// chathistory sends a CHATHISTORY subcommand, and returns the messages in the reply about target
+func (c *Client) chathistory(
+	ctx context.Context, target, subcommand string, params ...string,
+) ([]*event.Message, error) {
+	res, err := c.doChathistory(ctx, subcommand, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	capabilities := c.capabilities
+	c.mu.Unlock()
+
+	is := c.conn().ISupport
+	caps := capabilities.AvailableCaps()
+	currentNick := c.CurrentNick()
+	folded := is.Casefold(target)
+
+	out := historyMessages(res, event.BatchChathistory, func(batch *event.Batch) bool {
+		return len(batch.Params) > 0 && is.Casefold(batch.Params[0]) == folded
+	})
+
+	for _, msg := range out {
+		msg.SourceUser = user.FromMessage(msg.Raw, caps)
+		msg.CurrentNick = currentNick
+		msg.AvailableCaps = caps
+	}
+
+	return out, nil
+}
+
+// doChathistory sends a CHATHISTORY subcommand with Do, and checks the reply for errors
+func (c *Client) doChathistory(ctx context.Context, subcommand string, params ...string) (*Response, error) {
+	if !c.HasCap("draft/chathistory") && !c.HasCap("chathistory") {
+		return nil, fmt.Errorf("client.chathistory: %w", ErrNoChathistory)
+	}
+
+	res, err := c.Do(ctx, "CHATHISTORY", append([]string{subcommand}, params...)...)
+	if err != nil {
+		return nil, fmt.Errorf("client.chathistory: %w", err)
+	}
+
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("client.chathistory: %w", err)
+	}
+
+	return res, nil
+}
+
+// historyMessages returns the lines in res that are part of a batch of the given type, in order, and not including
+// the BATCH lines themselves. Only batches that match are included, to skip over anything else that happened to
+// arrive at the same time without labeled-response.
+func historyMessages(res *Response, batchType string, match func(*event.Batch) bool) []*event.Message {
+	batches := newBatchCollector()
+	out := []*event.Message{}
+
+	for _, msg := range res.Messages {
+		batch, _ := batches.add(msg)
+		if batch == nil || msg.Command == "BATCH" {
+			continue
+		}
+
+		// Messages in batches nested inside the history, such as multiline messages, are part of it too
+		outer := batch
+		for outer.Parent != nil && outer.Type != batchType {
+			outer = outer.Parent
+		}
+
+		if outer.Type == batchType && match(outer) {
+			out = append(out, &event.Message{Raw: msg, Batch: batch})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,257 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/event/function"
+	"awesome-dragon.science/go/irc/event/multi"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+type historyResult struct {
+	messages []*event.Message
+	err      error
+}
+
+// newHistoryClient creates a test client with chathistory, and the given extra capabilities
+func newHistoryClient(t *testing.T, caps ...string) (*Client, *testServer) {
+	t.Helper()
+
+	caps = append(caps, "draft/chathistory", "batch")
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name", RequestedCapabilities: caps}, caps...)
+
+	server.Expect("USER")
+	server.Send(
+		":irc.test 001 test :Welcome to the network test!user@host",
+		":irc.test 005 test CHATHISTORY=50 CASEMAPPING=ascii :are supported by this server",
+		":irc.test PING :isupport",
+	)
+	server.Expect("PONG")
+
+	return c, server
+}
+
+func waitHistory(t *testing.T, results <-chan historyResult) historyResult {
+	t.Helper()
+
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for chathistory")
+	}
+
+	return historyResult{}
+}
+
+func TestClient_ChathistoryLabelled(t *testing.T) {
+	t.Parallel()
+
+	c, server := newHistoryClient(t, "labeled-response")
+	results := make(chan historyResult, 1)
+
+	go func() {
+		messages, err := c.ChathistoryBefore(context.Background(), "#Chan", HistoryMsgID("abc"), 500)
+		results <- historyResult{messages: messages, err: err}
+	}()
+
+	line, err := ircmsg.ParseLine(server.Expect("@label="))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"BEFORE", "#Chan", "msgid=abc", "50"}; line.Command != "CHATHISTORY" ||
+		len(line.Params) != len(want) || line.Params[2] != want[2] || line.Params[3] != want[3] {
+		t.Fatalf("got %v, want CHATHISTORY %v", line.Params, want)
+	}
+
+	_, label := line.GetTag("label")
+	server.Send(
+		"@label="+label+" :irc.test BATCH +l labeled-response",
+		"@batch=l :irc.test BATCH +h chathistory #chan",
+		"@batch=h;msgid=1;time=2026-10-17T12:00:00.000Z :alice!a@h PRIVMSG #chan :one",
+		"@batch=h;msgid=2;time=2026-10-17T12:01:00.000Z :bob!b@h PRIVMSG #chan :two",
+		":irc.test BATCH -h",
+		":irc.test BATCH -l",
+	)
+
+	res := waitHistory(t, results)
+	if res.err != nil {
+		t.Fatalf("ChathistoryBefore() returned an error: %v", res.err)
+	}
+
+	if len(res.messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(res.messages))
+	}
+
+	first := res.messages[0]
+	if first.Raw.Params[1] != "one" || first.SourceUser.Name != "alice" || first.CurrentNick != "test" ||
+		first.Batch == nil || first.Batch.Type != event.BatchChathistory {
+		t.Errorf("first message = %+v", first)
+	}
+}
+
+func TestClient_ChathistoryFallback(t *testing.T) {
+	t.Parallel()
+
+	c, server := newHistoryClient(t)
+	results := make(chan historyResult, 1)
+
+	go func() {
+		messages, err := c.ChathistoryLatest(context.Background(), "bob", HistoryAny, 0)
+		results <- historyResult{messages: messages, err: err}
+	}()
+
+	server.Expect("CHATHISTORY LATEST bob * 50")
+	fence := server.Expect("PING do-")[len("PING "):]
+	server.Send(
+		":irc.test BATCH +split netsplit hub.test leaf.test",
+		":irc.test BATCH +h chathistory bob",
+		"@batch=split :carol!c@h QUIT :hub.test leaf.test",
+		"@batch=h :bob!b@h PRIVMSG test :hello",
+		":irc.test BATCH -h",
+		":irc.test BATCH -split",
+		":irc.test PONG irc.test "+fence,
+	)
+
+	res := waitHistory(t, results)
+	if res.err != nil {
+		t.Fatalf("ChathistoryLatest() returned an error: %v", res.err)
+	}
+
+	if len(res.messages) != 1 || res.messages[0].Raw.Params[1] != "hello" {
+		t.Errorf("messages = %+v, want just the history", res.messages)
+	}
+
+	go func() {
+		start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+		targets, err := c.ChathistoryTargets(context.Background(), start, start.Add(time.Hour), 10)
+
+		if err != nil || len(targets) != 1 || targets[0].Name != "#chan" ||
+			!targets[0].Latest.Equal(start.Add(time.Minute)) {
+			t.Errorf("ChathistoryTargets() = %+v, %v", targets, err)
+		}
+
+		results <- historyResult{}
+	}()
+
+	server.Expect("CHATHISTORY TARGETS timestamp=2026-10-17T12:00:00.000Z timestamp=2026-10-17T13:00:00.000Z 10")
+	fence = server.Expect("PING do-")[len("PING "):]
+	server.Send(
+		":irc.test BATCH +t draft/chathistory-targets",
+		"@batch=t :irc.test CHATHISTORY TARGETS #chan 2026-10-17T12:01:00.000Z",
+		":irc.test BATCH -t",
+		":irc.test PONG irc.test "+fence,
+	)
+
+	waitHistory(t, results)
+}
+
+func TestClient_ChathistoryErrors(t *testing.T) {
+	t.Parallel()
+
+	c, server := newHistoryClient(t)
+	results := make(chan historyResult, 1)
+
+	go func() {
+		messages, err := c.ChathistoryAround(context.Background(), "#chan", HistoryMsgID("nope"), 10)
+		results <- historyResult{messages: messages, err: err}
+	}()
+
+	server.Expect("CHATHISTORY AROUND #chan msgid=nope 10")
+	fence := server.Expect("PING do-")[len("PING "):]
+	server.Send(
+		":irc.test FAIL CHATHISTORY INVALID_MSGREFTYPE AROUND nope :Unknown msgid",
+		":irc.test PONG irc.test "+fence,
+	)
+
+	var replyErr *ReplyError
+	if res := waitHistory(t, results); !errors.As(res.err, &replyErr) || replyErr.Command != "FAIL" {
+		t.Errorf("ChathistoryAround() error = %v, want a FAIL", res.err)
+	}
+
+	plain, _ := newISupportClient(t, "CHATHISTORY=50")
+	_, err := plain.ChathistoryLatest(context.Background(), "#chan", HistoryAny, 10)
+	if !errors.Is(err, ErrNoChathistory) {
+		t.Errorf("ChathistoryLatest() error = %v, want %v", err, ErrNoChathistory)
+	}
+}
+
+func TestClient_ChathistoryNotLive(t *testing.T) {
+	t.Parallel()
+
+	c, server := newHistoryClient(t, "event-playback")
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+
+	batches := make(chan *event.Batch, 1)
+	syncHandler, synced := newSyncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command != "JOIN" && msg.Raw.Command != "PART" && msg.Raw.Command != "PRIVMSG" {
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		seen = append(seen, msg.Raw.Command)
+
+		return nil
+	})
+
+	handler := &multi.Handler{}
+	handler.AddHandlers(syncHandler, function.BatchFuncHandler(func(msg *event.Message) error {
+		batches <- msg.Batch
+
+		return nil
+	}))
+	c.SetMessageHandler(handler)
+
+	server.Send(
+		":test!user@host JOIN #chan",
+		":irc.test BATCH +hist chathistory #chan",
+		"@batch=hist;time=2026-10-16T12:00:00.000Z :test!old@old.host PART #chan :leaving",
+		"@batch=hist;time=2026-10-16T12:00:01.000Z :other!u@h PRIVMSG #chan :!command",
+		":irc.test BATCH -hist",
+	)
+	server.Sync(synced)
+
+	select {
+	case batch := <-batches:
+		if len(batch.Messages) != 2 {
+			t.Errorf("history batch has %d lines, want 2", len(batch.Messages))
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the history batch")
+	}
+
+	mu.Lock()
+	if want := []string{"JOIN"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("handler saw %v, want %v", seen, want)
+	}
+	mu.Unlock()
+
+	if _, ok := c.Channel("#chan"); !ok {
+		t.Error("replayed PART removed #chan from the state tracker")
+	}
+
+	c.mu.Lock()
+	_, rejoin := c.channels["#chan"]
+	c.mu.Unlock()
+
+	if !rejoin {
+		t.Error("replayed PART removed #chan from the channels to rejoin")
+	}
+
+	if u, ok := c.User("test"); ok && u.Host == "old.host" {
+		t.Errorf("replayed line changed our host to %q", u.Host)
+	}
+}
@@ -3,19 +3,22 @@ package client
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"awesome-dragon.science/go/irc/capab"
 	"awesome-dragon.science/go/irc/connection"
 	"awesome-dragon.science/go/irc/event"
 	"awesome-dragon.science/go/irc/event/irccommand"
+	"awesome-dragon.science/go/irc/logger"
 	"awesome-dragon.science/go/irc/numerics"
+	"awesome-dragon.science/go/irc/state"
 	"awesome-dragon.science/go/irc/user"
-	"github.com/op/go-logging"
+	"github.com/ergochat/irc-go/ircmsg"
 )
 
-var log = logging.MustGetLogger("irc-c") //nolint:gochecknoglobals // logger
-
 // Config is a startup configuration for a client instance
 type Config struct {
 	Connection     connection.Config
@@ -29,6 +32,23 @@ type Config struct {
 	SASLPassword string
 
 	RequestedCapabilities []string
+
+	// STS enables IRCv3 Strict Transport Security, which upgrades plaintext connections to TLS when the server
+	// asks. It is implied by setting STSStore. Capabilities are only negotiated when STS is enabled, or
+	// RequestedCapabilities is set.
+	STS bool
+	// STSStore stores STS policies between connections. If nil and STS is set, a capab.FileSTSStore in
+	// capab.DefaultSTSStorePath() is used
+	STSStore capab.STSStore
+
+	// Reconnect configures automatic reconnection. If nil, Run will return as soon as the connection is lost.
+	// On every reconnect, capability negotiation and SASL are redone, and any channels the client was in
+	// are rejoined.
+	Reconnect *ReconnectConfig
+
+	// Logger receives everything the client logs, with the network and nick added as fields. It is also used
+	// for the connection, unless Connection.Logger is set. If nil, logger.Default() is used.
+	Logger logger.Logger
 }
 
 // Client implements a full IRC client for use in bots. It does most of the work
@@ -38,73 +58,434 @@ type Client struct {
 	connection     *connection.Connection
 	internalEvents *irccommand.Handler
 	clientEvents   event.MessageHandler
+	state          *state.Tracker   // Channel state for the current connection
+	requests       *pendingRequests // Commands sent with Do on the current connection
 
 	currentNick string
 
 	capabilities *capab.Negotiator
 	config       *Config
+	log          *contextLogger
 	// outgoingEvents MessageHandler
+
+	registered     bool                       // Whether or not the current connection has seen RPL_WELCOME
+	rejoined       bool                       // Whether or not channels have been rejoined on the current connection
+	stsStore       capab.STSStore             // nil if STS is disabled
+	stsUpgradeHost string                     // Set with stsUpgradePort when a server asks to be upgraded to TLS
+	stsUpgradePort int                        // Set when the current connection must be upgraded to TLS
+	servers        *connection.ServerRotation // Shared between connections, so failover carries on across reconnects
+	channels       map[string]joinedChannel   // Channels we're in by casefolded name, to rejoin after reconnecting
+	joinKeys       map[string]string          // Keys sent by JoinWithKeys, by casefolded name, until the JOIN is seen
+	rawLog         bool                       // Whether raw logging is enabled, see ToggleRawLog
+	whoxToken      uint32                     // Last WHOX query token used, accessed atomically
+
+	statusCallbacks map[int]StatusFunc
+	lastStatusID    int
+
+	stopChan    chan struct{} // closed by Stop
+	stopMessage string        // The QUIT message given to Stop
+	stopOnce    sync.Once
+	done        chan struct{} // closed when Run returns
+	doneOnce    sync.Once
 }
 
 // New creates a new instance of Client.
 func New(config *Config) *Client {
-	conn := connection.NewConnection(&config.Connection)
 	out := &Client{
-		internalEvents: &irccommand.Handler{},
-		connection:     conn,
-		config:         config,
+		config:   config,
+		log:      &contextLogger{parent: logger.Or(config.Logger), nick: config.Nick},
+		servers:  config.Connection.Rotation,
+		channels: make(map[string]joinedChannel),
+		joinKeys: make(map[string]string),
+		rawLog:   config.Connection.RawLog,
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if out.servers == nil {
+		out.servers = connection.NewServerRotation(config.Connection.ServerList(), config.Connection.RandomiseServers)
+	}
+
+	out.stsStore = config.STSStore
+	if out.stsStore == nil && config.STS {
+		out.stsStore = out.defaultSTSStore()
 	}
 
-	out.capabilities = capab.New(&capab.Config{
-		ToRequest:    config.RequestedCapabilities,
-		SASL:         config.SASLUsername != "" && config.SASLPassword != "",
-		SASLUsername: config.SASLUsername,
-		SASLPassword: config.SASLPassword,
+	out.setupConnection()
+
+	return out
+}
+
+// setupConnection creates a fresh connection, capability negotiator, and internal event handlers.
+// It is called before every connection attempt, as none of them are reusable
+func (c *Client) setupConnection() {
+	internalEvents := &irccommand.Handler{}
+	immediateEvents := &irccommand.Handler{}
+	requests := &pendingRequests{}
+	conn := connection.NewConnection(c.connectionConfig())
+	conn.SetLagCallback(func(lag time.Duration) { c.emitStatus(StatusEvent{Type: StatusLag, Lag: lag}) })
+
+	// PINGs, capability negotiation, and replies to Do are handled straight from the read loop, so that they are never
+	// held up behind a slow handler
+	conn.SetImmediateHandler(func(msg *ircmsg.Message) {
+		requests.onMessage(msg)
+
+		if err := immediateEvents.OnMessage(&event.Message{Raw: msg}); err != nil {
+			c.log.Error("Error during immediate handling", "command", msg.Command, "error", err)
+		}
+	})
+
+	capabilities := capab.New(&capab.Config{
+		ToRequest:    c.config.RequestedCapabilities,
+		SASL:         c.config.SASLUsername != "" && c.config.SASLPassword != "",
+		SASLUsername: c.config.SASLUsername,
+		SASLPassword: c.config.SASLPassword,
 		SASLMech:     "PLAIN",
-	}, out.WriteIRC, &irccommand.SimpleHandler{Handler: out.internalEvents})
+		Logger:       c.log,
+
+		ListCapabilities: c.stsStore != nil,
+		OnOffered:        c.onCapsOffered(conn),
+	}, c.WriteIRC, &irccommand.SimpleHandler{Handler: immediateEvents})
 
-	out.internalEvents.AddCallback("PING", func(m *event.Message) error {
-		return out.WriteIRC("PONG", m.Raw.Params...)
+	immediateEvents.AddCallback("PING", func(m *event.Message) error {
+		return c.WriteIRC("PONG", m.Raw.Params...)
 	})
 
-	out.internalEvents.AddCallback(numerics.ERR_NICKNAMEINUSE, func(m *event.Message) error {
-		return out.WriteIRC("NICK", m.Raw.Params[1]+"_")
+	internalEvents.AddCallback(numerics.ERR_NICKNAMEINUSE, func(m *event.Message) error {
+		return c.WriteIRC("NICK", m.Raw.Params[1]+"_")
 	})
 
-	out.internalEvents.AddCallback(numerics.NICK, func(m *event.Message) error {
-		if m.SourceUser.Name != out.currentNick {
+	internalEvents.AddCallback(numerics.NICK, func(m *event.Message) error {
+		if m.SourceUser.Name != c.CurrentNick() {
 			return nil
 		}
 
-		out.mu.Lock()
-		out.currentNick = m.Raw.Params[len(m.Raw.Params)-1]
-		out.mu.Unlock()
+		newNick := m.Raw.Params[len(m.Raw.Params)-1]
+
+		c.mu.Lock()
+		c.currentNick = newNick
+		c.mu.Unlock()
+
+		c.log.setNick(newNick)
+
+		if source, err := ircmsg.ParseNUH(conn.Source()); err == nil {
+			source.Name = newNick
+			conn.SetSource(source.Canonical())
+		}
 
 		return nil
 	})
 
-	return out
+	internalEvents.AddCallback(numerics.RPL_ISUPPORT, func(*event.Message) error {
+		if network := conn.ISupport.Network(); network != "" {
+			c.log.setNetwork(network)
+		}
+
+		return nil
+	})
+
+	internalEvents.AddCallback(numerics.RPL_WELCOME, c.onWelcome)
+	internalEvents.AddCallback(numerics.RPL_ENDOFMOTD, c.onEndOfMOTD)
+	internalEvents.AddCallback(numerics.ERR_NOMOTD, c.onEndOfMOTD)
+	internalEvents.AddCallback(numerics.RPL_WELCOME, c.onSourceChange)
+	internalEvents.AddCallback(numerics.RPL_VISIBLEHOST, c.onSourceChange)
+	internalEvents.AddCallback("JOIN", c.onSourceChange)
+	internalEvents.AddCallback("JOIN", c.onChannelMembership)
+	internalEvents.AddCallback("PART", c.onChannelMembership)
+	internalEvents.AddCallback("KICK", c.onChannelMembership)
+	internalEvents.AddCallback("MODE", c.onChannelMembership)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn.SetRawLog(c.rawLog)
+
+	c.connection = conn
+	c.internalEvents = internalEvents
+	c.state = state.NewTracker(conn.ISupport)
+	c.requests = requests
+	c.capabilities = capabilities
+	c.registered = false
+	c.rejoined = false
+	c.currentNick = c.config.Nick
+	c.log.setNick(c.config.Nick)
+}
+
+func (c *Client) conn() *connection.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.connection
+}
+
+// joinedChannel is a channel we're in, and the key needed to join it again, if any
+type joinedChannel struct {
+	name string
+	key  string
+}
+
+func (c *Client) onWelcome(*event.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.registered = true
+
+	return nil
+}
+
+// onEndOfMOTD rejoins the channels we were in before reconnecting. This waits for the end of the MOTD, rather than
+// RPL_WELCOME, as by then the server has sent all of its ISUPPORT tokens, which JoinWithKeys checks channels against.
+func (c *Client) onEndOfMOTD(*event.Message) error {
+	c.mu.Lock()
+	if c.rejoined {
+		c.mu.Unlock()
+
+		return nil
+	}
+
+	c.rejoined = true
+
+	joined := make([]joinedChannel, 0, len(c.channels))
+	for _, channel := range c.channels {
+		joined = append(joined, channel)
+	}
+	c.mu.Unlock()
+
+	if len(joined) == 0 {
+		return nil
+	}
+
+	sort.Slice(joined, func(i, j int) bool { return joined[i].name < joined[j].name })
+
+	names, keys := make([]string, len(joined)), make([]string, len(joined))
+	for i, channel := range joined {
+		names[i], keys[i] = channel.name, channel.key
+	}
+
+	if err := c.JoinWithKeys(names, keys); err != nil {
+		return fmt.Errorf("could not rejoin channels: %w", err)
+	}
+
+	return nil
+}
+
+// onChannelMembership keeps track of the channels we're in, and their keys, so that they can be rejoined after
+// a reconnect
+func (c *Client) onChannelMembership(m *event.Message) error {
+	if len(m.Raw.Params) == 0 {
+		return nil
+	}
+
+	is := c.conn().ISupport
+	ourNick := is.Casefold(c.CurrentNick())
+	channel := m.Raw.Params[0]
+	folded := is.Casefold(channel)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch m.Raw.Command {
+	case "JOIN":
+		if is.Casefold(m.SourceUser.Name) != ourNick {
+			return nil
+		}
+
+		joined := joinedChannel{name: channel, key: c.channels[folded].key}
+		if key, ok := c.joinKeys[folded]; ok {
+			joined.key = key
+			delete(c.joinKeys, folded)
+		}
+
+		c.channels[folded] = joined
+
+	case "PART":
+		if is.Casefold(m.SourceUser.Name) == ourNick {
+			delete(c.channels, folded)
+		}
+
+	case "KICK":
+		if len(m.Raw.Params) > 1 && is.Casefold(m.Raw.Params[1]) == ourNick {
+			delete(c.channels, folded)
+		}
+
+	case "MODE":
+		joined, ok := c.channels[folded]
+		if !ok {
+			return nil
+		}
+
+		// The state tracker has already applied the change, some servers hide the key from those without ops
+		if state, ok := c.state.Channel(channel); ok {
+			if key, set := state.ModeParameter('k'); !set {
+				joined.key = ""
+			} else if key != "*" {
+				joined.key = key
+			}
+		}
+
+		c.channels[folded] = joined
+	}
+
+	return nil
 }
 
-// SetMessageHandler sets the callback handler for incoming IRC Messages
+// onSourceChange keeps the connection's idea of our nick!user@host up to date, so that it can work out how long
+// our lines are once relayed by the server
+func (c *Client) onSourceChange(m *event.Message) error {
+	conn := c.conn()
+	ourNick := c.CurrentNick()
+
+	switch m.Raw.Command {
+	case "JOIN":
+		if m.SourceUser.Name == ourNick && m.SourceUser.Host != "" {
+			conn.SetSource(m.Raw.Source)
+		}
+
+	case numerics.RPL_WELCOME:
+		// Most servers end the welcome message with our full mask, its a good first guess
+		words := strings.Fields(m.Raw.Params[len(m.Raw.Params)-1])
+		if len(words) == 0 {
+			return nil
+		}
+
+		if nuh, err := ircmsg.ParseNUH(words[len(words)-1]); err == nil && nuh.Host != "" && nuh.Name == ourNick {
+			conn.SetSource(nuh.Canonical())
+		}
+
+	case numerics.RPL_VISIBLEHOST:
+		if len(m.Raw.Params) < 2 {
+			return nil
+		}
+
+		if nuh, err := ircmsg.ParseNUH(conn.Source()); err == nil {
+			nuh.Host = m.Raw.Params[1]
+			if idx := strings.IndexByte(nuh.Host, '@'); idx != -1 {
+				// Some servers send user@host here
+				nuh.User, nuh.Host = nuh.Host[:idx], nuh.Host[idx+1:]
+			}
+
+			conn.SetSource(nuh.Canonical())
+		}
+	}
+
+	return nil
+}
+
+// SetMessageHandler sets the callback handler for incoming IRC Messages. If it also implements event.BatchHandler,
+// it is given every IRCv3 batch as a whole once the batch has ended
 func (c *Client) SetMessageHandler(handler event.MessageHandler) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.clientEvents = handler
 }
 
-// Run connects to IRC and handles messages until a disconnection occurs
+// Run connects to IRC and handles messages until a disconnection occurs. If Config.Reconnect is set,
+// Run will instead reconnect according to that policy, and only return once Stop is called, ctx is cancelled,
+// or the policy gives up (in which case the returned error wraps ErrReconnectGaveUp). If ctx is cancelled, the
+// returned error wraps ctx's error, after Stop, it is nil.
 func (c *Client) Run(ctx context.Context) error {
-	if err := c.connection.Connect(ctx); err != nil {
-		return fmt.Errorf("could not connect to IRC: %w", err)
+	defer c.doneOnce.Do(func() { close(c.done) })
+
+	if c.config.Reconnect != nil {
+		return c.runWithReconnect(ctx)
 	}
 
+	_, err := c.connectAndServe(ctx, 1)
+	c.emitStatus(StatusEvent{Type: StatusDisconnected, Attempt: 1, Err: err})
+
+	if err == nil {
+		err = c.runResult(ctx)
+	}
+
+	return err
+}
+
+// connectAndServe makes a single connection to IRC and handles it until it is closed. If the server requests
+// that the connection be upgraded to TLS, the upgraded connection is made and handled as part of the same attempt.
+// registered is true if the server accepted our registration during the connection.
+func (c *Client) connectAndServe(ctx context.Context, attempt int) (registered bool, err error) {
+	for {
+		registered, err = c.serveConnection(ctx, attempt)
+
+		if !c.stsUpgradePending() || ctx.Err() != nil || c.stopped() {
+			return registered, err
+		}
+	}
+}
+
+func (c *Client) serveConnection(ctx context.Context, attempt int) (registered bool, err error) {
+	c.setupConnection()
+
+	conn := c.conn()
+
+	c.emitStatus(StatusEvent{Type: StatusConnecting, Attempt: attempt})
+
+	if err := conn.Connect(ctx); err != nil {
+		return false, fmt.Errorf("could not connect to IRC: %w", err)
+	}
+
+	// A Stop that came in while we were connecting had nothing to stop, so it's done here instead
+	if c.stopped() {
+		c.mu.Lock()
+		message := c.stopMessage
+		c.mu.Unlock()
+
+		conn.Stop(message)
+
+		return false, nil
+	}
+
+	c.emitStatus(StatusEvent{Type: StatusConnected, Attempt: attempt})
+
 	// Connection complete, attach line handlers etc
 	go c.listenLoop(ctx)
 
-	c.capabilities.Negotiate()
+	// Registration can block on the server, so dont let it hold us up if the connection dies underneath it
+	registerCtx, cancelRegister := context.WithCancel(ctx)
+	defer cancelRegister()
 
-	c.currentNick = c.config.Nick
+	registerErr := make(chan error, 1)
+
+	go func() { registerErr <- c.register(registerCtx) }()
+
+	select {
+	case err = <-registerErr:
+		if err != nil {
+			conn.Stop("Registration failed")
+		}
+
+	case <-conn.Done():
+		// Waited for, so that a registration stuck on the old connection never outlives it across reconnects
+		cancelRegister()
+		<-registerErr
+	}
+
+	<-conn.Done()
+
+	if err == nil {
+		err = conn.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.registered, err
+}
+
+func (c *Client) register(ctx context.Context) error {
+	c.mu.Lock()
+	capabilities := c.capabilities
+	c.mu.Unlock()
+
+	if err := capabilities.NegotiateContext(ctx); err != nil {
+		return fmt.Errorf("could not negotiate capabilities: %w", err)
+	}
+
+	if c.stsUpgradePending() {
+		c.conn().Stop("Upgrading to TLS")
+
+		return nil
+	}
 
 	if c.config.ServerPassword != "" {
 		if err := c.WriteIRC("PASS", c.config.ServerPassword); err != nil {
@@ -120,13 +501,28 @@ func (c *Client) Run(ctx context.Context) error {
 		return err
 	}
 
-	<-c.connection.Done()
-
 	return nil
 }
 
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Client) listenLoop(ctx context.Context) {
+	c.mu.Lock()
 	lineChan := c.connection.LineChan()
+	internalEvents := c.internalEvents
+	tracker := c.state
+	capabilities := c.capabilities
+	c.mu.Unlock()
+
+	batches := newBatchCollector()
+
 loop:
 	for {
 		select {
@@ -139,28 +535,56 @@ loop:
 			clientHandler := c.clientEvents
 			c.mu.Unlock()
 
-			sourceUser := user.FromMessage(line, c.capabilities.AvailableCaps())
+			sourceUser := user.FromMessage(line, capabilities.AvailableCaps())
+			batch, batchFinished := batches.add(line)
 
 			ev := &event.Message{
 				Raw:           line,
 				SourceUser:    sourceUser,
-				AvailableCaps: c.capabilities.AvailableCaps(),
+				CurrentNick:   c.CurrentNick(),
+				AvailableCaps: capabilities.AvailableCaps(),
+				Batch:         batch,
 			}
 
-			if err := c.internalEvents.OnMessage(ev); err != nil {
-				log.Criticalf("Error during internal handling of %v: %s", ev.Raw, err)
+			// Replayed history is only given to handlers as a whole batch, and to Chathistory callers. Handling it
+			// line by line would apply old JOINs, PARTs, and so on to the current state, and rerun old commands
+			historical := ev.Historical()
+
+			// State is updated first, so that everything after sees the result of this line
+			if !historical {
+				if err := tracker.OnMessage(ev); err != nil {
+					c.log.Error("Error during state tracking", "command", line.Command, "line", ev.Raw, "error", err)
+				}
+
+				if err := internalEvents.OnMessage(ev); err != nil {
+					c.log.Error("Error during internal handling", "command", line.Command, "line", ev.Raw, "error", err)
+				}
 			}
 
 			pubEv := &event.Message{
 				Raw:           line,
 				SourceUser:    sourceUser,
 				CurrentNick:   c.CurrentNick(),
-				AvailableCaps: c.capabilities.AvailableCaps(),
+				AvailableCaps: capabilities.AvailableCaps(),
+				Batch:         batch,
 			}
 
-			if clientHandler != nil {
+			for outer := batch; outer != nil; outer = outer.Parent {
+				outer.Lines = append(outer.Lines, pubEv)
+			}
+
+			// Batch handlers get lines in batches with OnBatch, so that they don't see them twice
+			batchHandler, wantsBatches := clientHandler.(event.BatchHandler)
+
+			if clientHandler != nil && !historical && !(wantsBatches && batch != nil) {
 				if err := clientHandler.OnMessage(pubEv); err != nil {
-					log.Warningf("Error during client handling of %v: %s", ev.Raw, err)
+					c.log.Warn("Error during client handling", "command", line.Command, "line", ev.Raw, "error", err)
+				}
+			}
+
+			if wantsBatches && batchFinished {
+				if err := batchHandler.OnBatch(pubEv); err != nil {
+					c.log.Warn("Error during client batch handling", "type", batch.Type, "line", ev.Raw, "error", err)
 				}
 			}
 
@@ -172,21 +596,31 @@ loop:
 
 // WriteIRC constructs an IRC line and sends it to the server
 func (c *Client) WriteIRC(command string, params ...string) error {
-	if err := c.connection.WriteLine(command, params...); err != nil {
+	if err := c.conn().WriteLine(command, params...); err != nil {
 		return fmt.Errorf("client.writeirc: %w", err)
 	}
 
 	return nil
 }
 
+// WriteIRCWithPriority is like WriteIRC, but sends the line at the given priority rather than one picked
+// based on its command. Priorities only have an effect when flood control is enabled in Config.Connection
+func (c *Client) WriteIRCWithPriority(priority connection.Priority, command string, params ...string) error {
+	if err := c.conn().WriteLineWithPriority(priority, nil, command, params...); err != nil {
+		return fmt.Errorf("client.writeircwithpriority: %w", err)
+	}
+
+	return nil
+}
+
 // Write implements io.Writer. See WriteIRC for a nicer frontend for creating IRC lines
 func (c *Client) Write(data []byte) (int, error) {
 	//nolint:wrapcheck // Its still me.
-	return c.connection.Write(data)
+	return c.conn().Write(data)
 }
 
 // WriteString implements io.StringWriter. See WriteIRC for a nicer frontend
 func (c *Client) WriteString(s string) (int, error) {
 	//nolint:wrapcheck // Its still me.
-	return c.connection.WriteString(s)
+	return c.conn().WriteString(s)
 }
@@ -0,0 +1,571 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/capab"
+	"awesome-dragon.science/go/irc/connection"
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/event/function"
+)
+
+// testServer is the server side of an in memory connection to a Client
+type testServer struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+// newTestClient creates a Client connected to an in memory testServer, and starts it running.
+// The server offers and acknowledges the given capabilities. The client is stopped when the test ends
+func newTestClient(t *testing.T, config *Config, caps ...string) (*Client, *testServer) {
+	t.Helper()
+
+	clientSide, serverSide := net.Pipe()
+
+	if config.Nick == "" {
+		config.Nick = "test"
+	}
+
+	config.Connection.Dial = connection.SingleConnDialer(clientSide)
+
+	server := &testServer{t: t, conn: serverSide, lines: make(chan string, 100)}
+
+	go func() {
+		defer close(server.lines)
+
+		reader := bufio.NewReader(serverSide)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimRight(line, "\r\n")
+
+			switch {
+			case strings.HasPrefix(line, "CAP LS"):
+				_, _ = serverSide.Write([]byte(":irc.test CAP * LS :" + strings.Join(caps, " ") + "\r\n"))
+			case strings.HasPrefix(line, "CAP REQ"):
+				_, _ = serverSide.Write([]byte(":irc.test CAP * ACK :" + strings.SplitN(line, ":", 2)[1] + "\r\n"))
+			}
+
+			server.lines <- line
+		}
+	}()
+
+	c := New(config)
+
+	go func() { _ = c.Run(context.Background()) }()
+
+	t.Cleanup(func() {
+		serverSide.Close()
+		c.WaitForExit()
+	})
+
+	return c, server
+}
+
+// Send sends the given lines to the client
+func (s *testServer) Send(lines ...string) {
+	s.t.Helper()
+
+	for _, l := range lines {
+		if _, err := s.conn.Write([]byte(l + "\r\n")); err != nil {
+			s.t.Fatalf("could not send line to client: %s", err)
+		}
+	}
+}
+
+// Expect reads lines from the client until one starts with prefix, which is returned
+func (s *testServer) Expect(prefix string) string {
+	s.t.Helper()
+
+	timeout := time.After(time.Second * 5)
+
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("connection closed while waiting for %q", prefix)
+			}
+
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+
+		case <-timeout:
+			s.t.Fatalf("timed out waiting for %q", prefix)
+		}
+	}
+}
+
+// syncNotice is sent by testServer.Sync, and noticed by handlers from newSyncHandler
+const syncNotice = ":irc.test NOTICE test :sync"
+
+// newSyncHandler returns a handler that passes every message on to next, if it is not nil, and a channel that is sent
+// to whenever it sees the NOTICE sent by testServer.Sync
+func newSyncHandler(next function.FuncHandler) (function.FuncHandler, <-chan struct{}) {
+	synced := make(chan struct{}, 1)
+
+	return func(msg *event.Message) error {
+		if msg.Raw.Command == "NOTICE" && len(msg.Raw.Params) == 2 && msg.Raw.Params[1] == "sync" {
+			synced <- struct{}{}
+
+			return nil
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		return next(msg)
+	}, synced
+}
+
+// Sync waits for the client to handle every line sent before it, using a handler from newSyncHandler. A PING cannot
+// be used for this, as it is answered as soon as it is read, before the lines ahead of it reach the handlers.
+func (s *testServer) Sync(synced <-chan struct{}) {
+	s.t.Helper()
+	s.Send(syncNotice)
+
+	select {
+	case <-synced:
+	case <-time.After(time.Second * 5):
+		s.t.Fatal("timed out waiting for the client to handle the sync NOTICE")
+	}
+}
+
+func TestClient_Pipe(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Nick: "test", Username: "user", Realname: "real name"})
+
+	handler, synced := newSyncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" {
+			return c.Reply(msg, "pong")
+		}
+
+		return nil
+	})
+	c.SetMessageHandler(handler)
+
+	if line := server.Expect("NICK"); line != "NICK test" {
+		t.Errorf("got %q, want %q", line, "NICK test")
+	}
+
+	if line := server.Expect("USER"); line != "USER user * * :real name" {
+		t.Errorf("got %q, want %q", line, "USER user * * :real name")
+	}
+
+	server.Send(":irc.test 001 test :Welcome", ":irc.test PING :12345")
+
+	if line := server.Expect("PONG"); line != "PONG 12345" {
+		t.Errorf("got %q, want %q", line, "PONG 12345")
+	}
+
+	server.Send(":someone!u@h PRIVMSG #chan :ping")
+
+	if line := server.Expect("PRIVMSG"); line != "PRIVMSG #chan pong" {
+		t.Errorf("got %q, want %q", line, "PRIVMSG #chan pong")
+	}
+
+	server.Send(":test!user@h NICK newnick")
+	server.Sync(synced)
+
+	if nick := c.CurrentNick(); nick != "newnick" {
+		t.Errorf("CurrentNick() = %q, want %q", nick, "newnick")
+	}
+
+	go c.Stop("bye")
+
+	if line := server.Expect("QUIT"); line != "QUIT bye" {
+		t.Errorf("got %q, want %q", line, "QUIT bye")
+	}
+}
+
+func TestClient_NoCapabilitiesRequested(t *testing.T) {
+	t.Parallel()
+
+	_, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+
+	// Without requested capabilities or STS, there is nothing to negotiate
+	select {
+	case line := <-server.lines:
+		if line != "NICK test" {
+			t.Errorf("first line = %q, want %q", line, "NICK test")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for NICK")
+	}
+}
+
+func TestClient_WriteIRCWithPriority(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{
+		Username: "user",
+		Realname: "real name",
+		Connection: connection.Config{
+			Flood: &connection.FloodConfig{Limiter: connection.NewTokenBucket(1, time.Millisecond*20)},
+		},
+	})
+
+	server.Expect("USER")
+
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := c.SendMessage("#chan", msg); err != nil {
+			t.Fatalf("SendMessage() error = %s", err)
+		}
+	}
+
+	if err := c.WriteIRCWithPriority(connection.PriorityModeration, "PRIVMSG", "#chan", "urgent"); err != nil {
+		t.Fatalf("WriteIRCWithPriority() error = %s", err)
+	}
+
+	got := []string{}
+	for i := 0; i < 4; i++ {
+		got = append(got, server.Expect("PRIVMSG"))
+	}
+
+	// The first line may already be waiting on the limiter, but nothing else should be
+	if got[3] != "PRIVMSG #chan three" || got[2] != "PRIVMSG #chan two" {
+		t.Errorf("lines sent in order %q, want urgent line before other chat", got)
+	}
+}
+
+func TestClient_SourceTracking(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	handler, synced := newSyncHandler(nil)
+	c.SetMessageHandler(handler)
+
+	server.Expect("USER")
+	server.Send(":irc.test 001 test :Welcome to the network test!user@some.host")
+	server.Send(":irc.test 396 test cloaked.host :is now your displayed host")
+	server.Send(":test!user@cloaked.host NICK other")
+	server.Sync(synced)
+
+	if got, want := c.conn().Source(), "other!user@cloaked.host"; got != want {
+		t.Errorf("Source() = %q, want %q", got, want)
+	}
+}
+
+func TestClient_ToggleRawLog(t *testing.T) {
+	t.Parallel()
+
+	records := &bytes.Buffer{}
+	sink := connection.NewWriterSink(records)
+
+	c, server := newTestClient(t, &Config{
+		Username:   "user",
+		Realname:   "real name",
+		Connection: connection.Config{RawLogSinks: []connection.RawLogSink{sink}},
+	})
+
+	handler, synced := newSyncHandler(nil)
+	c.SetMessageHandler(handler)
+
+	server.Expect("USER")
+
+	// Run with -race, this used to race with the connection reading the setting
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			c.ToggleRawLog()
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		server.Send(":irc.test PING :flood")
+	}
+
+	<-done
+
+	c.ToggleRawLog()
+	server.Sync(synced)
+	c.ToggleRawLog()
+
+	if !strings.Contains(records.String(), ">> "+syncNotice) {
+		t.Errorf("raw log did not contain the sync NOTICE:\n%s", records.String())
+	}
+}
+
+var errTest = errors.New("test error")
+
+// logEntry is a single message logged to a recordingLogger
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger is a logger.Logger that keeps everything logged to it
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (r *recordingLogger) log(level, msg string, args []interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[fmt.Sprint(args[i])] = args[i+1]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (r *recordingLogger) Debug(msg string, args ...interface{}) { r.log("debug", msg, args) }
+func (r *recordingLogger) Info(msg string, args ...interface{})  { r.log("info", msg, args) }
+func (r *recordingLogger) Warn(msg string, args ...interface{})  { r.log("warn", msg, args) }
+func (r *recordingLogger) Error(msg string, args ...interface{}) { r.log("error", msg, args) }
+
+// find returns the last entry with the given message
+func (r *recordingLogger) find(msg string) (logEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		if r.entries[i].msg == msg {
+			return r.entries[i], true
+		}
+	}
+
+	return logEntry{}, false
+}
+
+func TestClient_Logger(t *testing.T) {
+	t.Parallel()
+
+	log := &recordingLogger{}
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name", Logger: log})
+
+	handler, synced := newSyncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" {
+			return errTest
+		}
+
+		return nil
+	})
+	c.SetMessageHandler(handler)
+
+	server.Expect("USER")
+	server.Send(
+		":irc.test 001 test :Welcome",
+		":irc.test 005 test NETWORK=TestNet :are supported by this server",
+		":test!user@h NICK newnick",
+		":someone!u@h PRIVMSG #chan :hi",
+	)
+	server.Sync(synced)
+
+	entry, ok := log.find("Error during client handling")
+	if !ok {
+		t.Fatalf("client handler error was not logged, got %v", log.entries)
+	}
+
+	want := map[string]interface{}{"network": "TestNet", "nick": "newnick", "command": "PRIVMSG", "error": errTest}
+	for key, value := range want {
+		if entry.fields[key] != value {
+			t.Errorf("field %q = %v, want %v", key, entry.fields[key], value)
+		}
+	}
+
+	// The connection should use the client's logger, with the server added
+	entry, ok = log.find("Opening connection")
+	if !ok {
+		t.Fatalf("connection did not log to the client's logger")
+	}
+
+	if entry.fields["server"] == nil {
+		t.Errorf("connection log entry has no server field: %v", entry.fields)
+	}
+}
+
+func TestClient_SlowHandlerDoesNotDelayPING(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	release := make(chan struct{})
+
+	c.SetMessageHandler(function.FuncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" {
+			<-release
+		}
+
+		return nil
+	}))
+
+	defer close(release)
+
+	server.Expect("USER")
+	server.Send(":someone!u@h PRIVMSG #chan :slow", ":irc.test PING :still-here")
+
+	if line := server.Expect("PONG"); line != "PONG still-here" {
+		t.Errorf("got %q, want %q", line, "PONG still-here")
+	}
+
+	// The PING is counted once it has been queued, just after it is answered
+	for deadline := time.Now().Add(time.Second * 5); c.InboundStats().Received < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if stats := c.InboundStats(); stats.Received < 2 {
+		t.Errorf("InboundStats().Received = %d, want at least 2", stats.Received)
+	}
+}
+
+func TestClient_ChannelState(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	synced := make(chan struct{}, 1)
+
+	c.SetMessageHandler(function.FuncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" {
+			synced <- struct{}{}
+		}
+
+		return nil
+	}))
+
+	server.Expect("USER")
+	server.Send(
+		":irc.test 001 test :Welcome",
+		":irc.test 005 test PREFIX=(ov)@+ CASEMAPPING=ascii :are supported by this server",
+		":test!user@host JOIN #Chan",
+		":irc.test 353 test = #Chan :@test +other",
+		":irc.test 366 test #Chan :End of /NAMES list.",
+		":irc.test 332 test #Chan :A topic",
+		":other!u@h PRIVMSG #chan :sync",
+	)
+
+	select {
+	case <-synced:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the client to handle the lines")
+	}
+
+	channel, ok := c.Channel("#CHAN")
+	if !ok {
+		t.Fatal("Channel(#CHAN) not found")
+	}
+
+	if channel.Topic.Text != "A topic" {
+		t.Errorf("Topic.Text = %q, want %q", channel.Topic.Text, "A topic")
+	}
+
+	if m, ok := channel.Member("Other"); !ok || m.Prefix() != "+" {
+		t.Errorf("Member(Other) = %+v, %t, want voiced member", m, ok)
+	}
+
+	if u, ok := c.User("OTHER"); !ok || u.Mask() != "other!u@h" {
+		t.Errorf("User(OTHER) = %+v, %t, want other!u@h", u, ok)
+	}
+}
+
+func TestClient_StopWhileDialing(t *testing.T) {
+	t.Parallel()
+
+	clientSide, serverSide := net.Pipe()
+	defer serverSide.Close()
+
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+
+	c := New(&Config{
+		Nick:     "test",
+		Username: "user",
+		Realname: "real name",
+		STSStore: &capab.MemorySTSStore{},
+		Connection: connection.Config{
+			Dial: func(context.Context, string) (net.Conn, error) {
+				close(dialing)
+				<-release
+
+				return clientSide, nil
+			},
+		},
+	})
+
+	runErr := make(chan error, 1)
+
+	go func() { runErr <- c.Run(context.Background()) }()
+
+	<-dialing
+	c.Stop("bye")
+	close(release)
+
+	line, err := bufio.NewReader(serverSide).ReadString('\n')
+	if err != nil {
+		t.Fatalf("could not read from the client: %v", err)
+	}
+
+	if line = strings.TrimRight(line, "\r\n"); line != "QUIT bye" {
+		t.Errorf("first line = %q, want %q", line, "QUIT bye")
+	}
+
+	serverSide.Close()
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run() returned an error: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Run did not return after Stop")
+	}
+}
+
+func TestClient_FullQueueDoesNotDelayPING(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{
+		Username:   "user",
+		Realname:   "real name",
+		Connection: connection.Config{Inbound: &connection.InboundConfig{QueueSize: 2}},
+	})
+	release := make(chan struct{})
+
+	c.SetMessageHandler(function.FuncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" {
+			<-release
+		}
+
+		return nil
+	}))
+
+	defer close(release)
+
+	server.Expect("USER")
+
+	for i := 0; i < 10; i++ {
+		server.Send(fmt.Sprintf(":someone!u@h PRIVMSG #chan :slow %d", i))
+	}
+
+	server.Send(":irc.test PING :still-here")
+
+	if line := server.Expect("PONG"); line != "PONG still-here" {
+		t.Errorf("got %q, want %q", line, "PONG still-here")
+	}
+
+	if stats := c.InboundStats(); stats.Dropped != 0 || stats.HighWater < 2 {
+		t.Errorf("InboundStats() = %+v, want lines past the queue size kept", stats)
+	}
+}
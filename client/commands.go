@@ -0,0 +1,342 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"awesome-dragon.science/go/irc/isupport"
+	"awesome-dragon.science/go/irc/util"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Errors returned by the command helpers when ISUPPORT says that what was asked for is not possible.
+// They are wrapped with details, use errors.Is to check for them.
+var (
+	ErrNoTargets       = errors.New("no targets given")
+	ErrNotChannel      = errors.New("not a valid channel name")
+	ErrChannelTooLong  = errors.New("channel name too long")
+	ErrTooManyChannels = errors.New("too many channels")
+	ErrTooManyKeys     = errors.New("more keys than channels")
+	ErrInvalidNick     = errors.New("not a valid nick")
+	ErrNickTooLong     = errors.New("nick too long")
+)
+
+const (
+	defaultChannelTypes   = "#&"     // Used when the server doesn't send CHANTYPES
+	invalidNickCharacters = " ,*?!@" // Characters that can never be in a nick
+)
+
+// Join joins the given channels, using as few lines as possible. See JoinWithKeys
+func (c *Client) Join(channels ...string) error {
+	return c.JoinWithKeys(channels, nil)
+}
+
+// JoinWithKeys joins the given channels, using keys[i] as the key for channels[i]. There may be fewer keys than
+// channels, and empty keys are ignored.
+//
+// Every channel is checked against CHANTYPES, CHANNELLEN, and CHANLIMIT before anything is sent. Channels are
+// then sent in batches that fit within TARGMAX and the maximum line length.
+func (c *Client) JoinWithKeys(channels, keys []string) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("client.join: %w", ErrNoTargets)
+	}
+
+	if len(keys) > len(channels) {
+		return fmt.Errorf("client.join: %w: %d keys for %d channels", ErrTooManyKeys, len(keys), len(channels))
+	}
+
+	is := c.conn().ISupport
+
+	// Keys are matched to channels by position, so channels with keys must come first
+	var keyed, unkeyed []string
+
+	for i, name := range channels {
+		if err := checkChannel(is, name); err != nil {
+			return fmt.Errorf("client.join: %w", err)
+		}
+
+		if i < len(keys) && keys[i] != "" {
+			keyed = append(keyed, name)
+		} else {
+			unkeyed = append(unkeyed, name)
+		}
+	}
+
+	if err := c.checkChanLimit(is, channels); err != nil {
+		return fmt.Errorf("client.join: %w", err)
+	}
+
+	keyFor := make(map[string]string, len(keys))
+
+	c.mu.Lock()
+	for i, key := range keys {
+		keyFor[channels[i]] = key
+
+		// Remembered so that the channel can be rejoined with the same key after a reconnect
+		if key != "" {
+			c.joinKeys[is.Casefold(channels[i])] = key
+		}
+	}
+	c.mu.Unlock()
+
+	ordered := make([]string, 0, len(channels))
+	ordered = append(append(ordered, keyed...), unkeyed...)
+
+	lines, err := c.batchLines(len(ordered), targetLimit(is, "JOIN"), func(start, end int) *ircmsg.Message {
+		batch := ordered[start:end]
+		batchKeys := make([]string, 0, len(batch))
+
+		for _, name := range batch {
+			if key := keyFor[name]; key != "" {
+				batchKeys = append(batchKeys, key)
+			}
+		}
+
+		params := []string{strings.Join(batch, ",")}
+		if len(batchKeys) > 0 {
+			params = append(params, strings.Join(batchKeys, ","))
+		}
+
+		msg := ircmsg.MakeMessage(nil, "", "JOIN", params...)
+
+		return &msg
+	})
+	if err != nil {
+		return fmt.Errorf("client.join: %w", err)
+	}
+
+	return c.writeLines(lines)
+}
+
+// Part leaves the given channels, using as few lines as possible
+func (c *Client) Part(channels ...string) error {
+	return c.PartWithReason("", channels...)
+}
+
+// PartWithReason leaves the given channels with a reason. Channels are checked against CHANTYPES, and sent in
+// batches that fit within TARGMAX and the maximum line length.
+func (c *Client) PartWithReason(reason string, channels ...string) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("client.part: %w", ErrNoTargets)
+	}
+
+	is := c.conn().ISupport
+
+	for _, name := range channels {
+		if err := checkChannel(is, name); err != nil {
+			return fmt.Errorf("client.part: %w", err)
+		}
+	}
+
+	lines, err := c.batchLines(len(channels), targetLimit(is, "PART"), func(start, end int) *ircmsg.Message {
+		params := []string{strings.Join(channels[start:end], ",")}
+		if reason != "" {
+			params = append(params, reason)
+		}
+
+		msg := ircmsg.MakeMessage(nil, "", "PART", params...)
+
+		return &msg
+	})
+	if err != nil {
+		return fmt.Errorf("client.part: %w", err)
+	}
+
+	return c.writeLines(lines)
+}
+
+// Quit disconnects from the server with the given reason, if any. Unlike Stop, the client will reconnect
+// afterwards if a reconnect policy is configured.
+func (c *Client) Quit(reason string) error {
+	if reason == "" {
+		return c.WriteIRC("QUIT")
+	}
+
+	return c.WriteIRC("QUIT", reason)
+}
+
+// Kick kicks nick from channel. The reason is truncated to KICKLEN.
+func (c *Client) Kick(channel, nick, reason string) error {
+	is := c.conn().ISupport
+
+	if err := checkChannel(is, channel); err != nil {
+		return fmt.Errorf("client.kick: %w", err)
+	}
+
+	if err := checkNick(is, nick); err != nil {
+		return fmt.Errorf("client.kick: %w", err)
+	}
+
+	params := []string{channel, nick}
+	if reason != "" {
+		params = append(params, util.TruncateByteLength(reason, is.MaxKickLen()))
+	}
+
+	return c.WriteIRC("KICK", params...)
+}
+
+// Nick changes our nick. It is checked against NICKLEN, and for characters that are never valid in nicks.
+func (c *Client) Nick(newNick string) error {
+	if err := checkNick(c.conn().ISupport, newNick); err != nil {
+		return fmt.Errorf("client.nick: %w", err)
+	}
+
+	return c.WriteIRC("NICK", newNick)
+}
+
+// Topic sets the topic of channel. The topic is truncated to TOPICLEN.
+func (c *Client) Topic(channel, topic string) error {
+	is := c.conn().ISupport
+
+	if err := checkChannel(is, channel); err != nil {
+		return fmt.Errorf("client.topic: %w", err)
+	}
+
+	return c.WriteIRC("TOPIC", channel, util.TruncateByteLength(topic, is.MaxTopicLen()))
+}
+
+// Invite invites nick to channel
+func (c *Client) Invite(nick, channel string) error {
+	is := c.conn().ISupport
+
+	if err := checkNick(is, nick); err != nil {
+		return fmt.Errorf("client.invite: %w", err)
+	}
+
+	if err := checkChannel(is, channel); err != nil {
+		return fmt.Errorf("client.invite: %w", err)
+	}
+
+	return c.WriteIRC("INVITE", nick, channel)
+}
+
+// channelTypes returns the channel prefixes from CHANTYPES, or the RFC defaults if it is unset
+func channelTypes(is *isupport.ISupport) string {
+	if types, ok := is.GetToken("CHANTYPES"); ok {
+		return types
+	}
+
+	return defaultChannelTypes
+}
+
+// checkChannel checks that name is a channel according to CHANTYPES, and fits within CHANNELLEN
+func checkChannel(is *isupport.ISupport, name string) error {
+	if name == "" || !strings.ContainsRune(channelTypes(is), rune(name[0])) || strings.ContainsAny(name, " ,\x07") {
+		return fmt.Errorf("%w: %q", ErrNotChannel, name)
+	}
+
+	if limit := is.MaxChanLen(); limit > 0 && len(name) > limit {
+		return fmt.Errorf("%w: %q is longer than %d", ErrChannelTooLong, name, limit)
+	}
+
+	return nil
+}
+
+// checkNick checks that nick could be a nick, and fits within NICKLEN
+func checkNick(is *isupport.ISupport, nick string) error {
+	if nick == "" || strings.ContainsAny(nick, invalidNickCharacters) ||
+		strings.ContainsRune(":$"+channelTypes(is), rune(nick[0])) {
+		return fmt.Errorf("%w: %q", ErrInvalidNick, nick)
+	}
+
+	if limit := is.MaxNickLen(); limit > 0 && len(nick) > limit {
+		return fmt.Errorf("%w: %q is longer than %d", ErrNickTooLong, nick, limit)
+	}
+
+	return nil
+}
+
+// checkChanLimit checks that joining channels would not put us in more channels than CHANLIMIT allows
+func (c *Client) checkChanLimit(is *isupport.ISupport, channels []string) error {
+	limits := is.ChanLimit()
+	if len(limits) == 0 {
+		return nil
+	}
+
+	tracker := c.State()
+	counts := make(map[string]int, len(limits))
+	seen := make(map[string]bool)
+
+	count := func(name string) {
+		folded := is.Casefold(name)
+		if seen[folded] {
+			return
+		}
+
+		seen[folded] = true
+
+		for prefixes := range limits {
+			if strings.ContainsRune(prefixes, rune(name[0])) {
+				counts[prefixes]++
+			}
+		}
+	}
+
+	for _, name := range tracker.Channels() {
+		count(name)
+	}
+
+	for _, name := range channels {
+		count(name)
+	}
+
+	for prefixes, limit := range limits {
+		if limit >= 0 && counts[prefixes] > limit {
+			return fmt.Errorf("%w: CHANLIMIT allows %d %q channels", ErrTooManyChannels, limit, prefixes)
+		}
+	}
+
+	return nil
+}
+
+// targetLimit returns the most targets command may have at once according to TARGMAX, or 0 if there is no limit
+func targetLimit(is *isupport.ISupport, command string) int {
+	if limit, ok := is.MaxCommandTargets()[strings.ToLower(command)]; ok && limit > 0 {
+		return limit
+	}
+
+	return 0
+}
+
+// batchLines builds as few lines as possible covering count targets. build creates a line with the targets from
+// start to end. Every line has at most limit targets, unless limit is 0, and fits within the maximum line length.
+func (c *Client) batchLines(count, limit int, build func(start, end int) *ircmsg.Message) ([]*ircmsg.Message, error) {
+	conn := c.conn()
+
+	var out []*ircmsg.Message
+
+	for start := 0; start < count; {
+		end := start + 1
+
+		msg := build(start, end)
+		if err := conn.CheckLength(msg); err != nil {
+			return nil, err //nolint:wrapcheck // Its wrapped by the caller
+		}
+
+		for end < count && (limit == 0 || end-start < limit) {
+			next := build(start, end+1)
+			if conn.CheckLength(next) != nil {
+				break
+			}
+
+			msg, end = next, end+1
+		}
+
+		out = append(out, msg)
+		start = end
+	}
+
+	return out, nil
+}
+
+// writeLines sends each of lines in turn
+func (c *Client) writeLines(lines []*ircmsg.Message) error {
+	for _, line := range lines {
+		if err := c.WriteIRC(line.Command, line.Params...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
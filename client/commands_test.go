@@ -0,0 +1,161 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newISupportClient creates a test client that has seen the given ISUPPORT tokens
+func newISupportClient(t *testing.T, tokens string) (*Client, *testServer) {
+	t.Helper()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+
+	server.Expect("USER")
+	server.Send(
+		":irc.test 001 test :Welcome to the network test!user@host",
+		":irc.test 005 test "+tokens+" :are supported by this server",
+		":irc.test PING :isupport",
+	)
+	server.Expect("PONG")
+
+	return c, server
+}
+
+func TestClient_Join(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "CHANTYPES=#& TARGMAX=JOIN:2,PART:")
+
+	if err := c.JoinWithKeys([]string{"#a", "#b", "&c"}, []string{"", "key"}); err != nil {
+		t.Fatalf("JoinWithKeys() returned an error: %v", err)
+	}
+
+	// Keyed channels first, at most two per line
+	if line := server.Expect("JOIN"); line != "JOIN #b,#a key" {
+		t.Errorf("first JOIN = %q, want %q", line, "JOIN #b,#a key")
+	}
+
+	if line := server.Expect("JOIN"); line != "JOIN &c" {
+		t.Errorf("second JOIN = %q, want %q", line, "JOIN &c")
+	}
+
+	channels := make([]string, 60)
+	for i := range channels {
+		channels[i] = "#" + strings.Repeat("x", 20) + string(rune('A'+i%26)) + string(rune('A'+i/26))
+	}
+
+	if err := c.Part(channels...); err != nil {
+		t.Fatalf("Part() returned an error: %v", err)
+	}
+
+	// No target limit for PART, so it is only split by length
+	parted := 0
+
+	for parted < len(channels) {
+		line := server.Expect("PART")
+		if len(line) > 512 {
+			t.Errorf("PART line is %d bytes long", len(line))
+		}
+
+		parted += len(strings.Split(strings.TrimPrefix(line, "PART "), ","))
+	}
+
+	if parted != len(channels) {
+		t.Errorf("parted %d channels, want %d", parted, len(channels))
+	}
+}
+
+func TestClient_CommandErrors(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newISupportClient(t, "CHANTYPES=# CHANNELLEN=10 NICKLEN=5 CHANLIMIT=#:2")
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{name: "join nothing", call: func() error { return c.Join() }, want: ErrNoTargets},
+		{name: "join bad chantype", call: func() error { return c.Join("&chan") }, want: ErrNotChannel},
+		{name: "join space", call: func() error { return c.Join("#a b") }, want: ErrNotChannel},
+		{name: "join too long", call: func() error { return c.Join("#abcdefghijk") }, want: ErrChannelTooLong},
+		{name: "join chanlimit", call: func() error { return c.Join("#a", "#b", "#c") }, want: ErrTooManyChannels},
+		{
+			name: "join too many keys",
+			call: func() error { return c.JoinWithKeys([]string{"#a"}, []string{"a", "b"}) },
+			want: ErrTooManyKeys,
+		},
+		{name: "part", call: func() error { return c.Part("chan") }, want: ErrNotChannel},
+		{name: "nick too long", call: func() error { return c.Nick("abcdef") }, want: ErrNickTooLong},
+		{name: "nick invalid", call: func() error { return c.Nick("a!b") }, want: ErrInvalidNick},
+		{name: "nick channel", call: func() error { return c.Nick("#abc") }, want: ErrInvalidNick},
+		{name: "kick", call: func() error { return c.Kick("#a", "", "bye") }, want: ErrInvalidNick},
+		{name: "topic", call: func() error { return c.Topic("a", "topic") }, want: ErrNotChannel},
+		{name: "invite", call: func() error { return c.Invite("nick", "a") }, want: ErrNotChannel},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Truncation(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "KICKLEN=5 TOPICLEN=8")
+
+	if err := c.Kick("#chan", "someone", "a long reason"); err != nil {
+		t.Fatalf("Kick() returned an error: %v", err)
+	}
+
+	if line := server.Expect("KICK"); line != "KICK #chan someone :a lon" {
+		t.Errorf("got %q, want %q", line, "KICK #chan someone :a lon")
+	}
+
+	if err := c.Topic("#chan", "a long topic"); err != nil {
+		t.Fatalf("Topic() returned an error: %v", err)
+	}
+
+	if line := server.Expect("TOPIC"); line != "TOPIC #chan :a long t" {
+		t.Errorf("got %q, want %q", line, "TOPIC #chan :a long t")
+	}
+
+	if err := c.Nick("other"); err != nil {
+		t.Fatalf("Nick() returned an error: %v", err)
+	}
+
+	if line := server.Expect("NICK"); line != "NICK other" {
+		t.Errorf("got %q, want %q", line, "NICK other")
+	}
+
+	if err := c.Invite("friend", "#chan"); err != nil {
+		t.Fatalf("Invite() returned an error: %v", err)
+	}
+
+	if line := server.Expect("INVITE"); line != "INVITE friend #chan" {
+		t.Errorf("got %q, want %q", line, "INVITE friend #chan")
+	}
+}
+
+func TestClient_Quit(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "CHANTYPES=#")
+
+	if err := c.Quit("going away"); err != nil {
+		t.Fatalf("Quit() returned an error: %v", err)
+	}
+
+	if line := server.Expect("QUIT"); line != "QUIT :going away" {
+		t.Errorf("got %q, want %q", line, "QUIT :going away")
+	}
+}
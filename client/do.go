@@ -0,0 +1,317 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"awesome-dragon.science/go/irc/numerics"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// ErrReplyFailed is wrapped by ReplyError, for use with errors.Is
+var ErrReplyFailed = errors.New("server replied with an error")
+
+// ErrConnectionClosed is returned by Do when the connection closes before the reply has been received
+var ErrConnectionClosed = errors.New("connection closed before the reply was received")
+
+// ReplyError is returned by Response.Err when the reply to a command contains an error numeric or a FAIL
+type ReplyError struct {
+	Command string   // The numeric, or FAIL
+	Params  []string // Its parameters
+}
+
+func (e *ReplyError) Error() string {
+	message := ""
+	if len(e.Params) > 0 {
+		message = ": " + e.Params[len(e.Params)-1]
+	}
+
+	return fmt.Sprintf("%s: %s%s", ErrReplyFailed, e.Command, message)
+}
+
+func (e *ReplyError) Unwrap() error { return ErrReplyFailed }
+
+// Response is the reply to a command sent with Do
+type Response struct {
+	Label    string            // The label sent with the command, or "" if labeled-response was not available
+	Messages []*ircmsg.Message // The lines of the reply. The BATCH lines around a labelled reply are not included
+}
+
+// Err returns a ReplyError for the first error numeric or FAIL in the reply, or nil if there were none
+func (r *Response) Err() error {
+	for _, msg := range r.Messages {
+		if msg.Command == "FAIL" || isErrorNumeric(msg.Command) {
+			return &ReplyError{Command: msg.Command, Params: msg.Params}
+		}
+	}
+
+	return nil
+}
+
+// isErrorNumeric returns whether or not command is a numeric in the 400-599 error range
+func isErrorNumeric(command string) bool {
+	num, err := strconv.Atoi(command)
+
+	return err == nil && len(command) == 3 && num >= 400 && num < 600
+}
+
+// replyTerminators are the numerics that end the reply to commands, used when labeled-response is not available
+var replyTerminators = map[string][]string{ //nolint:gochecknoglobals // Its a constant table
+	"WHOIS":  {numerics.RPL_ENDOFWHOIS},
+	"WHOWAS": {numerics.RPL_ENDOFWHOWAS},
+	"WHO":    {numerics.RPL_ENDOFWHO},
+	"NAMES":  {numerics.RPL_ENDOFNAMES},
+	"LIST":   {"323"},
+	"MOTD":   {numerics.RPL_ENDOFMOTD, numerics.ERR_NOMOTD},
+	"TOPIC":  {"331", numerics.RPL_TOPICWHOTIME}, // RPL_TOPIC is followed by RPL_TOPICWHOTIME
+	"ISON":   {"303"},
+	"AWAY":   {"305", "306"},
+	"INVITE": {"341"},
+	"ADMIN":  {"259"},
+	"INFO":   {"374"},
+	"LINKS":  {"365"},
+	"STATS":  {"219"},
+	"TIME":   {"391"},
+}
+
+// fenceTokenPrefix starts the PING tokens sent after commands when labeled-response is not available
+const fenceTokenPrefix = "do-"
+
+// Do sends a command, and waits for the server's reply to it. It returns early with ctx's error if ctx is done
+// first, or ErrConnectionClosed if the connection is lost.
+//
+// Do can be called from message handlers, as replies are collected as soon as lines are read, without waiting for
+// handlers. While a handler waits, the lines after it are queued as configured by connection.InboundConfig. With
+// the default OverflowBuffer policy they are all kept up to the queue's HardLimit, but with OverflowDisconnect, a
+// reply larger than QueueSize closes the connection. Either way, Do then returns ErrConnectionClosed.
+//
+// When the labeled-response and batch capabilities are available (they must be in Config.RequestedCapabilities),
+// the command is sent with a label, and the reply is exactly the lines the server labelled. Otherwise, a PING is
+// sent after the command, and the reply is every numeric, FAIL, line with the same command, and batch that arrives
+// before either the numeric that ends the reply to command (such as RPL_ENDOFWHOIS), or the PONG. This is a
+// heuristic, and can include lines that are not part of the reply, so only one such command is sent at a time.
+func (c *Client) Do(ctx context.Context, command string, params ...string) (*Response, error) {
+	c.mu.Lock()
+	requests := c.requests
+	conn := c.connection
+	c.mu.Unlock()
+
+	var (
+		w   *waiter
+		err error
+	)
+
+	if c.HasCap("labeled-response") && c.HasCap("batch") {
+		w = requests.add(&waiter{label: requests.nextID()})
+		err = c.WriteIRCWithTags(map[string]string{"label": w.label}, command, params...)
+	} else {
+		requests.fallbackMu.Lock()
+		defer requests.fallbackMu.Unlock()
+
+		w = requests.add(&waiter{
+			command:     strings.ToUpper(command),
+			terminators: replyTerminators[strings.ToUpper(command)],
+			fence:       fenceTokenPrefix + requests.nextID(),
+		})
+
+		if err = c.WriteIRC(command, params...); err == nil {
+			err = c.WriteIRC("PING", w.fence)
+		}
+	}
+
+	if err != nil {
+		requests.remove(w)
+
+		return nil, fmt.Errorf("client.do: %w", err)
+	}
+
+	select {
+	case <-w.done:
+		return &Response{Label: w.label, Messages: w.lines}, nil
+
+	case <-ctx.Done():
+		requests.remove(w)
+
+		return nil, fmt.Errorf("client.do: %w", ctx.Err())
+
+	case <-conn.Done():
+		requests.remove(w)
+
+		return nil, fmt.Errorf("client.do: %w", ErrConnectionClosed)
+	}
+}
+
+// pendingRequests keeps track of commands sent with Do that are waiting for replies. It is fed every line from the
+// read loop, so that replies are collected even if the handler that called Do is holding up the rest.
+type pendingRequests struct {
+	mu      sync.Mutex
+	lastID  uint64
+	waiters []*waiter
+
+	fallbackMu sync.Mutex // Held while a command without a label is waiting for its reply
+}
+
+func (r *pendingRequests) nextID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+
+	return strconv.FormatUint(r.lastID, 36)
+}
+
+func (r *pendingRequests) add(w *waiter) *waiter {
+	w.done = make(chan struct{})
+	w.batches = make(map[string]bool)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.waiters = append(r.waiters, w)
+
+	return w
+}
+
+func (r *pendingRequests) remove(w *waiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, other := range r.waiters {
+		if other == w {
+			r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// onMessage offers msg to every waiting request, completing any that it finishes
+func (r *pendingRequests) onMessage(msg *ircmsg.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := r.waiters[:0]
+
+	for _, w := range r.waiters {
+		if w.offer(msg) {
+			close(w.done)
+
+			continue
+		}
+
+		remaining = append(remaining, w)
+	}
+
+	r.waiters = remaining
+}
+
+// waiter is a single command waiting for its reply. Its fields are protected by pendingRequests.mu until done
+// is closed
+type waiter struct {
+	done    chan struct{}
+	lines   []*ircmsg.Message
+	batches map[string]bool // References of batches that are part of the reply, including nested ones
+
+	// With labeled-response
+	label string
+	batch string // Reference of the labeled-response batch, once it has started
+
+	// Without labeled-response
+	command     string
+	terminators []string
+	fence       string
+}
+
+// offer gives msg to the waiter, returning true if the reply is complete
+func (w *waiter) offer(msg *ircmsg.Message) bool {
+	if w.label != "" {
+		return w.offerLabelled(msg)
+	}
+
+	return w.offerFallback(msg)
+}
+
+func (w *waiter) offerLabelled(msg *ircmsg.Message) bool {
+	if ok, label := msg.GetTag("label"); ok && label == w.label {
+		switch {
+		case msg.Command == "ACK":
+			return true
+
+		case msg.Command == "BATCH" && len(msg.Params) > 0 && strings.HasPrefix(msg.Params[0], "+"):
+			w.batch = msg.Params[0][1:]
+			w.batches[w.batch] = true
+
+			return false
+
+		default:
+			w.lines = append(w.lines, msg)
+
+			return true
+		}
+	}
+
+	if w.batch == "" {
+		return false
+	}
+
+	if msg.Command == "BATCH" && len(msg.Params) > 0 && msg.Params[0] == "-"+w.batch {
+		return true
+	}
+
+	ok, ref := msg.GetTag("batch")
+	if !ok || !w.batches[ref] {
+		return false
+	}
+
+	if msg.Command == "BATCH" && len(msg.Params) > 0 && strings.HasPrefix(msg.Params[0], "+") {
+		w.batches[msg.Params[0][1:]] = true
+	}
+
+	w.lines = append(w.lines, msg)
+
+	return false
+}
+
+func (w *waiter) offerFallback(msg *ircmsg.Message) bool {
+	if msg.Command == "PONG" && len(msg.Params) > 0 && msg.Params[len(msg.Params)-1] == w.fence {
+		return true
+	}
+
+	if msg.Command == "BATCH" && len(msg.Params) > 0 && strings.HasPrefix(msg.Params[0], "+") {
+		w.batches[msg.Params[0][1:]] = true
+		w.lines = append(w.lines, msg)
+
+		return false
+	}
+
+	if ok, ref := msg.GetTag("batch"); ok && w.batches[ref] {
+		w.lines = append(w.lines, msg)
+
+		return false
+	}
+
+	if msg.Command == "BATCH" && len(msg.Params) > 0 && w.batches[strings.TrimPrefix(msg.Params[0], "-")] {
+		w.lines = append(w.lines, msg)
+
+		return false
+	}
+
+	numeric := len(msg.Command) == 3 && strings.Trim(msg.Command, "0123456789") == ""
+	if !numeric && msg.Command != "FAIL" && !strings.EqualFold(msg.Command, w.command) {
+		return false
+	}
+
+	w.lines = append(w.lines, msg)
+
+	for _, t := range w.terminators {
+		if msg.Command == t {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,255 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/connection"
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/event/function"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+type doResult struct {
+	res *Response
+	err error
+}
+
+// startDo calls Do in the background, so that the test can play the server's part
+func startDo(ctx context.Context, c *Client, command string, params ...string) <-chan doResult {
+	out := make(chan doResult, 1)
+
+	go func() {
+		res, err := c.Do(ctx, command, params...)
+		out <- doResult{res: res, err: err}
+	}()
+
+	return out
+}
+
+func waitDo(t *testing.T, results <-chan doResult) doResult {
+	t.Helper()
+
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for Do to return")
+	}
+
+	return doResult{}
+}
+
+func commands(res *Response) []string {
+	out := make([]string, 0, len(res.Messages))
+	for _, m := range res.Messages {
+		out = append(out, m.Command)
+	}
+
+	return out
+}
+
+func TestClient_DoLabelled(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{
+		Username:              "user",
+		Realname:              "real name",
+		RequestedCapabilities: []string{"labeled-response", "batch"},
+	}, "labeled-response", "batch")
+	server.Expect("USER")
+
+	results := startDo(context.Background(), c, "WHOIS", "someone")
+
+	line, err := ircmsg.ParseLine(server.Expect("@label="))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, label := line.GetTag("label")
+
+	server.Send(
+		"@label="+label+" :irc.test BATCH +b1 labeled-response",
+		"@batch=b1 :irc.test 311 test someone u h * :Real Name",
+		":irc.test 311 test unrelated u h * :Not part of the reply",
+		"@batch=b1 :irc.test 318 test someone :End of WHOIS",
+		":irc.test BATCH -b1",
+	)
+
+	res := waitDo(t, results)
+	if res.err != nil {
+		t.Fatalf("Do() returned an error: %v", res.err)
+	}
+
+	if got := commands(res.res); len(got) != 2 || got[0] != "311" || got[1] != "318" {
+		t.Errorf("reply = %v, want [311 318]", got)
+	}
+
+	if res.res.Label != label {
+		t.Errorf("Label = %q, want %q", res.res.Label, label)
+	}
+
+	// A single labelled line, and an ACK with no reply at all
+	results = startDo(context.Background(), c, "NICK", "taken")
+	line, _ = ircmsg.ParseLine(server.Expect("@label="))
+	_, label = line.GetTag("label")
+
+	server.Send("@label=" + label + " :irc.test 433 test taken :Nickname is already in use")
+
+	res = waitDo(t, results)
+	if res.err != nil || !errors.Is(res.res.Err(), ErrReplyFailed) {
+		t.Errorf("Do() = %v, %v, want a reply with an error", res.res, res.err)
+	}
+
+	results = startDo(context.Background(), c, "AWAY")
+	line, _ = ircmsg.ParseLine(server.Expect("@label="))
+	_, label = line.GetTag("label")
+
+	server.Send("@label=" + label + " :irc.test ACK")
+
+	if res = waitDo(t, results); res.err != nil || len(res.res.Messages) != 0 {
+		t.Errorf("Do() = %v, %v, want an empty reply", res.res, res.err)
+	}
+}
+
+func TestClient_DoFallback(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	server.Expect("USER")
+
+	// WHOIS has a terminator, so the PONG isn't needed
+	results := startDo(context.Background(), c, "WHOIS", "someone")
+
+	server.Expect("WHOIS someone")
+	server.Expect("PING do-")
+	server.Send(
+		":irc.test 311 test someone u h * :Real Name",
+		":other!u@h PRIVMSG #chan :not part of the reply",
+		":irc.test 318 test someone :End of WHOIS",
+	)
+
+	res := waitDo(t, results)
+	if res.err != nil {
+		t.Fatalf("Do() returned an error: %v", res.err)
+	}
+
+	if got := commands(res.res); len(got) != 2 || got[0] != "311" || got[1] != "318" {
+		t.Errorf("reply = %v, want [311 318]", got)
+	}
+
+	// Without a terminator, the PONG ends the reply
+	results = startDo(context.Background(), c, "NICK", "taken")
+
+	server.Expect("NICK taken")
+	fence := server.Expect("PING do-")[len("PING "):]
+	server.Send(
+		":irc.test 433 test taken :Nickname is already in use",
+		":irc.test PONG irc.test "+fence,
+	)
+
+	res = waitDo(t, results)
+
+	var replyErr *ReplyError
+	if res.err != nil || !errors.As(res.res.Err(), &replyErr) || replyErr.Command != "433" {
+		t.Errorf("Do() = %v, %v, want a reply with a 433 error", res.res, res.err)
+	}
+}
+
+func TestClient_DoContext(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	server.Expect("USER")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := c.Do(ctx, "WHOIS", "nobody"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	c.mu.Lock()
+	requests := c.requests
+	c.mu.Unlock()
+
+	requests.mu.Lock()
+	waiting := len(requests.waiters)
+	requests.mu.Unlock()
+
+	if waiting != 0 {
+		t.Errorf("%d requests still waiting after the context was done", waiting)
+	}
+}
+
+func TestClient_DoFromHandler(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{
+		Username:   "user",
+		Realname:   "real name",
+		Connection: connection.Config{Inbound: &connection.InboundConfig{QueueSize: 4}},
+	})
+	server.Expect("USER")
+
+	results := make(chan doResult, 1)
+
+	c.SetMessageHandler(function.FuncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			res, err := c.Do(ctx, "NAMES", "#big")
+			results <- doResult{res: res, err: err}
+		}
+
+		return nil
+	}))
+
+	server.Send(":someone!u@h PRIVMSG #chan :go")
+	server.Expect("NAMES #big")
+
+	// Far more lines than the queue holds, while the handler that called Do is still waiting
+	for i := 0; i < 20; i++ {
+		server.Send(fmt.Sprintf(":irc.test 353 test = #big :user%d", i))
+	}
+
+	server.Send(":irc.test 366 test #big :End of /NAMES list.")
+
+	res := waitDo(t, results)
+	if res.err != nil {
+		t.Fatalf("Do() returned an error: %v", res.err)
+	}
+
+	if len(res.res.Messages) != 21 {
+		t.Errorf("got %d lines, want 21", len(res.res.Messages))
+	}
+}
+
+func TestClient_DoTopic(t *testing.T) {
+	t.Parallel()
+
+	c, server := newTestClient(t, &Config{Username: "user", Realname: "real name"})
+	server.Expect("USER")
+
+	results := startDo(context.Background(), c, "TOPIC", "#chan")
+
+	server.Expect("TOPIC #chan")
+	server.Expect("PING do-")
+	server.Send(
+		":irc.test 332 test #chan :The topic",
+		":irc.test 333 test #chan someone 1600000000",
+	)
+
+	res := waitDo(t, results)
+	if res.err != nil {
+		t.Fatalf("Do() returned an error: %v", res.err)
+	}
+
+	if got := commands(res.res); len(got) != 2 || got[1] != "333" {
+		t.Errorf("reply = %v, want [332 333]", got)
+	}
+}
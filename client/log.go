@@ -0,0 +1,52 @@
+package client
+
+import (
+	"sync"
+
+	"awesome-dragon.science/go/irc/logger"
+)
+
+// contextLogger is a logger.Logger that adds the network and nick a Client is using to every message.
+// It has its own lock, so it is safe to log while holding Client.mu
+type contextLogger struct {
+	parent logger.Logger
+
+	mu      sync.Mutex
+	network string
+	nick    string
+}
+
+func (l *contextLogger) setNetwork(network string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.network = network
+}
+
+func (l *contextLogger) setNick(nick string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nick = nick
+}
+
+func (l *contextLogger) with(args []interface{}) []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]interface{}, 0, len(args)+4)
+	if l.network != "" {
+		out = append(out, "network", l.network)
+	}
+
+	if l.nick != "" {
+		out = append(out, "nick", l.nick)
+	}
+
+	return append(out, args...)
+}
+
+func (l *contextLogger) Debug(msg string, args ...interface{}) { l.parent.Debug(msg, l.with(args)...) }
+func (l *contextLogger) Info(msg string, args ...interface{})  { l.parent.Info(msg, l.with(args)...) }
+func (l *contextLogger) Warn(msg string, args ...interface{})  { l.parent.Warn(msg, l.with(args)...) }
+func (l *contextLogger) Error(msg string, args ...interface{}) { l.parent.Error(msg, l.with(args)...) }
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Defaults used for any unset fields on ReconnectConfig
+const (
+	DefaultReconnectInitialDelay = time.Second * 2
+	DefaultReconnectMaxDelay     = time.Minute * 5
+	DefaultReconnectMultiplier   = 2.0
+)
+
+// ErrReconnectGaveUp is returned from Run when the reconnect policy's limits have been reached
+var ErrReconnectGaveUp = errors.New("gave up reconnecting")
+
+// ReconnectConfig describes how a Client should reconnect when it loses its connection.
+//
+// The delay between attempts grows exponentially from InitialDelay by Multiplier each failed attempt,
+// up to MaxDelay, and is then randomised by Jitter. The attempt counter is reset once a connection
+// successfully registers with the server.
+type ReconnectConfig struct {
+	InitialDelay time.Duration // Delay before the first reconnect attempt
+	MaxDelay     time.Duration // Upper limit on the delay between attempts
+	Multiplier   float64       // Growth factor applied to the delay for each failed attempt
+	Jitter       float64       // Fraction (0-1) of the delay that is randomised in either direction
+
+	MaxAttempts int           // Maximum consecutive failed attempts before giving up, 0 for no limit
+	MaxElapsed  time.Duration // Maximum time spent failing to reconnect before giving up, 0 for no limit
+}
+
+// DefaultReconnectConfig returns a ReconnectConfig with sensible defaults, and no limits
+func DefaultReconnectConfig() *ReconnectConfig {
+	return &ReconnectConfig{
+		InitialDelay: DefaultReconnectInitialDelay,
+		MaxDelay:     DefaultReconnectMaxDelay,
+		Multiplier:   DefaultReconnectMultiplier,
+		Jitter:       0.2,
+	}
+}
+
+// Delay returns the delay to wait before the given attempt (starting at 1), randomised by
+// rng, which is expected to return numbers in [0, 1), like rand.Float64. rng may be nil if Jitter is 0
+func (r *ReconnectConfig) Delay(attempt int, rng func() float64) time.Duration {
+	initial, maxDelay, multiplier := r.InitialDelay, r.MaxDelay, r.Multiplier
+
+	if initial <= 0 {
+		initial = DefaultReconnectInitialDelay
+	}
+
+	if maxDelay <= 0 {
+		maxDelay = DefaultReconnectMaxDelay
+	}
+
+	if multiplier < 1 {
+		multiplier = DefaultReconnectMultiplier
+	}
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maxDelay))
+
+	if r.Jitter > 0 && rng != nil {
+		delay += delay * r.Jitter * (2*rng() - 1)
+	}
+
+	return time.Duration(math.Max(0, math.Min(delay, float64(maxDelay))))
+}
+
+// shouldGiveUp returns whether or not the limits on the ReconnectConfig have been reached
+func (r *ReconnectConfig) shouldGiveUp(failedAttempts int, failingSince time.Time) bool {
+	if r.MaxAttempts > 0 && failedAttempts >= r.MaxAttempts {
+		return true
+	}
+
+	if r.MaxElapsed > 0 && !failingSince.IsZero() && time.Since(failingSince) >= r.MaxElapsed {
+		return true
+	}
+
+	return false
+}
+
+// StatusType is the type of a StatusEvent
+type StatusType int
+
+// Status event types
+const (
+	// StatusConnecting is emitted before every connection attempt
+	StatusConnecting StatusType = iota
+	// StatusConnected is emitted once the socket has been opened
+	StatusConnected
+	// StatusDisconnected is emitted whenever an attempt ends. If the connection could not be opened
+	// at all, Err will be set
+	StatusDisconnected
+	// StatusGaveUp is emitted when the reconnect policy has run out of attempts
+	StatusGaveUp
+	// StatusLag is emitted whenever a keepalive PING measures the lag to the server. See connection.KeepaliveConfig
+	StatusLag
+)
+
+func (s StatusType) String() string {
+	switch s {
+	case StatusConnecting:
+		return "Connecting"
+	case StatusConnected:
+		return "Connected"
+	case StatusDisconnected:
+		return "Disconnected"
+	case StatusGaveUp:
+		return "GaveUp"
+	case StatusLag:
+		return "Lag"
+	default:
+		return "Unknown"
+	}
+}
+
+// StatusEvent describes a change in the connection status of a Client
+type StatusEvent struct {
+	Type    StatusType
+	Attempt int           // The current connection attempt, starting at 1
+	Delay   time.Duration // For StatusDisconnected, how long until the next attempt, if any
+	Lag     time.Duration // For StatusLag, the measured round trip time
+	Err     error
+}
+
+// StatusFunc is a callback for StatusEvents
+type StatusFunc func(StatusEvent)
+
+// AddStatusCallback adds a callback that will be called on every StatusEvent. The returned ID can be used
+// to remove the callback. Callbacks are called synchronously from Run, and should not block.
+func (c *Client) AddStatusCallback(f StatusFunc) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.statusCallbacks == nil {
+		c.statusCallbacks = make(map[int]StatusFunc)
+	}
+
+	c.lastStatusID++
+	c.statusCallbacks[c.lastStatusID] = f
+
+	return c.lastStatusID
+}
+
+// RemoveStatusCallback removes a callback added with AddStatusCallback
+func (c *Client) RemoveStatusCallback(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.statusCallbacks, id)
+}
+
+func (c *Client) emitStatus(ev StatusEvent) {
+	c.mu.Lock()
+	callbacks := make([]StatusFunc, 0, len(c.statusCallbacks))
+
+	for _, f := range c.statusCallbacks {
+		callbacks = append(callbacks, f)
+	}
+	c.mu.Unlock()
+
+	for _, f := range callbacks {
+		f(ev)
+	}
+}
+
+//nolint:gochecknoglobals // rand.Rand is not safe for concurrent use, and every process needs its own seed
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec // Not security relevant
+)
+
+func jitter() float64 {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return jitterRand.Float64()
+}
+
+func (c *Client) runWithReconnect(ctx context.Context) error {
+	policy := c.config.Reconnect
+	failedAttempts := 0
+
+	var failingSince time.Time
+
+	for attempt := 1; ; attempt++ {
+		registered, err := c.connectAndServe(ctx, attempt)
+
+		if registered {
+			failedAttempts = 0
+			failingSince = time.Time{}
+		} else {
+			failedAttempts++
+
+			if failingSince.IsZero() {
+				failingSince = time.Now()
+			}
+		}
+
+		if c.stopped() || ctx.Err() != nil {
+			c.emitStatus(StatusEvent{Type: StatusDisconnected, Attempt: attempt, Err: err})
+
+			return c.runResult(ctx)
+		}
+
+		if policy.shouldGiveUp(failedAttempts, failingSince) {
+			c.emitStatus(StatusEvent{Type: StatusDisconnected, Attempt: attempt, Err: err})
+
+			gaveUpErr := fmt.Errorf("%w after %d attempts", ErrReconnectGaveUp, failedAttempts)
+			c.log.Error("Giving up reconnecting to IRC", "attempts", failedAttempts, "error", err)
+			c.emitStatus(StatusEvent{Type: StatusGaveUp, Attempt: attempt, Err: gaveUpErr})
+
+			return gaveUpErr
+		}
+
+		delay := policy.Delay(failedAttempts+1, jitter)
+
+		c.emitStatus(StatusEvent{Type: StatusDisconnected, Attempt: attempt, Delay: delay, Err: err})
+		c.log.Info("Disconnected from IRC, reconnecting", "delay", delay, "attempt", attempt)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return c.runResult(ctx)
+		case <-c.stopChan:
+			timer.Stop()
+
+			return nil
+		}
+	}
+}
+
+// runResult is what Run returns once it has been told to exit: nil after Stop, or ctx's error if it was cancelled
+func (c *Client) runResult(ctx context.Context) error {
+	if c.stopped() || ctx.Err() == nil {
+		return nil
+	}
+
+	return fmt.Errorf("client.run: %w", ctx.Err())
+}
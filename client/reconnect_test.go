@@ -0,0 +1,393 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/capab"
+	"awesome-dragon.science/go/irc/connection"
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/event/function"
+)
+
+func TestReconnectConfig_Delay(t *testing.T) {
+	t.Parallel()
+
+	conf := &ReconnectConfig{
+		InitialDelay: time.Second,
+		MaxDelay:     time.Second * 10,
+		Multiplier:   2,
+	}
+
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first", attempt: 1, want: time.Second},
+		{name: "second", attempt: 2, want: time.Second * 2},
+		{name: "fourth", attempt: 4, want: time.Second * 8},
+		{name: "capped", attempt: 10, want: time.Second * 10},
+		{name: "zero", attempt: 0, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := conf.Delay(tt.attempt, nil); got != tt.want {
+				t.Errorf("ReconnectConfig.Delay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconnectConfig_DelayJitter(t *testing.T) {
+	t.Parallel()
+
+	conf := &ReconnectConfig{InitialDelay: time.Second * 10, MaxDelay: time.Minute, Jitter: 0.5}
+
+	if got := conf.Delay(1, func() float64 { return 0 }); got != time.Second*5 {
+		t.Errorf("ReconnectConfig.Delay() with low jitter = %s, want %s", got, time.Second*5)
+	}
+
+	if got := conf.Delay(1, func() float64 { return 0.5 }); got != time.Second*10 {
+		t.Errorf("ReconnectConfig.Delay() with mid jitter = %s, want %s", got, time.Second*10)
+	}
+
+	if got := conf.Delay(3, func() float64 { return 0.99 }); got > time.Minute {
+		t.Errorf("ReconnectConfig.Delay() with high jitter = %s, want <= %s", got, time.Minute)
+	}
+}
+
+func TestReconnectConfig_shouldGiveUp(t *testing.T) {
+	t.Parallel()
+
+	if (&ReconnectConfig{}).shouldGiveUp(1000, time.Now().Add(-time.Hour)) {
+		t.Error("shouldGiveUp() with no limits returned true")
+	}
+
+	if !(&ReconnectConfig{MaxAttempts: 3}).shouldGiveUp(3, time.Now()) {
+		t.Error("shouldGiveUp() did not give up after MaxAttempts")
+	}
+
+	if !(&ReconnectConfig{MaxElapsed: time.Minute}).shouldGiveUp(1, time.Now().Add(-time.Hour)) {
+		t.Error("shouldGiveUp() did not give up after MaxElapsed")
+	}
+}
+
+// fakeServer accepts connections and hands them to handle, one at a time.
+func fakeServer(t *testing.T, handle func(n int, conn net.Conn)) (host, port string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			handle(n, conn)
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+
+	return host, port
+}
+
+// readUntil reads lines from r until one starts with prefix, returning all lines read.
+// Capability negotiation is answered with the given caps on the way.
+func readUntil(conn net.Conn, r *bufio.Reader, prefix string, caps ...string) []string {
+	var out []string
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return out
+		}
+
+		if strings.HasPrefix(line, "CAP LS") {
+			_, _ = conn.Write([]byte(":srv CAP * LS :" + strings.Join(caps, " ") + "\r\n"))
+		}
+
+		out = append(out, strings.TrimSpace(line))
+
+		if strings.HasPrefix(line, prefix) {
+			return out
+		}
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	t.Parallel()
+
+	rejoined := make(chan []string, 1)
+
+	host, port := fakeServer(t, func(n int, conn net.Conn) {
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		readUntil(conn, reader, "USER")
+
+		// Channels are only rejoined once the MOTD is over, after the CHANTYPES that allows +chan
+		_, _ = conn.Write([]byte(strings.Join([]string{
+			":srv 001 test :Welcome",
+			":srv 005 test CHANTYPES=#+ :are supported by this server",
+			":srv 422 test :MOTD File is missing",
+		}, "\r\n") + "\r\n"))
+
+		if n == 0 {
+			_, _ = conn.Write([]byte(":test!u@h JOIN #chan\r\n:test!u@h JOIN +chan\r\n"))
+			time.Sleep(time.Millisecond * 50)
+
+			return
+		}
+
+		rejoined <- readUntil(conn, reader, "JOIN")
+	})
+
+	c := New(&Config{
+		Connection: connection.Config{Host: host, Port: port},
+		Nick:       "test",
+		Username:   "test",
+		Realname:   "test",
+		Reconnect:  &ReconnectConfig{InitialDelay: time.Millisecond * 10},
+		STSStore:   &capab.MemorySTSStore{},
+	})
+
+	var (
+		mu     sync.Mutex
+		events []StatusType
+	)
+
+	c.AddStatusCallback(func(ev StatusEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		events = append(events, ev.Type)
+	})
+
+	go func() { _ = c.Run(context.Background()) }()
+
+	select {
+	case lines := <-rejoined:
+		if last := lines[len(lines)-1]; last != "JOIN #chan,+chan" {
+			t.Errorf("client did not rejoin channels, last line was %q", last)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for client to reconnect")
+	}
+
+	c.Stop("bye")
+	c.WaitForExit()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []StatusType{StatusConnecting, StatusConnected, StatusDisconnected, StatusConnecting, StatusConnected}
+	for i, ev := range want {
+		if i >= len(events) || events[i] != ev {
+			t.Fatalf("unexpected status events %v, want prefix %v", events, want)
+		}
+	}
+}
+
+func TestClient_ReconnectGiveUp(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close() // Nothing will be listening here now
+
+	c := New(&Config{
+		Connection: connection.Config{Host: host, Port: port},
+		Nick:       "test",
+		Reconnect:  &ReconnectConfig{InitialDelay: time.Millisecond, MaxAttempts: 3},
+		STSStore:   &capab.MemorySTSStore{},
+	})
+
+	attempts := 0
+
+	c.AddStatusCallback(func(ev StatusEvent) {
+		if ev.Type == StatusConnecting {
+			attempts++
+		}
+	})
+
+	if err := c.Run(context.Background()); !errors.Is(err, ErrReconnectGaveUp) {
+		t.Errorf("Run() = %v, want %v", err, ErrReconnectGaveUp)
+	}
+
+	if attempts != 3 {
+		t.Errorf("made %d attempts, want 3", attempts)
+	}
+}
+
+func TestClient_RunCancelled(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close() // Nothing will be listening here now
+
+	c := New(&Config{
+		Connection: connection.Config{Host: host, Port: port},
+		Nick:       "test",
+		Reconnect:  &ReconnectConfig{InitialDelay: time.Hour},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancelled while waiting to reconnect
+	c.AddStatusCallback(func(ev StatusEvent) {
+		if ev.Type == StatusDisconnected {
+			cancel()
+		}
+	})
+
+	if err := c.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClient_ReconnectKeys(t *testing.T) {
+	t.Parallel()
+
+	rejoined := make(chan []string, 1)
+
+	host, port := fakeServer(t, func(n int, conn net.Conn) {
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		readUntil(conn, reader, "USER")
+
+		_, _ = conn.Write([]byte(":srv 001 test :Welcome\r\n"))
+
+		if n > 0 {
+			_, _ = conn.Write([]byte(":srv 376 test :End of /MOTD command.\r\n"))
+			rejoined <- readUntil(conn, reader, "JOIN")
+
+			return
+		}
+
+		readUntil(conn, reader, "JOIN #keyed")
+		_, _ = conn.Write([]byte(strings.Join([]string{
+			":srv 005 test CHANMODES=b,k,l,imnst CASEMAPPING=ascii :are supported by this server",
+			":Test!u@h JOIN #Keyed",
+			":test!u@h JOIN #chan",
+			":op!u@h MODE #chan +k hunter2",
+			":test!u@h JOIN #gone",
+			":TEST!u@h PART #GONE",
+			":test!u@h JOIN #kicked",
+			":op!u@h KICK #KICKED Test :bye",
+		}, "\r\n") + "\r\n"))
+		time.Sleep(time.Millisecond * 50)
+	})
+
+	c := New(&Config{
+		Connection: connection.Config{Host: host, Port: port},
+		Nick:       "test",
+		Username:   "test",
+		Realname:   "test",
+		Reconnect:  &ReconnectConfig{InitialDelay: time.Millisecond * 10},
+		STSStore:   &capab.MemorySTSStore{},
+	})
+
+	var once sync.Once
+
+	c.SetMessageHandler(function.FuncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "001" {
+			once.Do(func() {
+				if err := c.JoinWithKeys([]string{"#keyed"}, []string{"secret"}); err != nil {
+					t.Errorf("JoinWithKeys() returned an error: %v", err)
+				}
+			})
+		}
+
+		return nil
+	}))
+
+	go func() { _ = c.Run(context.Background()) }()
+
+	select {
+	case lines := <-rejoined:
+		want := "JOIN #Keyed,#chan secret,hunter2"
+		if last := lines[len(lines)-1]; last != want {
+			t.Errorf("rejoin line = %q, want %q", last, want)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for client to reconnect")
+	}
+
+	c.Stop("bye")
+	c.WaitForExit()
+}
+
+func TestClient_ReconnectDuringNegotiation(t *testing.T) {
+	t.Parallel()
+
+	registered := make(chan struct{}, 1)
+
+	host, port := fakeServer(t, func(n int, conn net.Conn) {
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		if n == 0 {
+			// Drop the link without answering, leaving negotiation waiting on a reply that will never come
+			_, _ = reader.ReadString('\n')
+
+			return
+		}
+
+		readUntil(conn, reader, "USER")
+		registered <- struct{}{}
+	})
+
+	c := New(&Config{
+		Connection:            connection.Config{Host: host, Port: port},
+		Nick:                  "test",
+		Username:              "test",
+		Realname:              "test",
+		RequestedCapabilities: []string{"message-tags"},
+		Reconnect:             &ReconnectConfig{InitialDelay: time.Millisecond * 10},
+	})
+
+	go func() { _ = c.Run(context.Background()) }()
+
+	// The client only reconnects once the registration on the dropped connection has returned
+	select {
+	case <-registered:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the client to reconnect and register")
+	}
+
+	c.Stop("bye")
+	c.WaitForExit()
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	"awesome-dragon.science/go/irc/capab"
+	"awesome-dragon.science/go/irc/connection"
+)
+
+// defaultSTSStore returns a file backed STSStore in the default location, or an in memory one if that fails
+func (c *Client) defaultSTSStore() capab.STSStore {
+	path, err := capab.DefaultSTSStorePath()
+	if err != nil {
+		c.log.Warn("Could not find a location for STS policies, they will not be persisted", "error", err)
+
+		return &capab.MemorySTSStore{}
+	}
+
+	return &capab.FileSTSStore{Path: path}
+}
+
+// connectionConfig returns a copy of Config.Connection to use for the next connection. It shares the client's
+// server rotation, and enforces STS policies on the servers in it.
+func (c *Client) connectionConfig() *connection.Config {
+	config := c.config.Connection
+	config.Rotation = c.servers
+
+	if config.Logger == nil {
+		config.Logger = c.log
+	}
+
+	if c.stsStore != nil && config.WebSocket == nil {
+		config.PrepareServer = c.applySTS
+	}
+
+	return &config
+}
+
+// applySTS changes server to use TLS if there is an STS policy for it, or the last connection to it asked to
+// be upgraded. It is used as connection.Config.PrepareServer
+func (c *Client) applySTS(server connection.Server) connection.Server {
+	c.mu.Lock()
+	port := 0
+	if c.stsUpgradeHost == server.Host {
+		port = c.stsUpgradePort
+	}
+
+	c.stsUpgradeHost, c.stsUpgradePort = "", 0
+	c.mu.Unlock()
+
+	if server.TLS {
+		return server
+	}
+
+	if port == 0 {
+		policy, err := c.stsStore.Get(server.Host)
+		if err != nil {
+			c.log.Warn("Could not look up STS policy", "host", server.Host, "error", err)
+		}
+
+		if policy != nil {
+			port = policy.Port
+		}
+	}
+
+	if port == 0 {
+		return server
+	}
+
+	c.log.Info("STS: Using TLS", "host", server.Host, "port", port)
+
+	server.TLS = true
+	server.Port = strconv.Itoa(port)
+
+	return server
+}
+
+// stsUpgradePending returns whether or not the current connection is being dropped to upgrade to TLS
+func (c *Client) stsUpgradePending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stsUpgradePort != 0
+}
+
+// onCapsOffered returns a callback for capab.Config.OnOffered that enforces STS for the given connection
+func (c *Client) onCapsOffered(conn *connection.Connection) func([]capab.Capability) bool {
+	return func(offered []capab.Capability) bool {
+		for _, capability := range offered {
+			if capability.Name == capab.STS {
+				return c.onSTS(conn.Server(), capability.Value)
+			}
+		}
+
+		return true
+	}
+}
+
+// onSTS handles an sts capability offered by server. It returns false if the connection is going to be upgraded
+func (c *Client) onSTS(server connection.Server, rawValue string) bool {
+	value, err := capab.ParseSTS(rawValue)
+	if err != nil {
+		c.log.Warn("Ignoring invalid STS policy", "policy", rawValue, "error", err)
+
+		return true
+	}
+
+	if !server.TLS {
+		if value.Port == 0 {
+			c.log.Warn("Ignoring STS policy offered without a port on an insecure connection", "policy", rawValue)
+
+			return true
+		}
+
+		c.log.Info("STS: Server requested an upgrade to TLS", "port", value.Port)
+
+		c.mu.Lock()
+		c.stsUpgradeHost, c.stsUpgradePort = server.Host, value.Port
+		c.mu.Unlock()
+
+		return false
+	}
+
+	if !value.HasDuration {
+		return true
+	}
+
+	if value.Duration == 0 {
+		if err := c.stsStore.Delete(server.Host); err != nil {
+			c.log.Warn("Could not remove STS policy", "host", server.Host, "error", err)
+		}
+
+		return true
+	}
+
+	port, _ := strconv.Atoi(server.Port)
+
+	err = c.stsStore.Set(&capab.STSPolicy{
+		Host:    server.Host,
+		Port:    port,
+		Expires: time.Now().Add(value.Duration),
+		Preload: value.Preload,
+	})
+	if err != nil {
+		c.log.Warn("Could not save STS policy", "host", server.Host, "error", err)
+	}
+
+	return true
+}
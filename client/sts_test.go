@@ -0,0 +1,109 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/capab"
+	"awesome-dragon.science/go/irc/connection"
+)
+
+// tlsServer is fakeServer, but over TLS
+func tlsServer(t *testing.T, handle func(n int, conn net.Conn)) (host, port string) {
+	t.Helper()
+
+	// Easiest way to get our hands on a certificate
+	httpServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certs := httpServer.TLS.Certificates
+	httpServer.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certs}) //nolint:gosec // Its a test
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			handle(n, conn)
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+
+	return host, port
+}
+
+func TestClient_STS(t *testing.T) {
+	t.Parallel()
+
+	registered := make(chan struct{}, 2)
+
+	_, tlsPort := tlsServer(t, func(n int, conn net.Conn) {
+		go func() {
+			defer conn.Close()
+
+			reader := bufio.NewReader(conn)
+			readUntil(conn, reader, "USER", "sts=duration=300")
+
+			_, _ = conn.Write([]byte(":srv 001 test :Welcome\r\n"))
+			registered <- struct{}{}
+
+			readUntil(conn, reader, "QUIT")
+		}()
+	})
+
+	host, plainPort := fakeServer(t, func(n int, conn net.Conn) {
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		readUntil(conn, reader, "QUIT", "sts=port="+tlsPort)
+	})
+
+	store := &capab.MemorySTSStore{}
+
+	run := func() {
+		c := New(&Config{
+			Connection: connection.Config{Host: host, Port: plainPort, InsecureSkipVerifyTLS: true},
+			Nick:       "test",
+			Username:   "test",
+			Realname:   "test",
+			STSStore:   store,
+		})
+
+		go func() { _ = c.Run(context.Background()) }()
+
+		select {
+		case <-registered:
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for client to connect over TLS")
+		}
+
+		c.Stop("bye")
+		c.WaitForExit()
+	}
+
+	// The first run is upgraded by the plaintext server, and stores the policy
+	run()
+
+	policy, _ := store.Get(host)
+	if policy == nil || strconv.Itoa(policy.Port) != tlsPort {
+		t.Fatalf("stored policy = %+v, want one for port %s", policy, tlsPort)
+	}
+
+	// The second should go straight to TLS
+	run()
+}
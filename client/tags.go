@@ -0,0 +1,126 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"awesome-dragon.science/go/irc/event"
+)
+
+// Client-only tags with helpers on Client
+const (
+	TagReply  = "+draft/reply"
+	TagReact  = "+draft/react"
+	TagTyping = "+typing"
+)
+
+// ErrTagsUnsupported is returned when attempting to send tags without the capability they require
+var ErrTagsUnsupported = errors.New("tag not supported by server")
+
+// HasCap returns whether or not the given capability has been negotiated on the current connection
+func (c *Client) HasCap(name string) bool {
+	c.mu.Lock()
+	capabilities := c.capabilities
+	c.mu.Unlock()
+
+	for _, capab := range capabilities.AvailableCaps() {
+		if strings.EqualFold(capab.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tagCapability returns the capability that must be negotiated before the given tag can be sent
+func tagCapability(tag string) string {
+	if tag == "label" {
+		return "labeled-response"
+	}
+
+	return "message-tags"
+}
+
+// WriteIRCWithTags is like WriteIRC, but includes the given IRCv3 message tags on the line.
+// Every tag is checked against the negotiated capabilities (ErrTagsUnsupported), and client-only tags
+// against the server's CLIENTTAGDENY token (connection.ErrTagDenied) before anything is sent.
+func (c *Client) WriteIRCWithTags(tags map[string]string, command string, params ...string) error {
+	for name := range tags {
+		if required := tagCapability(name); !c.HasCap(required) {
+			return fmt.Errorf("%w: cannot send %q without %q", ErrTagsUnsupported, name, required)
+		}
+	}
+
+	if err := c.conn().WriteLineWithTags(tags, command, params...); err != nil {
+		return fmt.Errorf("client.writeircwithtags: %w", err)
+	}
+
+	return nil
+}
+
+// canSendClientTag returns whether or not the given client-only tag can be sent right now
+func (c *Client) canSendClientTag(tag string) bool {
+	return c.HasCap("message-tags") && c.conn().ISupport.ClientTagAllowed(tag)
+}
+
+// ReplyTarget returns where a reply to msg should be sent. For messages sent directly to us, this is the sender,
+// otherwise it is the channel the message was sent to.
+func ReplyTarget(msg *event.Message) string {
+	if len(msg.Raw.Params) == 0 || strings.EqualFold(msg.Raw.Params[0], msg.CurrentNick) {
+		return msg.SourceUser.Name
+	}
+
+	return msg.Raw.Params[0]
+}
+
+// replyTags returns the tags needed to mark a line as a reply to msg, if they can be sent
+func (c *Client) replyTags(msg *event.Message) map[string]string {
+	exists, msgid := msg.Raw.GetTag("msgid")
+	if !exists || !c.canSendClientTag(TagReply) {
+		return nil
+	}
+
+	return map[string]string{TagReply: msgid}
+}
+
+// Reply sends a PRIVMSG in reply to msg, to the channel it was sent to, or the sender if it was sent to us directly.
+// If the server supports it, the message is tagged as a reply to the original.
+func (c *Client) Reply(msg *event.Message, message string) error {
+	return c.WriteIRCWithTags(c.replyTags(msg), "PRIVMSG", ReplyTarget(msg), message)
+}
+
+// Replyf is like Reply but with printf formatting
+func (c *Client) Replyf(msg *event.Message, format string, args ...interface{}) error {
+	return c.Reply(msg, fmt.Sprintf(format, args...))
+}
+
+// ReplyNotice is like Reply, but sends a NOTICE
+func (c *Client) ReplyNotice(msg *event.Message, message string) error {
+	return c.WriteIRCWithTags(c.replyTags(msg), "NOTICE", ReplyTarget(msg), message)
+}
+
+// React sends a reaction to msg. This requires that the server supports message-tags, and that msg has an ID.
+func (c *Client) React(msg *event.Message, reaction string) error {
+	exists, msgid := msg.Raw.GetTag("msgid")
+	if !exists {
+		return fmt.Errorf("%w: cannot react to a message without a msgid", ErrTagsUnsupported)
+	}
+
+	return c.WriteIRCWithTags(map[string]string{TagReply: msgid, TagReact: reaction}, "TAGMSG", ReplyTarget(msg))
+}
+
+// TypingState is a state for the +typing client tag
+type TypingState string
+
+// Valid TypingStates
+const (
+	TypingActive TypingState = "active"
+	TypingPaused TypingState = "paused"
+	TypingDone   TypingState = "done"
+)
+
+// SendTyping sends a typing notification to the given target. This requires that the server supports message-tags
+func (c *Client) SendTyping(target string, state TypingState) error {
+	return c.WriteIRCWithTags(map[string]string{TagTyping: string(state)}, "TAGMSG", target)
+}
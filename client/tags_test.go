@@ -0,0 +1,63 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"errors"
+	"testing"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func mustParseLine(line string) *ircmsg.Message {
+	res, err := ircmsg.ParseLine(line)
+	if err != nil {
+		panic(err)
+	}
+
+	return &res
+}
+
+func TestReplyTarget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{name: "channel", line: ":a!b@c PRIVMSG #chan :hi", want: "#chan"},
+		{name: "pm", line: ":a!b@c PRIVMSG test :hi", want: "a"},
+		{name: "pm case", line: ":a!b@c PRIVMSG TeSt :hi", want: "a"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw := mustParseLine(tt.line)
+			msg := &event.Message{Raw: raw, SourceUser: user.FromMessage(raw, nil), CurrentNick: "test"}
+
+			if got := ReplyTarget(msg); got != tt.want {
+				t.Errorf("ReplyTarget() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_WriteIRCWithTagsUnsupported(t *testing.T) {
+	t.Parallel()
+
+	c := New(&Config{Nick: "test"})
+
+	err := c.WriteIRCWithTags(map[string]string{TagTyping: "active"}, "TAGMSG", "#chan")
+	if !errors.Is(err, ErrTagsUnsupported) {
+		t.Errorf("WriteIRCWithTags() = %v, want %v", err, ErrTagsUnsupported)
+	}
+
+	err = c.WriteIRCWithTags(map[string]string{"label": "1"}, "PING", "test")
+	if !errors.Is(err, ErrTagsUnsupported) {
+		t.Errorf("WriteIRCWithTags() = %v, want %v", err, ErrTagsUnsupported)
+	}
+}
@@ -2,27 +2,38 @@ package client
 
 import (
 	"fmt"
+	"time"
 
-	"awesome-dragon.science/go/irc/util"
+	"awesome-dragon.science/go/irc/connection"
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/state"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
 )
 
-// WaitForExit blocks until the connection is closed
+// WaitForExit blocks until Run has returned
 func (c *Client) WaitForExit() {
 	<-c.DoneChan()
 }
 
-// DoneChan returns a channel that will be closed when the connection is closed
+// DoneChan returns a channel that will be closed when Run returns. Without a reconnect policy, this is when
+// the connection is closed.
 func (c *Client) DoneChan() <-chan struct{} {
-	return c.connection.Done()
+	return c.done
 }
 
-// Stop stops the bot, quitting with the given message if possible
+// Stop stops the bot, quitting with the given message if possible. The client will not reconnect afterwards.
 func (c *Client) Stop(message string) {
-	c.connection.Stop(message)
+	c.stopOnce.Do(func() {
+		c.mu.Lock()
+		c.stopMessage = message
+		c.mu.Unlock()
+
+		close(c.stopChan)
+	})
+	c.conn().Stop(message)
 }
 
-const maxMessageLength = 400
-
 // SendMessage sends a PRIVMSG to the given target with the given message.
 func (c *Client) SendMessage(target, message string) error {
 	return c.WriteIRC("PRIVMSG", target, message)
@@ -32,10 +43,23 @@ func (c *Client) SendMessage(target, message string) error {
 // message, it will split it into chunks, and send each one individually.
 //
 // This is mostly intended for use with things like chatcommand.Handler that dont know how long their messages
-// will be. If you can, you likely want to do this manually, as the chunking this uses is not intelligent at all.
+// will be. Chunks are split on spaces where possible, and sized using the real length of the line once the
+// server has relayed it.
 func (c *Client) SendMessageChunked(target, message string) error {
-	for _, l := range util.ChunkMessage(message, maxMessageLength) {
-		if err := c.SendMessage(target, l); err != nil {
+	return c.sendChunked("PRIVMSG", target, message)
+}
+
+// sendChunked sends message to target using command, split over as many lines as are needed
+func (c *Client) sendChunked(command, target, message string) error {
+	msg := ircmsg.MakeMessage(nil, "", command, target, message)
+
+	split, err := c.conn().SplitMessage(&msg)
+	if err != nil {
+		return fmt.Errorf("client.sendchunked: %w", err)
+	}
+
+	for _, m := range split {
+		if err := c.WriteIRC(command, m.Params...); err != nil {
 			return err
 		}
 	}
@@ -62,15 +86,9 @@ func (c *Client) SendNoticef(target, format string, args ...interface{}) error {
 // message, it will split it into chunks, and send each one individually.
 //
 // This is mostly intended for use with things like chatcommand.Handler that dont know how long their messages
-// will be. If you can, you likely want to do this manually, as the chunking this uses is not intelligent at all.
+// will be. Chunks are split as described on SendMessageChunked.
 func (c *Client) SendNoticeChunked(message, target string) error {
-	for _, l := range util.ChunkMessage(message, maxMessageLength) {
-		if err := c.SendNotice(target, l); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return c.sendChunked("NOTICE", target, message)
 }
 
 // CurrentNick returns what the Client believes its current nick is. It is safe for concurrent use.
@@ -82,27 +100,56 @@ func (c *Client) CurrentNick() string {
 	return c.currentNick
 }
 
-// ToggleRawLog enables or disables raw IRC line logging
-func (c *Client) ToggleRawLog() {
-	c.config.Connection.RawLog = !c.config.Connection.RawLog
+// State returns the state tracker for the current connection, which knows about the channels we are in, their
+// members, modes, and topics, and the users we share them with. A new one is made for every connection, so dont
+// hold on to it across reconnects.
+func (c *Client) State() *state.Tracker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
 }
 
-/*
-Util functions
-Join
-Part
-Quit
-Kick
-Nick
-Privmsg
-Notice
+// Channel returns a snapshot of a channel we are in, see state.Tracker
+func (c *Client) Channel(name string) (state.Channel, bool) {
+	return c.State().Channel(name)
+}
 
-these should be ISUPPORT aware where possible, and so on
+// User returns a snapshot of a user we share a channel with, see state.Tracker
+func (c *Client) User(nick string) (user.User, bool) {
+	return c.State().User(nick)
+}
 
-*/
+// Sender returns a snapshot of the user that sent msg, if we share a channel with them. It is up to date with any
+// changes made by msg itself.
+func (c *Client) Sender(msg *event.Message) (user.User, bool) {
+	return c.State().Sender(msg)
+}
 
-// func (c *Client) Join(channels ...string) {}
+// QueueLen returns the number of lines waiting to be sent by flood control, see connection.FloodConfig
+func (c *Client) QueueLen() int {
+	return c.conn().QueueLen()
+}
 
-// func (c *Client) Part(channels ...string) {}
+// InboundStats returns metrics about the queue of incoming lines waiting to be handled, see connection.InboundConfig
+func (c *Client) InboundStats() connection.InboundStats {
+	return c.conn().InboundStats()
+}
 
-// func (c *Client) Nick(newNickname string) {}
+// Lag returns the most recently measured round trip time to the server, or 0 if it hasn't been measured.
+// Lag is only measured if connection.Config.Keepalive is set.
+func (c *Client) Lag() time.Duration {
+	return c.conn().Lag()
+}
+
+// ToggleRawLog enables or disables raw IRC line logging. It is safe to call at any time, and the setting is
+// kept across reconnects.
+func (c *Client) ToggleRawLog() {
+	c.mu.Lock()
+	c.rawLog = !c.rawLog
+	enabled := c.rawLog
+	conn := c.connection
+	c.mu.Unlock()
+
+	conn.SetRawLog(enabled)
+}
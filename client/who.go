@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"awesome-dragon.science/go/irc/numerics"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// whoxFields are the WHOX fields requested by Who, in the order the server replies with them:
+// token, channel, user, ip, host, server, nick, flags, hopcount, idle, account, realname.
+// https://ircv3.net/specs/extensions/whox
+const whoxFields = "tcuihsnfdlar"
+
+// whoxHiddenIP is sent in place of an IP address that we are not allowed to see
+const whoxHiddenIP = "255.255.255.255"
+
+// WhoReply is a single user from a WHO query
+type WhoReply struct {
+	user.User // Nick, user, host, and real name, and with WHOX, account and IP
+
+	Channel  string        // A channel the user is in, or "*"
+	Server   string        // The server the user is connected to
+	Flags    string        // The raw flags, such as "H*@"
+	Oper     bool          // Whether or not the user is an IRC operator
+	Prefixes string        // The user's prefixes in Channel, such as "@+"
+	Hops     int           // How many servers away the user is
+	Idle     time.Duration // How long the user has been idle for, only with WHOX
+	WHOX     bool          // Whether or not this came from a WHOX reply, and has Account, RealIP, and Idle
+}
+
+// Who sends a WHO query for mask, which may be a channel or a nick or mask, and returns the users the server
+// replied with. If the server supports WHOX, it is used to also get accounts, IPs, and idle times. Anything
+// learnt about users we share channels with is added to the state tracker, see state.Tracker.Learn.
+func (c *Client) Who(ctx context.Context, mask string) ([]WhoReply, error) {
+	is := c.conn().ISupport
+	params, token := []string{mask}, ""
+
+	if is.HasToken("WHOX") {
+		// The token tells our replies apart from anyone else's, it can be up to three digits
+		token = strconv.Itoa(int(atomic.AddUint32(&c.whoxToken, 1)%999) + 1)
+		params = append(params, "%"+whoxFields+","+token)
+	}
+
+	res, err := c.Do(ctx, "WHO", params...)
+	if err != nil {
+		return nil, fmt.Errorf("client.who: %w", err)
+	}
+
+	replies, err := parseWhoReplies(res, token, is.Prefix())
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := c.State()
+	for _, reply := range replies {
+		tracker.Learn(reply.User)
+	}
+
+	return replies, nil
+}
+
+// parseWhoReplies parses the RPL_WHOREPLY lines in res, or RPL_WHOSPCRPL lines with the given token if it is set
+func parseWhoReplies(res *Response, token string, prefixes map[rune]rune) ([]WhoReply, error) {
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("client.who: %w", err)
+	}
+
+	out := []WhoReply{}
+
+	for _, msg := range res.Messages {
+		var (
+			reply WhoReply
+			ok    bool
+		)
+
+		switch {
+		case token == "" && msg.Command == numerics.RPL_WHOREPLY:
+			reply, ok = parseWhoReply(msg)
+		case token != "" && msg.Command == numerics.RPL_WHOSPCRPL:
+			reply, ok = parseWhoxReply(msg, token)
+		}
+
+		if !ok {
+			continue
+		}
+
+		reply.parseFlags(prefixes)
+		out = append(out, reply)
+	}
+
+	return out, nil
+}
+
+// parseWhoReply parses an RPL_WHOREPLY:
+// :server 352 us channel user host server nick flags :hopcount realname
+func parseWhoReply(msg *ircmsg.Message) (WhoReply, bool) {
+	if len(msg.Params) < 8 {
+		return WhoReply{}, false
+	}
+
+	p := msg.Params
+	reply := WhoReply{
+		User:    user.User{NUH: ircmsg.NUH{Name: p[5], User: p[2], Host: p[3]}},
+		Channel: p[1],
+		Server:  p[4],
+		Flags:   p[6],
+	}
+
+	hops, realname := p[7], ""
+	if idx := strings.IndexByte(hops, ' '); idx != -1 {
+		hops, realname = hops[:idx], hops[idx+1:]
+	}
+
+	reply.Hops, _ = strconv.Atoi(hops)
+	reply.RealName = realname
+
+	return reply, true
+}
+
+// parseWhoxReply parses an RPL_WHOSPCRPL with the fields in whoxFields:
+// :server 354 us token channel user ip host server nick flags hopcount idle account :realname
+func parseWhoxReply(msg *ircmsg.Message, token string) (WhoReply, bool) {
+	if len(msg.Params) < len(whoxFields)+1 || msg.Params[1] != token {
+		return WhoReply{}, false
+	}
+
+	p := msg.Params
+	reply := WhoReply{
+		User: user.User{
+			NUH:      ircmsg.NUH{Name: p[7], User: p[3], Host: p[5]},
+			RealName: p[12],
+		},
+		Channel: p[2],
+		Server:  p[6],
+		Flags:   p[8],
+		WHOX:    true,
+	}
+
+	if p[4] != whoxHiddenIP {
+		reply.RealIP = net.ParseIP(p[4])
+	}
+
+	reply.Hops, _ = strconv.Atoi(p[9])
+
+	if idle, err := strconv.Atoi(p[10]); err == nil {
+		reply.Idle = time.Duration(idle) * time.Second
+	}
+
+	if p[11] != "0" {
+		reply.Account = p[11]
+	}
+
+	return reply, true
+}
+
+// parseFlags fills in Away, Oper, and Prefixes from Flags
+func (w *WhoReply) parseFlags(prefixes map[rune]rune) {
+	isPrefix := make(map[rune]bool, len(prefixes))
+	for _, p := range prefixes {
+		isPrefix[p] = true
+	}
+
+	for _, flag := range w.Flags {
+		switch {
+		case flag == 'G':
+			w.Away = true
+		case flag == '*':
+			w.Oper = true
+		case isPrefix[flag]:
+			w.Prefixes += string(flag)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Who(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "PREFIX=(ov)@+")
+
+	results := make(chan []WhoReply, 1)
+
+	go func() {
+		replies, err := c.Who(context.Background(), "#chan")
+		if err != nil {
+			t.Errorf("Who() returned an error: %v", err)
+		}
+
+		results <- replies
+	}()
+
+	server.Expect("WHO #chan")
+	server.Send(
+		":irc.test 352 test #chan alice alice.host irc.test alice H*@ :0 Alice Liddell",
+		":irc.test 352 test #chan bob bob.host leaf.test bob G+ :2 Bob",
+		":irc.test 315 test #chan :End of WHO list",
+	)
+
+	var replies []WhoReply
+	select {
+	case replies = <-results:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for Who")
+	}
+
+	if len(replies) != 2 {
+		t.Fatalf("got %d replies, want 2: %+v", len(replies), replies)
+	}
+
+	alice, bob := replies[0], replies[1]
+	if alice.Mask() != "alice!alice@alice.host" || alice.RealName != "Alice Liddell" || !alice.Oper ||
+		alice.Prefixes != "@" || alice.Away || alice.WHOX {
+		t.Errorf("alice = %+v", alice)
+	}
+
+	if bob.Server != "leaf.test" || bob.Hops != 2 || !bob.Away || bob.Prefixes != "+" || bob.Oper {
+		t.Errorf("bob = %+v", bob)
+	}
+}
+
+func TestClient_WhoX(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "WHOX PREFIX=(ov)@+")
+
+	results := make(chan []WhoReply, 1)
+
+	go func() {
+		replies, err := c.Who(context.Background(), "alice")
+		if err != nil {
+			t.Errorf("Who() returned an error: %v", err)
+		}
+
+		results <- replies
+	}()
+
+	line := server.Expect("WHO alice")
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "%"+whoxFields+",") {
+		t.Fatalf("got %q, want a WHOX query", line)
+	}
+
+	token := strings.SplitN(fields[2], ",", 2)[1]
+
+	server.Send(
+		":irc.test 354 test 999 #other someone 1.2.3.4 h irc.test someone H 0 0 0 :Another query",
+		":irc.test 354 test "+token+" #chan alice 192.0.2.1 alice.host irc.test alice H@ 0 42 aliceacct :Alice",
+		":irc.test 354 test "+token+" #chan bob 255.255.255.255 bob.host irc.test bob G 0 0 0 :Bob",
+		":irc.test 315 test alice :End of WHO list",
+	)
+
+	var replies []WhoReply
+	select {
+	case replies = <-results:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for Who")
+	}
+
+	if len(replies) != 2 {
+		t.Fatalf("got %d replies, want 2: %+v", len(replies), replies)
+	}
+
+	alice, bob := replies[0], replies[1]
+	if !alice.WHOX || alice.Account != "aliceacct" || !alice.RealIP.Equal(net.ParseIP("192.0.2.1")) ||
+		alice.Idle != time.Second*42 || alice.Prefixes != "@" || alice.RealName != "Alice" {
+		t.Errorf("alice = %+v", alice)
+	}
+
+	if bob.Account != "" || bob.RealIP != nil || !bob.Away {
+		t.Errorf("bob = %+v", bob)
+	}
+}
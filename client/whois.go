@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesome-dragon.science/go/irc/isupport"
+	"awesome-dragon.science/go/irc/numerics"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// ErrNoSuchNick is returned by Whois and Whowas when the server does not know the nick asked about
+var ErrNoSuchNick = errors.New("no such nick")
+
+// WhoisReply is the information a server gave in reply to a WHOIS
+type WhoisReply struct {
+	user.User // Nick, user, host, real name, account, away status, and actual host and IP if we may see them
+
+	Server     string        // The server the user is connected to
+	ServerInfo string        // The description of Server
+	Channels   []string      // The channels the user is in, with their prefixes, such as "@#chan"
+	Oper       bool          // Whether or not the user is an IRC operator
+	Secure     bool          // Whether or not the user is connected securely, such as with TLS
+	Idle       time.Duration // How long the user has been idle for
+	SignOn     time.Time     // When the user connected, or the zero time if the server did not say
+}
+
+// WhowasReply is a single entry from the server's history of a nick, in reply to WHOWAS
+type WhowasReply struct {
+	user.User // Nick, user, host, real name, and with some servers, account
+
+	Server     string // The server the user was connected to
+	ServerInfo string // Usually when the user disconnected, in a format that depends on the server
+}
+
+// Whois sends a WHOIS query for nick, and collects the reply. The query is sent to the user's server, so that their
+// idle time is included. If the server does not know of nick, the error wraps ErrNoSuchNick.
+func (c *Client) Whois(ctx context.Context, nick string) (*WhoisReply, error) {
+	res, err := c.Do(ctx, "WHOIS", nick, nick)
+	if err != nil {
+		return nil, fmt.Errorf("client.whois: %w", err)
+	}
+
+	is := c.conn().ISupport
+	out := &WhoisReply{User: user.User{NUH: ircmsg.NUH{Name: nick}}}
+
+	for _, msg := range replyTo(res, is, nick) {
+		p := msg.Params
+
+		switch msg.Command {
+		case numerics.ERR_NOSUCHNICK, numerics.ERR_NOSUCHSERVER:
+			// As the nick is also used as the server to ask, some servers say there is no such server instead
+			return nil, fmt.Errorf("client.whois: %w: %s", ErrNoSuchNick, nick)
+
+		case numerics.RPL_WHOISUSER:
+			if len(p) >= 6 {
+				out.NUH = ircmsg.NUH{Name: p[1], User: p[2], Host: p[3]}
+				out.RealName = p[5]
+			}
+
+		case numerics.RPL_WHOISSERVER:
+			if len(p) >= 4 {
+				out.Server, out.ServerInfo = p[2], p[3]
+			}
+
+		case numerics.RPL_WHOISCHANNELS:
+			out.Channels = append(out.Channels, strings.Fields(p[len(p)-1])...)
+
+		case numerics.RPL_WHOISIDLE:
+			out.parseIdle(p)
+
+		case numerics.RPL_WHOISACCOUNT:
+			if len(p) >= 3 {
+				out.Account = p[2]
+			}
+
+		case numerics.RPL_WHOISACTUALLY:
+			// Servers disagree on the format here, but all send some of user@host, host, and IP between the nick
+			// and the trailing text
+			if len(p) >= 3 {
+				out.parseHosts(p[2 : len(p)-1])
+			}
+
+		case numerics.RPL_WHOISHOST:
+			// :server 378 us nick :is connecting from *@host ip
+			out.parseHosts(strings.Fields(p[len(p)-1]))
+
+		case numerics.RPL_WHOISOPERATOR:
+			out.Oper = true
+
+		case numerics.RPL_WHOISSECURE:
+			out.Secure = true
+
+		case numerics.RPL_AWAY:
+			out.Away, out.AwayMessage = true, p[len(p)-1]
+		}
+	}
+
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("client.whois: %w", err)
+	}
+
+	return out, nil
+}
+
+// Whowas sends a WHOWAS query for nick, and returns the server's history of it, most recent first. If the server has
+// no history of nick, the error wraps ErrNoSuchNick.
+func (c *Client) Whowas(ctx context.Context, nick string) ([]WhowasReply, error) {
+	res, err := c.Do(ctx, "WHOWAS", nick)
+	if err != nil {
+		return nil, fmt.Errorf("client.whowas: %w", err)
+	}
+
+	is := c.conn().ISupport
+	out := []WhowasReply{}
+
+	for _, msg := range replyTo(res, is, nick) {
+		p := msg.Params
+
+		switch msg.Command {
+		case numerics.ERR_WASNOSUCHNICK:
+			return nil, fmt.Errorf("client.whowas: %w: %s", ErrNoSuchNick, nick)
+
+		case numerics.RPL_WHOWASUSER:
+			// Each entry starts with one of these, and the lines after it are about the same entry
+			if len(p) >= 6 {
+				out = append(out, WhowasReply{
+					User: user.User{NUH: ircmsg.NUH{Name: p[1], User: p[2], Host: p[3]}, RealName: p[5]},
+				})
+			}
+
+		case numerics.RPL_WHOISSERVER:
+			if len(p) >= 4 && len(out) > 0 {
+				out[len(out)-1].Server, out[len(out)-1].ServerInfo = p[2], p[3]
+			}
+
+		case numerics.RPL_WHOISACCOUNT:
+			if len(p) >= 3 && len(out) > 0 {
+				out[len(out)-1].Account = p[2]
+			}
+		}
+	}
+
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("client.whowas: %w", err)
+	}
+
+	return out, nil
+}
+
+// replyTo returns the lines in res that are about nick, which is the second parameter on WHOIS and WHOWAS numerics.
+// Without labeled-response, this drops replies to other queries that happened to arrive at the same time.
+func replyTo(res *Response, is *isupport.ISupport, nick string) []*ircmsg.Message {
+	folded := is.Casefold(nick)
+	out := make([]*ircmsg.Message, 0, len(res.Messages))
+
+	for _, msg := range res.Messages {
+		if len(msg.Params) >= 2 && is.Casefold(msg.Params[1]) == folded {
+			out = append(out, msg)
+		}
+	}
+
+	return out
+}
+
+// parseIdle parses an RPL_WHOISIDLE:
+// :server 317 us nick idle signon :seconds idle, signon time
+// Some servers leave out the signon time.
+func (w *WhoisReply) parseIdle(params []string) {
+	if len(params) < 3 {
+		return
+	}
+
+	if idle, err := strconv.ParseInt(params[2], 10, 64); err == nil {
+		w.Idle = time.Duration(idle) * time.Second
+	}
+
+	if len(params) < 4 {
+		return
+	}
+
+	if signon, err := strconv.ParseInt(params[3], 10, 64); err == nil {
+		w.SignOn = time.Unix(signon, 0)
+	}
+}
+
+// parseHosts fills in RealIP and RealHost from a list of IPs, hosts, and user@host masks
+func (w *WhoisReply) parseHosts(fields []string) {
+	for _, field := range fields {
+		if idx := strings.LastIndexByte(field, '@'); idx != -1 {
+			field = field[idx+1:]
+		}
+
+		if ip := net.ParseIP(field); ip != nil {
+			w.RealIP = ip
+
+			continue
+		}
+
+		if strings.ContainsAny(field, ".:") {
+			w.RealHost = field
+		}
+	}
+}
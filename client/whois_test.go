@@ -0,0 +1,179 @@
+package client //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type whoisResult struct {
+	reply *WhoisReply
+	err   error
+}
+
+func startWhois(ctx context.Context, c *Client, nick string) <-chan whoisResult {
+	out := make(chan whoisResult, 1)
+
+	go func() {
+		reply, err := c.Whois(ctx, nick)
+		out <- whoisResult{reply: reply, err: err}
+	}()
+
+	return out
+}
+
+func waitWhois(t *testing.T, results <-chan whoisResult) whoisResult {
+	t.Helper()
+
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for Whois to return")
+	}
+
+	return whoisResult{}
+}
+
+func TestClient_Whois(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "CASEMAPPING=rfc1459")
+	results := startWhois(context.Background(), c, "Alice")
+
+	server.Expect("WHOIS Alice Alice")
+	server.Send(
+		":irc.test 311 test alice al alice.host * :Alice Liddell",
+		":irc.test 319 test alice :@#chan +#other",
+		":irc.test 319 test alice :#third",
+		":irc.test 312 test alice leaf.test :A leaf server",
+		":irc.test 311 test bob bob bob.host * :Someone else's reply",
+		":irc.test 313 test alice :is an IRC Operator",
+		":irc.test 301 test alice :gone fishing",
+		":irc.test 671 test alice :is using a secure connection",
+		":irc.test 338 test alice al@real.host 192.0.2.1 :actually using host",
+		":irc.test 317 test ALICE 42 1600000000 :seconds idle, signon time",
+		":irc.test 330 test alice aliceacct :is logged in as",
+		":irc.test 318 test alice :End of /WHOIS list.",
+	)
+
+	res := waitWhois(t, results)
+	if res.err != nil {
+		t.Fatalf("Whois() returned an error: %v", res.err)
+	}
+
+	w := res.reply
+	if w.Mask() != "alice!al@alice.host" || w.RealName != "Alice Liddell" || w.Account != "aliceacct" {
+		t.Errorf("user = %+v", w.User)
+	}
+
+	if len(w.Channels) != 3 || w.Channels[0] != "@#chan" || w.Channels[2] != "#third" {
+		t.Errorf("Channels = %v, want [@#chan +#other #third]", w.Channels)
+	}
+
+	if w.Server != "leaf.test" || !w.Oper || !w.Secure || !w.Away || w.AwayMessage != "gone fishing" {
+		t.Errorf("reply = %+v", w)
+	}
+
+	if w.Idle != time.Second*42 || !w.SignOn.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("Idle, SignOn = %v, %v, want 42s, %v", w.Idle, w.SignOn, time.Unix(1600000000, 0))
+	}
+
+	if w.RealHost != "real.host" || !w.RealIP.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("RealHost, RealIP = %q, %v", w.RealHost, w.RealIP)
+	}
+}
+
+func TestClient_WhoisErrors(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "CASEMAPPING=ascii")
+
+	results := startWhois(context.Background(), c, "nobody")
+
+	server.Expect("WHOIS nobody")
+	server.Send(
+		":irc.test 401 test nobody :No such nick/channel",
+		":irc.test 318 test nobody :End of /WHOIS list.",
+	)
+
+	if res := waitWhois(t, results); !errors.Is(res.err, ErrNoSuchNick) {
+		t.Errorf("Whois() = %+v, %v, want %v", res.reply, res.err, ErrNoSuchNick)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := c.Whois(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Whois() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClient_Whowas(t *testing.T) {
+	t.Parallel()
+
+	c, server := newISupportClient(t, "CASEMAPPING=ascii")
+
+	results := make(chan []WhowasReply, 1)
+
+	go func() {
+		replies, err := c.Whowas(context.Background(), "alice")
+		if err != nil {
+			t.Errorf("Whowas() returned an error: %v", err)
+		}
+
+		results <- replies
+	}()
+
+	server.Expect("WHOWAS alice")
+	server.Send(
+		":irc.test 314 test alice al new.host * :Alice",
+		":irc.test 312 test alice leaf.test :Sat Oct 17 12:00:00 2026",
+		":irc.test 330 test alice aliceacct :was logged in as",
+		":irc.test 314 test alice al old.host * :Alice",
+		":irc.test 312 test alice hub.test :Fri Oct 16 12:00:00 2026",
+		":irc.test 369 test alice :End of WHOWAS",
+	)
+
+	var replies []WhowasReply
+	select {
+	case replies = <-results:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for Whowas")
+	}
+
+	if len(replies) != 2 {
+		t.Fatalf("got %d replies, want 2: %+v", len(replies), replies)
+	}
+
+	if replies[0].Host != "new.host" || replies[0].Server != "leaf.test" || replies[0].Account != "aliceacct" {
+		t.Errorf("first entry = %+v", replies[0])
+	}
+
+	if replies[1].Host != "old.host" || replies[1].Server != "hub.test" || replies[1].Account != "" {
+		t.Errorf("second entry = %+v", replies[1])
+	}
+
+	go func() {
+		_, err := c.Whowas(context.Background(), "nobody")
+		if !errors.Is(err, ErrNoSuchNick) {
+			t.Errorf("Whowas() error = %v, want %v", err, ErrNoSuchNick)
+		}
+
+		results <- nil
+	}()
+
+	server.Expect("WHOWAS nobody")
+	server.Send(
+		":irc.test 406 test nobody :There was no such nickname",
+		":irc.test 369 test nobody :End of WHOWAS",
+	)
+
+	select {
+	case <-results:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for Whowas")
+	}
+}
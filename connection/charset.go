@@ -0,0 +1,173 @@
+package connection
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"golang.org/x/text/encoding"
+)
+
+// CharsetConfig configures how lines that are not UTF-8 are handled. Everything is UTF-8 inside the library,
+// this only affects what is sent and received on the wire.
+//
+// If the server advertises the UTF8ONLY ISUPPORT token, or the connection is a text mode WebSocket, all lines
+// are treated as UTF-8 and these options are ignored.
+type CharsetConfig struct {
+	// Strict causes incoming lines that are not valid UTF-8, and that cannot be decoded with a configured
+	// charset, to be dropped. Otherwise, invalid sequences are replaced with U+FFFD.
+	Strict bool
+
+	// Fallback is used to decode incoming lines that are not valid UTF-8, for example
+	// charmap.Windows1252 from golang.org/x/text/encoding/charmap.
+	Fallback encoding.Encoding
+	// EncodeFallback causes outgoing lines to be encoded with Fallback, rather than sent as UTF-8
+	EncodeFallback bool
+
+	// Channels overrides the charset for lines sent to and from specific channels (or users). Lines to these
+	// targets are always decoded and encoded with the given charset. Keys are compared case insensitively.
+	Channels map[string]encoding.Encoding
+}
+
+// charset returns the CharsetConfig to use right now, or nil if everything should be treated as UTF-8
+func (s *Connection) charset() *CharsetConfig {
+	textWebSocket := s.config.WebSocket != nil && !s.config.WebSocket.Binary
+	if s.config.Charset == nil || textWebSocket || s.ISupport.UTF8Only() {
+		return nil
+	}
+
+	return s.config.Charset
+}
+
+// channelCharset returns the charset override for the given target, if any
+func (c *CharsetConfig) channelCharset(target string) encoding.Encoding {
+	for name, enc := range c.Channels {
+		if strings.EqualFold(name, target) {
+			return enc
+		}
+	}
+
+	return nil
+}
+
+// decodeLine parses a line from the server, converting it to UTF-8 as configured. If the line should be
+// dropped, false is returned.
+func (s *Connection) decodeLine(data string) (ircmsg.Message, bool) {
+	msg, err := ircmsg.ParseLine(data)
+	if err != nil {
+		s.log.Warn("Got an invalid IRC line", "line", data, "error", err)
+
+		return msg, false
+	}
+
+	charset := s.charset()
+	if charset == nil {
+		if !utf8.ValidString(data) {
+			replaceInvalid(&msg)
+		}
+
+		return msg, true
+	}
+
+	var enc encoding.Encoding
+	if len(msg.Params) > 0 {
+		enc = charset.channelCharset(msg.Params[0])
+	}
+
+	if enc == nil && utf8.ValidString(data) {
+		return msg, true
+	}
+
+	if enc == nil {
+		enc = charset.Fallback
+	}
+
+	if enc == nil {
+		if charset.Strict {
+			s.log.Warn("Dropping line that is not valid UTF-8", "command", msg.Command, "line", data)
+
+			return msg, false
+		}
+
+		replaceInvalid(&msg)
+
+		return msg, true
+	}
+
+	decoder := enc.NewDecoder()
+	if err := decodeParams(&msg, decoder.String); err != nil {
+		s.log.Warn("Could not decode line", "command", msg.Command, "line", data, "error", err)
+
+		return msg, false
+	}
+
+	return msg, true
+}
+
+// replaceInvalid replaces invalid UTF-8 in the source and parameters of msg with U+FFFD
+func replaceInvalid(msg *ircmsg.Message) {
+	msg.Source = strings.ToValidUTF8(msg.Source, string(utf8.RuneError))
+
+	for i, p := range msg.Params {
+		msg.Params[i] = strings.ToValidUTF8(p, string(utf8.RuneError))
+	}
+}
+
+// decodeParams runs the source and parameters of msg through decode. Tags are always UTF-8 so are left alone.
+func decodeParams(msg *ircmsg.Message, decode func(string) (string, error)) error {
+	source, err := decode(msg.Source)
+	if err != nil {
+		return err //nolint:wrapcheck // Its wrapped by the caller
+	}
+
+	msg.Source = source
+
+	for i, p := range msg.Params {
+		if msg.Params[i], err = decode(p); err != nil {
+			return err //nolint:wrapcheck // Its wrapped by the caller
+		}
+	}
+
+	return nil
+}
+
+// encodeMessage returns msg encoded in the charset it should be sent in, and whether or not that is different
+// to UTF-8. Characters that cannot be represented in the target charset are replaced.
+func (s *Connection) encodeMessage(msg *ircmsg.Message) (*ircmsg.Message, bool) {
+	charset := s.charset()
+	if charset == nil {
+		return msg, false
+	}
+
+	var enc encoding.Encoding
+	if len(msg.Params) > 0 {
+		enc = charset.channelCharset(msg.Params[0])
+	}
+
+	if enc == nil && charset.EncodeFallback {
+		enc = charset.Fallback
+	}
+
+	if enc == nil {
+		return msg, false
+	}
+
+	encoder := encoding.ReplaceUnsupported(enc.NewEncoder())
+	params := make([]string, len(msg.Params))
+
+	for i, p := range msg.Params {
+		encoded, err := encoder.String(p)
+		if err != nil {
+			// Cant happen with ReplaceUnsupported, but just in case
+			s.log.Warn("Could not encode parameter", "command", msg.Command, "param", p, "error", err)
+
+			encoded = p
+		}
+
+		params[i] = encoded
+	}
+
+	out := ircmsg.MakeMessage(msg.AllTags(), msg.Source, msg.Command, params...)
+
+	return &out, true
+}
@@ -0,0 +1,116 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestConnection_decodeLine(t *testing.T) {
+	t.Parallel()
+
+	latin1 := ":nick!u@h PRIVMSG #chan :caf\xe9\r\n"
+	koi8 := ":nick!u@h PRIVMSG #ru :\xf0\xd2\xc9\xd7\xc5\xd4\r\n" // Привет
+
+	charset := &CharsetConfig{
+		Fallback: charmap.Windows1252,
+		Channels: map[string]encoding.Encoding{"#RU": charmap.KOI8R},
+	}
+
+	tests := []struct {
+		name     string
+		charset  *CharsetConfig
+		isupport string
+		line     string
+		want     []string
+		wantDrop bool
+	}{
+		{name: "utf8", charset: charset, line: ":nick!u@h PRIVMSG #chan :café\r\n", want: []string{"#chan", "café"}},
+		{name: "fallback", charset: charset, line: latin1, want: []string{"#chan", "café"}},
+		{name: "channel override", charset: charset, line: koi8, want: []string{"#ru", "Привет"}},
+		{name: "no config", line: latin1, want: []string{"#chan", "caf�"}},
+		{name: "strict", charset: &CharsetConfig{Strict: true}, line: latin1, wantDrop: true},
+		{name: "utf8only", charset: charset, isupport: "UTF8ONLY", line: latin1, want: []string{"#chan", "caf�"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := NewConnection(&Config{Charset: tt.charset})
+			if tt.isupport != "" {
+				conn.ISupport.Parse(&ircmsg.Message{Command: "005", Params: []string{"test", tt.isupport, "are supported"}})
+			}
+
+			msg, ok := conn.decodeLine(tt.line)
+			if ok == tt.wantDrop {
+				t.Fatalf("decodeLine() ok = %t, want %t", ok, !tt.wantDrop)
+			}
+
+			if ok && !reflect.DeepEqual(msg.Params, tt.want) {
+				t.Errorf("decodeLine() params = %q, want %q", msg.Params, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnection_WriteEncoded(t *testing.T) {
+	t.Parallel()
+
+	clientSide, serverSide := net.Pipe()
+	t.Cleanup(func() { serverSide.Close() })
+
+	conn := NewConnection(&Config{
+		Dial: SingleConnDialer(clientSide),
+		Charset: &CharsetConfig{
+			Fallback: charmap.Windows1252,
+			Channels: map[string]encoding.Encoding{"#ru": charmap.KOI8R},
+		},
+	})
+
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	lines := make(chan string, 10)
+
+	go func() {
+		reader := bufio.NewReader(serverSide)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			lines <- line
+		}
+	}()
+
+	tests := []struct {
+		target string
+		text   string
+		want   string
+	}{
+		// EncodeFallback is not set, so this is sent as UTF-8
+		{target: "#chan", text: "café", want: "PRIVMSG #chan café\r\n"},
+		{target: "#ru", text: "Привет", want: "PRIVMSG #ru \xf0\xd2\xc9\xd7\xc5\xd4\r\n"},
+	}
+
+	for _, tt := range tests {
+		if err := conn.WriteLine("PRIVMSG", tt.target, tt.text); err != nil {
+			t.Fatalf("WriteLine() error = %s", err)
+		}
+
+		if got := <-lines; got != tt.want {
+			t.Errorf("sent %q, want %q", got, tt.want)
+		}
+	}
+}
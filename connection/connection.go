@@ -9,17 +9,17 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"awesome-dragon.science/go/irc/isupport"
+	"awesome-dragon.science/go/irc/logger"
 	"awesome-dragon.science/go/irc/numerics"
 	"github.com/ergochat/irc-go/ircmsg"
-	"github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger("irc") //nolint:gochecknoglobals // Its the logger.
-
 // Config contains all the configuration options used by Server
 type Config struct {
 	Host                  string // Hostname of the target server
@@ -28,31 +28,133 @@ type Config struct {
 	InsecureSkipVerifyTLS bool   // Skip verifying TLS Certificates
 	TLSCertPath           string
 	TLSKeyPath            string
-	RawLog                bool // Log raw messages
+	RawLog                bool // Log raw messages. See also RawLogSinks, and Connection.SetRawLog
+
+	// Servers, if set, replaces Host, Port, and the TLS options with a list of servers to rotate through.
+	// Connect tries each in turn until one can be connected to. See ServerRotation.
+	Servers []Server
+	// RandomiseServers shuffles Servers, rather than trying them in order
+	RandomiseServers bool
+	// Rotation, if set, is used instead of creating a new ServerRotation from Servers. Share one between
+	// Connections to carry on rotating where the last one left off when reconnecting.
+	Rotation *ServerRotation
+	// BindAddress, if set, is the local IP address to connect from, for example to use a specific vhost.
+	// A port may also be given. It is not used with a custom Dial.
+	BindAddress string
+	// AddressFamily chooses whether to connect over IPv4, IPv6, or both. It is not used with a custom Dial.
+	AddressFamily AddressFamily
+
+	// PrepareServer, if set, is called with each server just before it is connected to, and may return a
+	// changed copy of it. The client uses this to enforce STS policies.
+	PrepareServer func(Server) Server
+
+	// Logger receives everything the connection logs, with the server it is connected to added as a field.
+	// If nil, logger.Default() is used. Use logger.Discard to silence it entirely.
+	Logger logger.Logger
+
+	// Dial, if set, replaces the built in dialer. TLS and WebSocket are still done over the returned connection
+	// if configured, but Proxy is ignored. See SingleConnDialer for using an already open connection.
+	Dial DialFunc
+
+	// Proxy, if set, causes all connections to be made through the given proxy
+	Proxy *ProxyConfig
+
+	// WebSocket, if set, causes connections to be made over WebSocket, rather than a raw socket
+	WebSocket *WebSocketConfig
+
+	// Keepalive enables sending PINGs to the server when the connection is idle, to measure lag, and to detect
+	// connections that have died without being closed. If nil, only the server's PINGs are relied on.
+	Keepalive *KeepaliveConfig
+
+	// Inbound configures the queue of incoming lines. If nil, DefaultInboundQueueSize lines are buffered, and
+	// lines past that are held in memory until they are read, up to DefaultInboundHardLimit, see OverflowBuffer.
+	Inbound *InboundConfig
+
+	// Flood enables an outgoing send queue with rate limiting. If nil, lines are sent as soon as they're written
+	Flood *FloodConfig
+
+	// LongLines decides what happens to lines that would be truncated by the server. See LongLinePolicy
+	LongLines LongLinePolicy
+
+	// RawLogSinks receive raw lines when RawLog is enabled, with secrets redacted. If empty, lines are
+	// logged to Logger.
+	RawLogSinks []RawLogSink
+
+	// Record receives every line sent and received, redacted, regardless of RawLog. Use it with NewJSONSink
+	// to record a transcript that can be replayed later, see the transcript package.
+	Record RawLogSink
+
+	// Charset configures decoding and encoding of lines that are not UTF-8. If nil, everything is assumed
+	// to be UTF-8, and invalid sequences in incoming lines are replaced with U+FFFD.
+	Charset *CharsetConfig
 }
 
 // Connection implements the barebones required to make a connection to an IRC server.
 //
 // It expects that you do EVERYTHING yourself. It simply is a nice frontend for the socket.
 type Connection struct {
-	config *Config
+	inbound  inboundStats // First, so its 64 bit values are aligned for atomic access
+	config   *Config
+	log      logger.Logger
+	rotation *ServerRotation
+
+	serverMu sync.Mutex
+	server   Server // The server we connected to
+	quit     int32  // Set once we have sent a QUIT, accessed atomically
+
+	redirected bool // Set once the server has redirected us with RPL_BOUNCE, only used from the read loop
 
+	connMu        sync.RWMutex // Protects conn, connectionCtx, and cancelConnCtx, which are set by Connect
 	conn          net.Conn
 	connectionCtx context.Context // nolint:containedctx // Used to hold onto tne entire connection
 	cancelConnCtx context.CancelFunc
-	lineChan      chan *ircmsg.Message // Incoming lines
-	writeMutex    sync.Mutex           // Protects the write socket
+	lineChan      chan *ircmsg.Message // Incoming lines, handed over from inboundQueue
+	inboundQueue  *inboundQueue        // Incoming lines waiting for LineChan to be read
+	immediateMu   sync.Mutex
+	immediate     func(*ircmsg.Message) // Called with every line before it is queued
+	writeMutex    sync.Mutex            // Protects the write socket
+	queue         *sendQueue            // Outgoing lines waiting on flood control, nil if disabled
+
+	errMu sync.Mutex
+	err   error // Why the connection was closed, if known
+
+	rawLog int32 // Whether or not raw logging is enabled, accessed atomically
+
+	sourceMu sync.Mutex
+	source   string // Our own nick!user@host, if known
+
+	keepaliveMu  sync.Mutex // Protects everything below
+	lastActivity time.Time
+	pingSent     time.Time // When the outstanding keepalive PING was sent, zero if there isn't one
+	pingToken    string
+	lag          time.Duration
+	onLag        func(time.Duration)
 
 	ISupport *isupport.ISupport
 }
 
 // NewConnection creates a new Server instance ready for use
 func NewConnection(config *Config) *Connection {
-	return &Connection{
-		config:   config,
-		lineChan: make(chan *ircmsg.Message),
-		ISupport: isupport.New(),
+	out := &Connection{
+		config:       config,
+		log:          logger.Or(config.Logger),
+		rotation:     config.Rotation,
+		lineChan:     make(chan *ircmsg.Message),
+		inboundQueue: newInboundQueue(),
+		ISupport:     isupport.New(),
 	}
+
+	if out.rotation == nil {
+		out.rotation = NewServerRotation(config.ServerList(), config.RandomiseServers)
+	}
+
+	if config.Flood != nil {
+		out.queue = newSendQueue()
+	}
+
+	out.SetRawLog(config.RawLog)
+
+	return out
 }
 
 // NewSimpleServer is a nice wrapper that creates a ServerConfig for you
@@ -60,68 +162,153 @@ func NewSimpleServer(host, port string, useTLS bool) *Connection {
 	return NewConnection(&Config{Host: host, Port: port, TLS: useTLS, RawLog: true})
 }
 
-// Connect connects the Server instance to IRC. It does NOT block.
+// Connect connects the Server instance to IRC. It does NOT block. If there are multiple servers configured,
+// each is tried once, starting from the current one in the rotation, until one can be connected to.
 func (s *Connection) Connect(ctx context.Context) error {
-	connContext, cancel := context.WithTimeout(ctx, time.Second*10)
-	defer cancel()
-
-	conn, err := s.openConn(connContext)
+	conn, err := s.connectToRotation(ctx)
 	if err != nil {
-		return fmt.Errorf("could not open connection: %w", err)
+		return err
 	}
 
-	s.conn = conn
 	mainCtx, mainCancel := context.WithCancel(ctx)
 
+	s.connMu.Lock()
+	s.conn = conn
 	s.connectionCtx = mainCtx
 	s.cancelConnCtx = mainCancel
+	s.connMu.Unlock()
+
+	// Make sure the socket goes away with the context, otherwise readLoop can sit on a dead socket forever
+	go func() {
+		<-mainCtx.Done()
+		_ = conn.Close()
+	}()
 
 	// These are being used as signals
 	readCtx, readCancel := context.WithCancel(mainCtx)
 
 	_ = readCancel
 
-	go s.readLoop(readCtx)
+	go s.forwardLines(ctx)
+	go s.readLoop(readCtx, conn)
+
+	if s.queue != nil {
+		go s.writeLoop(mainCtx)
+	}
+
+	if s.config.Keepalive != nil {
+		s.markActivity()
+
+		go s.keepaliveLoop()
+	}
 
 	return nil
 }
 
-func (s *Connection) openConn(ctx context.Context) (net.Conn, error) {
-	var (
-		conn     net.Conn
-		err      error
-		hostPort = net.JoinHostPort(s.config.Host, s.config.Port)
-	)
+// connectToRotation tries each server in the rotation in turn, returning the first connection that is made
+func (s *Connection) connectToRotation(ctx context.Context) (net.Conn, error) {
+	var lastErr error
 
-	log.Debugf("Opening connection to %q...", hostPort)
+	for i, count := 0, s.rotation.Len(); i < count; i++ {
+		server, redirect, ok := s.rotation.current()
+		if !ok {
+			break
+		}
 
-	if s.config.TLS {
-		dialer := &tls.Dialer{}
-		dialer.Config = &tls.Config{InsecureSkipVerify: s.config.InsecureSkipVerifyTLS} //nolint:gosec // Its intentional
+		if s.config.PrepareServer != nil {
+			server = s.config.PrepareServer(server)
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+		conn, err := s.openConn(dialCtx, server)
 
-		if s.config.TLSCertPath != "" && s.config.TLSKeyPath != "" {
-			res, err := tls.LoadX509KeyPair(s.config.TLSCertPath, s.config.TLSKeyPath)
-			if err != nil {
-				return nil, fmt.Errorf("could not load keypair: %w", err)
+		cancel()
+
+		if err == nil {
+			if redirect {
+				// Redirects are only tried once, later connections go back to the usual servers
+				s.rotation.Next()
 			}
 
-			dialer.Config.Certificates = append(dialer.Config.Certificates, res)
+			s.serverMu.Lock()
+			s.server = server
+			s.serverMu.Unlock()
+
+			s.log = logger.With(logger.Or(s.config.Logger), "server", server.Address())
+
+			return conn, nil
+		}
+
+		lastErr = err
+
+		if ctx.Err() != nil {
+			break
 		}
 
-		conn, err = dialer.DialContext(ctx, "tcp", hostPort)
-	} else {
-		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(s.config.Host, s.config.Port))
+		s.log.Warn("Could not connect to server", "server", server.Address(), "error", err)
+		s.rotation.Next()
 	}
 
+	if lastErr == nil {
+		return nil, ErrNoServers
+	}
+
+	return nil, fmt.Errorf("could not open connection: %w", lastErr)
+}
+
+func (s *Connection) openConn(ctx context.Context, server Server) (net.Conn, error) {
+	hostPort := server.Address()
+
+	if s.config.WebSocket != nil {
+		return s.openWebSocket(ctx, server)
+	}
+
+	s.log.Debug("Opening connection", "server", hostPort)
+
+	conn, err := s.dial(ctx, hostPort)
 	if err != nil {
 		return nil, fmt.Errorf("could not dial: %w", err)
 	}
 
-	return conn, nil
+	if !server.TLS {
+		return conn, nil
+	}
+
+	tlsConfig, err := tlsConfig(server)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("could not complete TLS handshake: %w", err)
+	}
+
+	return tlsConn, nil
+}
+
+func tlsConfig(server Server) (*tls.Config, error) {
+	//nolint:gosec // Its intentional
+	config := &tls.Config{ServerName: server.Host, InsecureSkipVerify: server.InsecureSkipVerifyTLS}
+
+	if server.TLSCertPath != "" && server.TLSKeyPath != "" {
+		res, err := tls.LoadX509KeyPair(server.TLSCertPath, server.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not load keypair: %w", err)
+		}
+
+		config.Certificates = append(config.Certificates, res)
+	}
+
+	return config, nil
 }
 
-func (s *Connection) readLoop(ctx context.Context) {
-	reader := bufio.NewReader(s.conn)
+func (s *Connection) readLoop(ctx context.Context, conn net.Conn) {
+	reader := bufio.NewReader(conn)
 
 outer:
 	for {
@@ -138,48 +325,164 @@ outer:
 				break
 			}
 
-			log.Warningf("Unexpected error from conn.Read: %s", err)
+			if ctx.Err() == nil {
+				s.log.Warn("Unexpected error from conn.Read", "error", err)
+				s.setErr(err)
+			}
 
 			break
 		}
 
-		msg, err := ircmsg.ParseLine(data)
-		if err != nil {
-			log.Warningf("got an invalid IRC Line: %q -> %s", data, err)
+		s.markActivity()
 
-			continue
-		}
+		s.logRaw(DirectionIn, data)
 
-		if s.config.RawLog {
-			log.Infof("[>>] %s", data)
+		msg, ok := s.decodeLine(data)
+		if !ok {
+			continue
 		}
 
 		s.onLine(&msg)
 	}
 
-	close(s.lineChan)
-	s.cancelConnCtx()
+	s.inboundQueue.close()
+	s.cancel()
 }
 
 func (s *Connection) onLine(msg *ircmsg.Message) {
+	if s.onKeepalivePong(msg) {
+		return
+	}
+
 	switch msg.Command {
 	case numerics.RPL_ISUPPORT:
 		s.ISupport.Parse(msg)
 	case numerics.RPL_MYINFO:
+	case "ERROR", numerics.RPL_BOUNCE:
+		s.onRotationMessage(msg)
+	}
+
+	if immediate := s.immediateHandler(); immediate != nil {
+		immediate(msg)
 	}
 
-	s.lineChan <- msg
+	s.enqueue(msg)
 }
 
+// ErrNotConnected is returned when attempting to write to a Connection that has not been connected, or has
+// been disconnected
+var ErrNotConnected = errors.New("not connected")
+
+// Write implements io.Writer. If flood control is enabled, b is queued and sent later, unless its command
+// is configured to bypass the queue. b is expected to contain a single IRC line, which is checked against
+// MaxLineLength and MaxTagLength as described on Config.LongLines.
 func (s *Connection) Write(b []byte) (int, error) {
+	return s.WriteWithPriority(PriorityDefault, b)
+}
+
+// write checks the length of msg, and sends or queues b at the given priority. msg is the parsed form of b.
+// If msg is too long, it is either rejected or split, depending on Config.LongLines
+func (s *Connection) write(priority Priority, msg *ircmsg.Message, b []byte) (int, error) {
+	if msg == nil {
+		return s.writeBytes(priority, msg, b)
+	}
+
+	if strings.EqualFold(msg.Command, "QUIT") {
+		atomic.StoreInt32(&s.quit, 1)
+	}
+
+	encoded, changed := s.encodeMessage(msg)
+
+	if err := s.CheckLength(encoded); err != nil {
+		if s.config.LongLines != LongLineSplit {
+			return 0, err
+		}
+
+		// Split the original, splitting encoded text could cut a UTF-8 sequence in half
+		return s.writeSplit(priority, msg)
+	}
+
+	if changed {
+		var err error
+		if b, err = encoded.LineBytes(); err != nil {
+			return 0, fmt.Errorf("could not create IRC line: %w", err)
+		}
+	}
+
+	return s.writeBytes(priority, encoded, b)
+}
+
+// writeSplit splits msg over multiple lines and writes them all
+func (s *Connection) writeSplit(priority Priority, msg *ircmsg.Message) (int, error) {
+	split, err := s.SplitMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+
+	for _, m := range split {
+		m, _ = s.encodeMessage(m)
+
+		b, err := m.LineBytes()
+		if err != nil {
+			return total, fmt.Errorf("could not create IRC line: %w", err)
+		}
+
+		n, err := s.writeBytes(priority, m, b)
+		total += n
+
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// writeBytes sends or queues b at the given priority. msg is the parsed form of b, and is only used for
+// flood control
+func (s *Connection) writeBytes(priority Priority, msg *ircmsg.Message, b []byte) (int, error) {
+	if ctx := s.connContext(); ctx == nil || ctx.Err() != nil {
+		return 0, fmt.Errorf("Connection.Write: %w", ErrNotConnected)
+	}
+
+	if s.queue == nil {
+		return s.writeSocket(b)
+	}
+
+	if priority == PriorityDefault {
+		priority = PriorityChat
+		if msg != nil {
+			priority = s.PriorityFor(msg.Command)
+		}
+	}
+
+	if priority == PriorityControl {
+		return s.writeSocket(b)
+	}
+
+	// The write loop may have exited since the check above
+	if !s.queue.push(priority, queueTarget(msg), b) {
+		return 0, fmt.Errorf("Connection.Write: %w", ErrNotConnected)
+	}
+
+	return len(b), nil
+}
+
+// writeSocket writes directly to the underlying socket
+func (s *Connection) writeSocket(b []byte) (int, error) {
 	s.writeMutex.Lock()
 	defer s.writeMutex.Unlock()
 
-	if s.config.RawLog {
-		log.Infof("[<<] %s", b)
+	conn := s.socket()
+	if conn == nil {
+		return 0, fmt.Errorf("Connection.Write: %w", ErrNotConnected)
 	}
 
-	n, err := s.conn.Write(b)
+	s.logRaw(DirectionOut, string(b))
+
+	n, err := conn.Write(b)
 	if err != nil {
 		return n, fmt.Errorf("Connection.Write: %w", err)
 	}
@@ -189,40 +492,110 @@ func (s *Connection) Write(b []byte) (int, error) {
 
 // WriteLine constructs an ircmsg.Message and sends it to the server
 func (s *Connection) WriteLine(command string, args ...string) error {
-	msg := ircmsg.MakeMessage(nil, "", command, args...)
-
-	bytes, err := msg.LineBytes()
-	if err != nil {
-		return fmt.Errorf("could not create IRC line: %w", err)
-	}
+	return s.WriteLineWithTags(nil, command, args...)
+}
 
-	_, err = s.Write(bytes)
-	if err != nil {
-		return fmt.Errorf("WriteLine: Could not send line: %w", err)
-	}
+// ErrTagDenied is returned when attempting to send a client-only tag that the server has said it will block
+var ErrTagDenied = errors.New("tag denied by server")
 
-	return nil
+// WriteLineWithTags is like WriteLine, but includes the given IRCv3 message tags on the line.
+// Client-only tags (those prefixed with +) are checked against the CLIENTTAGDENY ISUPPORT token, and
+// ErrTagDenied is returned if any would be blocked. Checking that the server supports tags at all
+// is left to the caller.
+func (s *Connection) WriteLineWithTags(tags map[string]string, command string, args ...string) error {
+	return s.WriteLineWithPriority(PriorityDefault, tags, command, args...)
 }
 
 // WriteString implements io.StringWriter
 func (s *Connection) WriteString(m string) (int, error) { return s.Write([]byte(m)) } //nolint:gocritic // ... No
 
-// LineChan returns a read only channel that will have messages from the server
-// sent to it
+// LineChan returns a read only channel that will have messages from the server sent to it. Lines are buffered
+// as described on Config.Inbound. Once the connection is closed, lines still buffered are sent before LineChan is
+// closed, so it should be read until it is closed, or until the context given to Connect is done.
 func (s *Connection) LineChan() <-chan *ircmsg.Message { return s.lineChan }
 
-// Done returns a channel that is closed when the connection is closed.
-func (s *Connection) Done() <-chan struct{} { return s.connectionCtx.Done() }
+// Done returns a channel that is closed when the connection is closed. Before Connect has succeeded, the channel
+// is nil.
+func (s *Connection) Done() <-chan struct{} {
+	ctx := s.connContext()
+	if ctx == nil {
+		return nil
+	}
+
+	return ctx.Done()
+}
+
+// socket returns the underlying connection, or nil if Connect has not succeeded
+func (s *Connection) socket() net.Conn {
+	s.connMu.RLock()
+	defer s.connMu.RUnlock()
+
+	return s.conn
+}
+
+// connContext returns the context that lives as long as the connection, or nil if Connect has not succeeded
+func (s *Connection) connContext() context.Context {
+	s.connMu.RLock()
+	defer s.connMu.RUnlock()
+
+	return s.connectionCtx
+}
+
+// cancel closes the connection, if it has been made
+func (s *Connection) cancel() {
+	s.connMu.RLock()
+	cancel := s.cancelConnCtx
+	s.connMu.RUnlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Err returns the reason the connection was closed, if it was closed due to an error. A connection closed
+// by the server, or by Stop, has no error.
+func (s *Connection) Err() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	return s.err
+}
+
+func (s *Connection) setErr(err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
 
-// Stop stops the connection to IRC
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// closeWithError closes the connection, recording err as the reason
+func (s *Connection) closeWithError(err error) {
+	s.setErr(err)
+	s.cancel()
+}
+
+// Stop stops the connection to IRC. If flood control is enabled, the send queue is either flushed or dropped
+// before the QUIT is sent, according to the config. Stop does nothing if Connect has not succeeded, and only sends
+// a QUIT the first time it is called.
 func (s *Connection) Stop(msg string) {
-	if err := s.WriteLine("QUIT", msg); err != nil {
-		log.Infof("Failed to write quit while exiting: %s", err)
+	ctx := s.connContext()
+	if ctx == nil {
+		return
+	}
+
+	if !s.quitSent() {
+		s.flushQueue()
+
+		if err := s.WriteLine("QUIT", msg); err != nil {
+			s.log.Info("Failed to write quit while exiting", "error", err)
+		}
 	}
 
 	select {
 	case <-time.After(time.Second * 2):
-		s.cancelConnCtx()
-	case <-s.connectionCtx.Done():
+		s.cancel()
+	case <-ctx.Done():
 	}
 }
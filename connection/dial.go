@@ -0,0 +1,96 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialFunc opens a connection for a Connection to use. address is the Host and Port from Config, joined,
+// and may be ignored by implementations that know better (for example, ones that open a unix socket)
+type DialFunc func(ctx context.Context, address string) (net.Conn, error)
+
+// ErrDialerUsed is returned by dialers created with SingleConnDialer once their connection has been used
+var ErrDialerUsed = errors.New("connection already used")
+
+// SingleConnDialer returns a DialFunc that returns conn the first time its called, and ErrDialerUsed after.
+// This is useful for running a Connection or client over an already open connection, such as one end of a
+// net.Pipe.
+func SingleConnDialer(conn io.ReadWriteCloser) DialFunc {
+	var (
+		mu   sync.Mutex
+		used bool
+	)
+
+	return func(context.Context, string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if used {
+			return nil, ErrDialerUsed
+		}
+
+		used = true
+
+		return WrapReadWriteCloser(conn), nil
+	}
+}
+
+// WrapReadWriteCloser wraps the given io.ReadWriteCloser to implement net.Conn, so it can be used
+// with a DialFunc. If rwc is already a net.Conn, it is returned as is.
+// Deadlines are not supported on wrapped io.ReadWriteClosers, and are silently ignored.
+func WrapReadWriteCloser(rwc io.ReadWriteCloser) net.Conn {
+	if conn, ok := rwc.(net.Conn); ok {
+		return conn
+	}
+
+	return &rwcConn{ReadWriteCloser: rwc}
+}
+
+type rwcConn struct {
+	io.ReadWriteCloser
+}
+
+var _ net.Conn = (*rwcConn)(nil)
+
+type rwcAddr struct{}
+
+func (rwcAddr) Network() string { return "rwc" }
+func (rwcAddr) String() string  { return "rwc" }
+
+func (*rwcConn) LocalAddr() net.Addr              { return rwcAddr{} }
+func (*rwcConn) RemoteAddr() net.Addr             { return rwcAddr{} }
+func (*rwcConn) SetDeadline(time.Time) error      { return nil }
+func (*rwcConn) SetReadDeadline(time.Time) error  { return nil }
+func (*rwcConn) SetWriteDeadline(time.Time) error { return nil }
+
+// dial opens a plain connection to hostPort, through either the configured DialFunc, a proxy, or directly
+func (s *Connection) dial(ctx context.Context, hostPort string) (net.Conn, error) {
+	if s.config.Dial != nil {
+		conn, err := s.config.Dial(ctx, hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("custom dialer: %w", err)
+		}
+
+		return conn, nil
+	}
+
+	dialer, err := s.netDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	dialTCP := func(ctx context.Context, address string) (net.Conn, error) {
+		return dialFamily(ctx, dialer, s.config.AddressFamily, address)
+	}
+
+	if s.config.Proxy != nil {
+		return s.config.Proxy.dial(ctx, dialTCP, hostPort)
+	}
+
+	return dialTCP(ctx, hostPort)
+}
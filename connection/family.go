@@ -0,0 +1,275 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AddressFamily chooses which IP versions are used to connect to a server
+type AddressFamily int
+
+// Address families
+const (
+	// AddressFamilyAny connects over IPv4 or IPv6, racing them as described in RFC 6555 ("happy eyeballs"),
+	// in whatever order the resolver returns them
+	AddressFamilyAny AddressFamily = iota
+	// AddressFamilyIPv4 only connects over IPv4
+	AddressFamilyIPv4
+	// AddressFamilyIPv6 only connects over IPv6
+	AddressFamilyIPv6
+	// AddressFamilyPreferIPv4 races IPv4 and IPv6, giving IPv4 a head start of DefaultFallbackDelay
+	AddressFamilyPreferIPv4
+	// AddressFamilyPreferIPv6 races IPv4 and IPv6, giving IPv6 a head start of DefaultFallbackDelay
+	AddressFamilyPreferIPv6
+)
+
+func (a AddressFamily) String() string {
+	switch a {
+	case AddressFamilyAny:
+		return "any"
+	case AddressFamilyIPv4:
+		return "IPv4"
+	case AddressFamilyIPv6:
+		return "IPv6"
+	case AddressFamilyPreferIPv4:
+		return "prefer IPv4"
+	case AddressFamilyPreferIPv6:
+		return "prefer IPv6"
+	default:
+		return fmt.Sprintf("AddressFamily(%d)", int(a))
+	}
+}
+
+// DefaultFallbackDelay is how long the preferred address family is given before the other is tried alongside it
+const DefaultFallbackDelay = time.Millisecond * 300
+
+// Dialing errors
+var (
+	ErrInvalidBindAddress = errors.New("invalid bind address")
+	ErrNoAddresses        = errors.New("no addresses to connect to")
+)
+
+// parseBindAddress parses an IP address, optionally with a port, to bind to
+func parseBindAddress(address string) (*net.TCPAddr, error) {
+	host, port := address, "0"
+	if h, p, err := net.SplitHostPort(address); err == nil {
+		host, port = h, p
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %q is not an IP address", ErrInvalidBindAddress, address)
+	}
+
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q has an invalid port", ErrInvalidBindAddress, address)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: portNum}, nil
+}
+
+// netDialer creates a net.Dialer bound to Config.BindAddress, if set
+func (s *Connection) netDialer() (*net.Dialer, error) {
+	dialer := &net.Dialer{}
+	if s.config.BindAddress == "" {
+		return dialer, nil
+	}
+
+	addr, err := parseBindAddress(s.config.BindAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer.LocalAddr = addr
+
+	return dialer, nil
+}
+
+// dialFamily opens a TCP connection to address using the given address family
+func dialFamily(ctx context.Context, dialer *net.Dialer, family AddressFamily, address string) (net.Conn, error) {
+	network := "tcp"
+
+	switch family {
+	case AddressFamilyIPv4:
+		network = "tcp4"
+	case AddressFamilyIPv6:
+		network = "tcp6"
+	case AddressFamilyPreferIPv4, AddressFamilyPreferIPv6:
+		return dialPreferring(ctx, dialer, family == AddressFamilyPreferIPv6, address)
+	case AddressFamilyAny:
+	}
+
+	//nolint:wrapcheck // Its wrapped by the caller
+	return dialer.DialContext(ctx, network, address)
+}
+
+// dialPreferring resolves address, and races connections to its IPv4 and IPv6 addresses, giving the preferred
+// family a head start
+func dialPreferring(ctx context.Context, dialer *net.Dialer, preferIPv6 bool, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+
+	if net.ParseIP(host) != nil {
+		//nolint:wrapcheck // Its wrapped by the caller
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	resolver := dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve %q: %w", host, err)
+	}
+
+	// A bound dialer can only connect to addresses of the same family as the one it is bound to
+	var bindIPv4, bindIPv6 bool
+	if local, ok := dialer.LocalAddr.(*net.TCPAddr); ok && local != nil {
+		bindIPv4 = local.IP.To4() != nil
+		bindIPv6 = !bindIPv4
+	}
+
+	var ipv4, ipv6 []string
+
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			if !bindIPv6 {
+				ipv4 = append(ipv4, net.JoinHostPort(ip.String(), port))
+			}
+		} else if !bindIPv4 {
+			ipv6 = append(ipv6, net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	if preferIPv6 {
+		return dialParallel(ctx, dialer, ipv6, ipv4, DefaultFallbackDelay)
+	}
+
+	return dialParallel(ctx, dialer, ipv4, ipv6, DefaultFallbackDelay)
+}
+
+// dialSerial tries each of addresses in turn, returning the first connection made, or the first error
+func dialSerial(ctx context.Context, dialer *net.Dialer, addresses []string) (net.Conn, error) {
+	var firstErr error
+
+	for _, address := range addresses {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			return conn, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if firstErr == nil {
+		return nil, ErrNoAddresses
+	}
+
+	return nil, firstErr
+}
+
+// dialParallel dials primary, and fallback once delay has passed or primary has failed, returning whichever
+// connects first
+func dialParallel(
+	ctx context.Context, dialer *net.Dialer, primary, fallback []string, delay time.Duration,
+) (net.Conn, error) {
+	if len(fallback) == 0 {
+		return dialSerial(ctx, dialer, primary)
+	}
+
+	if len(primary) == 0 {
+		return dialSerial(ctx, dialer, fallback)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+
+	results := make(chan result, 2)
+	start := func(addresses []string, isPrimary bool) {
+		go func() {
+			conn, err := dialSerial(ctx, dialer, addresses)
+			results <- result{conn: conn, err: err, primary: isPrimary}
+		}()
+	}
+
+	start(primary, true)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var (
+		primaryErr      error
+		fallbackErr     error
+		fallbackStarted bool
+	)
+
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+
+				start(fallback, false)
+			}
+
+		case res := <-results:
+			if res.err == nil {
+				otherPending := fallbackErr == nil
+				if !res.primary {
+					otherPending = primaryErr == nil
+				}
+
+				if fallbackStarted && otherPending {
+					// The other dial is still going, close whatever it ends up with
+					go func() {
+						if other := <-results; other.conn != nil {
+							other.conn.Close()
+						}
+					}()
+				}
+
+				return res.conn, nil
+			}
+
+			if res.primary {
+				primaryErr = res.err
+			} else {
+				fallbackErr = res.err
+			}
+
+			if !fallbackStarted {
+				fallbackStarted = true
+
+				start(fallback, false)
+
+				continue
+			}
+
+			if primaryErr != nil && fallbackErr != nil {
+				return nil, primaryErr
+			}
+		}
+	}
+}
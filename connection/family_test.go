@@ -0,0 +1,149 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/logger"
+)
+
+func TestParseBindAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4", address: "192.0.2.1", want: "192.0.2.1:0"},
+		{name: "ipv4 port", address: "192.0.2.1:4000", want: "192.0.2.1:4000"},
+		{name: "ipv6", address: "2001:db8::1", want: "[2001:db8::1]:0"},
+		{name: "ipv6 brackets", address: "[2001:db8::1]", want: "[2001:db8::1]:0"},
+		{name: "ipv6 port", address: "[2001:db8::1]:4000", want: "[2001:db8::1]:4000"},
+		{name: "hostname", address: "irc.test", wantErr: true},
+		{name: "bad port", address: "192.0.2.1:port", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseBindAddress(tt.address)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidBindAddress) {
+					t.Errorf("parseBindAddress() error = %v, want %v", err, ErrInvalidBindAddress)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseBindAddress() error = %s", err)
+			}
+
+			if got.String() != tt.want {
+				t.Errorf("parseBindAddress() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// acceptOne returns a channel that receives the remote address of the first connection to listener
+func acceptOne(listener net.Listener) <-chan net.Addr {
+	out := make(chan net.Addr, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(out)
+
+			return
+		}
+
+		out <- conn.RemoteAddr()
+		conn.Close()
+	}()
+
+	return out
+}
+
+func TestConnection_BindAddress(t *testing.T) {
+	t.Parallel()
+
+	listener := listen(t)
+	remote := acceptOne(listener)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// Everything in 127.0.0.0/8 is loopback, so this is a different source address that still works
+	conn := NewConnection(&Config{Host: host, Port: port, BindAddress: "127.0.0.2", Logger: logger.Discard})
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	defer conn.cancelConnCtx()
+
+	select {
+	case addr := <-remote:
+		if ip := addr.(*net.TCPAddr).IP.String(); ip != "127.0.0.2" {
+			t.Errorf("connection came from %s, want 127.0.0.2", ip)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for connection")
+	}
+}
+
+func TestDialFamily(t *testing.T) {
+	t.Parallel()
+
+	listener := listen(t)
+	go acceptOne(listener)
+
+	conn, err := dialFamily(context.Background(), &net.Dialer{}, AddressFamilyIPv4, listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialFamily() IPv4 error = %s", err)
+	}
+
+	conn.Close()
+
+	if _, err := dialFamily(context.Background(), &net.Dialer{}, AddressFamilyIPv6, listener.Addr().String()); err == nil {
+		t.Error("dialFamily() IPv6 to an IPv4 address succeeded")
+	}
+}
+
+func TestDialParallel(t *testing.T) {
+	t.Parallel()
+
+	dead := listen(t)
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	alive := listen(t)
+	remote := acceptOne(alive)
+
+	dialer := &net.Dialer{}
+
+	conn, err := dialParallel(context.Background(), dialer, []string{deadAddr}, []string{alive.Addr().String()}, time.Hour)
+	if err != nil {
+		t.Fatalf("dialParallel() error = %s", err)
+	}
+
+	conn.Close()
+
+	if addr := <-remote; addr == nil {
+		t.Error("fallback address was not connected to")
+	}
+
+	if _, err := dialParallel(context.Background(), dialer, []string{deadAddr}, []string{deadAddr}, 0); err == nil {
+		t.Error("dialParallel() to dead addresses succeeded")
+	}
+
+	if _, err := dialParallel(context.Background(), dialer, nil, nil, 0); !errors.Is(err, ErrNoAddresses) {
+		t.Errorf("dialParallel() with no addresses error = %v, want %v", err, ErrNoAddresses)
+	}
+}
@@ -0,0 +1,397 @@
+package connection
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Defaults for FloodConfig. They mirror what most clients have used for years.
+const (
+	DefaultFloodBurst    = 5
+	DefaultFloodInterval = time.Second * 2
+	DefaultFlushTimeout  = time.Second * 10
+)
+
+// DefaultFloodBypass is the set of commands that skip the send queue unless FloodConfig.Bypass is set.
+// These are either required to keep the connection alive, or are only sent during registration.
+var DefaultFloodBypass = []string{"PONG", "QUIT", "CAP", "AUTHENTICATE"} //nolint:gochecknoglobals // Its a default
+
+// FloodConfig configures the outgoing send queue on a Connection
+type FloodConfig struct {
+	// Limiter decides when a queued line may be sent. If nil, a TokenBucket is created using the
+	// defaults above.
+	Limiter Limiter
+	// Bypass is a list of commands that are sent immediately at PriorityControl, regardless of the queue.
+	// If nil, DefaultFloodBypass is used.
+	Bypass []string
+	// Moderation is a list of commands that are queued at PriorityModeration, ahead of everything else.
+	// If nil, DefaultModerationCommands is used.
+	Moderation []string
+	// DropOnStop causes any queued lines to be dropped when Stop is called, rather than flushed
+	DropOnStop bool
+	// FlushTimeout is the maximum amount of time Stop will wait for the queue to be flushed
+	FlushTimeout time.Duration
+}
+
+func (f *FloodConfig) bypasses(command string) bool {
+	bypass := f.Bypass
+	if bypass == nil {
+		bypass = DefaultFloodBypass
+	}
+
+	return containsFold(bypass, command)
+}
+
+func (f *FloodConfig) moderation() []string {
+	if f.Moderation == nil {
+		return DefaultModerationCommands
+	}
+
+	return f.Moderation
+}
+
+// Limiter decides when outgoing lines may be sent. Implementations must be safe for concurrent use.
+type Limiter interface {
+	// Reserve returns 0 and records the line as sent if it may be sent at now. Otherwise, it returns how
+	// long to wait before asking again
+	Reserve(now time.Time, line []byte) time.Duration
+}
+
+// TokenBucket is a Limiter that allows Burst lines to be sent immediately, and then one line every Interval
+type TokenBucket struct {
+	mu       sync.Mutex
+	burst    float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+var _ Limiter = (*TokenBucket)(nil)
+
+// NewTokenBucket creates a new TokenBucket that starts full
+func NewTokenBucket(burst int, interval time.Duration) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{burst: float64(burst), interval: interval, tokens: float64(burst)}
+}
+
+// Reserve implements Limiter
+func (t *TokenBucket) Reserve(now time.Time, _ []byte) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.last.IsZero() && t.interval > 0 {
+		t.tokens += float64(now.Sub(t.last)) / float64(t.interval)
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+	}
+
+	t.last = now
+
+	if t.tokens >= 1 || t.interval <= 0 {
+		t.tokens--
+
+		return 0
+	}
+
+	return time.Duration((1 - t.tokens) * float64(t.interval))
+}
+
+// PenaltyLimiter is a Limiter that implements the penalty based flood control described in RFC 1459
+// section 8.10, as used by InspIRCd and others. Every line adds Penalty, plus a second for every
+// BytesPerSecond bytes, to a timer, and lines may only be sent while that timer is less than Window
+// ahead of the current time.
+type PenaltyLimiter struct {
+	mu             sync.Mutex
+	Window         time.Duration
+	Penalty        time.Duration
+	BytesPerSecond int
+	timer          time.Time
+}
+
+var _ Limiter = (*PenaltyLimiter)(nil)
+
+// NewPenaltyLimiter returns a PenaltyLimiter with the values from RFC 1459
+func NewPenaltyLimiter() *PenaltyLimiter {
+	return &PenaltyLimiter{Window: time.Second * 10, Penalty: time.Second * 2, BytesPerSecond: 120}
+}
+
+// Reserve implements Limiter
+func (p *PenaltyLimiter) Reserve(now time.Time, line []byte) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.timer.Before(now) {
+		p.timer = now
+	}
+
+	if ahead := p.timer.Sub(now); ahead >= p.Window {
+		return ahead - p.Window + time.Millisecond
+	}
+
+	penalty := p.Penalty
+	if p.BytesPerSecond > 0 {
+		penalty += time.Second * time.Duration(len(line)/p.BytesPerSecond)
+	}
+
+	p.timer = p.timer.Add(penalty)
+
+	return 0
+}
+
+// sendQueue holds a lane for each queued priority. Lanes are drained in priority order.
+type sendQueue struct {
+	mu      sync.Mutex
+	lanes   [PriorityChat - PriorityModeration + 1]lane
+	length  int  // queued lines, including one that is currently being sent
+	closed  bool // Set once the write loop has exited, nothing can be queued after that
+	newLine chan struct{}
+}
+
+// lane is a set of per-target FIFO queues that are drained in a round-robin fashion,
+// so that one busy target cannot starve the rest
+type lane struct {
+	order []string // targets in round-robin order
+	lines map[string][][]byte
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{newLine: make(chan struct{}, 1)}
+}
+
+// queueTarget returns the key a line is queued under, for fairness
+func queueTarget(msg *ircmsg.Message) string {
+	if msg == nil || len(msg.Params) == 0 {
+		return ""
+	}
+
+	return strings.ToLower(msg.Params[0])
+}
+
+// laneFor returns the lane lines of the given priority are queued in. Anything more urgent than the
+// first lane goes in the first lane, anything less urgent than the last goes in the last.
+func (q *sendQueue) laneFor(priority Priority) *lane {
+	idx := int(priority - PriorityModeration)
+
+	switch {
+	case idx < 0:
+		idx = 0
+	case idx >= len(q.lanes):
+		idx = len(q.lanes) - 1
+	}
+
+	return &q.lanes[idx]
+}
+
+// push queues line, returning false if the queue has been closed
+func (q *sendQueue) push(priority Priority, target string, line []byte) bool {
+	q.mu.Lock()
+
+	if q.closed {
+		q.mu.Unlock()
+
+		return false
+	}
+
+	l := q.laneFor(priority)
+	if l.lines == nil {
+		l.lines = make(map[string][][]byte)
+	}
+
+	if _, exists := l.lines[target]; !exists {
+		l.order = append(l.order, target)
+	}
+
+	l.lines[target] = append(l.lines[target], line)
+	q.length++
+	q.mu.Unlock()
+
+	select {
+	case q.newLine <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// pop removes the next line from the queue, if any. sent MUST be called once the line has been dealt with
+func (q *sendQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.lanes {
+		if line, ok := q.lanes[i].pop(); ok {
+			return line, true
+		}
+	}
+
+	return nil, false
+}
+
+func (l *lane) pop() ([]byte, bool) {
+	if len(l.order) == 0 {
+		return nil, false
+	}
+
+	target := l.order[0]
+	l.order = l.order[1:]
+	lines := l.lines[target]
+	line := lines[0]
+
+	if len(lines) > 1 {
+		l.lines[target] = lines[1:]
+		l.order = append(l.order, target)
+	} else {
+		delete(l.lines, target)
+	}
+
+	return line, true
+}
+
+func (q *sendQueue) sent() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.length--
+}
+
+// Len returns the number of lines waiting to be sent
+func (q *sendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.length
+}
+
+// clear drops all queued lines, returning how many were dropped
+func (q *sendQueue) clear() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.clearLocked()
+}
+
+// close drops all queued lines and stops any more from being queued, returning how many were dropped
+func (q *sendQueue) close() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+
+	return q.clearLocked()
+}
+
+func (q *sendQueue) clearLocked() int {
+	dropped := 0
+
+	for i := range q.lanes {
+		for _, l := range q.lanes[i].lines {
+			dropped += len(l)
+		}
+
+		q.lanes[i] = lane{}
+	}
+
+	q.length -= dropped
+
+	return dropped
+}
+
+// writeLoop drains the send queue onto the socket, as fast as the limiter allows. Once ctx is done, anything
+// still queued is dropped, and further writes fail with ErrNotConnected.
+func (s *Connection) writeLoop(ctx context.Context) {
+	limiter := s.config.Flood.Limiter
+	if limiter == nil {
+		limiter = NewTokenBucket(DefaultFloodBurst, DefaultFloodInterval)
+	}
+
+	defer func() {
+		if dropped := s.queue.close(); dropped > 0 {
+			s.log.Info("Dropped queued lines after disconnecting", "dropped", dropped)
+		}
+	}()
+
+	for {
+		line, ok := s.queue.pop()
+		if !ok {
+			select {
+			case <-s.queue.newLine:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for wait := limiter.Reserve(time.Now(), line); wait > 0; wait = limiter.Reserve(time.Now(), line) {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				s.queue.sent()
+
+				return
+			}
+		}
+
+		if _, err := s.writeSocket(line); err != nil {
+			s.log.Warn("Could not send queued line", "error", err)
+		}
+
+		s.queue.sent()
+	}
+}
+
+// QueueLen returns the number of lines waiting in the send queue. It is always 0 if flood control is disabled
+func (s *Connection) QueueLen() int {
+	if s.queue == nil {
+		return 0
+	}
+
+	return s.queue.Len()
+}
+
+// flushQueue either drops or waits for the send queue to be empty, depending on config
+func (s *Connection) flushQueue() {
+	if s.queue == nil {
+		return
+	}
+
+	if s.config.Flood.DropOnStop {
+		if dropped := s.queue.clear(); dropped > 0 {
+			s.log.Info("Dropped queued lines while stopping", "dropped", dropped)
+		}
+
+		return
+	}
+
+	timeout := s.config.Flood.FlushTimeout
+	if timeout <= 0 {
+		timeout = DefaultFlushTimeout
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for s.queue.Len() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			s.log.Info("Timed out flushing send queue", "dropped", s.queue.clear())
+
+			return
+		case <-s.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	t.Parallel()
+
+	bucket := NewTokenBucket(2, time.Second)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait := bucket.Reserve(now, nil); wait != 0 {
+			t.Fatalf("Reserve() within burst = %s, want 0", wait)
+		}
+	}
+
+	if wait := bucket.Reserve(now, nil); wait != time.Second {
+		t.Errorf("Reserve() after burst = %s, want %s", wait, time.Second)
+	}
+
+	if wait := bucket.Reserve(now.Add(time.Second), nil); wait != 0 {
+		t.Errorf("Reserve() after refill = %s, want 0", wait)
+	}
+}
+
+func TestPenaltyLimiter_Reserve(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewPenaltyLimiter()
+	now := time.Now()
+
+	// 10 second window, 2 seconds per line, means 5 lines can go out immediately
+	for i := 0; i < 5; i++ {
+		if wait := limiter.Reserve(now, []byte("PRIVMSG #test :hi\r\n")); wait != 0 {
+			t.Fatalf("Reserve() within window = %s, want 0", wait)
+		}
+	}
+
+	if wait := limiter.Reserve(now, []byte("PRIVMSG #test :hi\r\n")); wait <= 0 {
+		t.Errorf("Reserve() after window = %s, want > 0", wait)
+	}
+
+	if wait := limiter.Reserve(now.Add(time.Second*3), []byte("PRIVMSG #test :hi\r\n")); wait != 0 {
+		t.Errorf("Reserve() after waiting = %s, want 0", wait)
+	}
+}
+
+func TestSendQueue_Fairness(t *testing.T) {
+	t.Parallel()
+
+	q := newSendQueue()
+	q.push(PriorityChat, "#a", []byte("a1"))
+	q.push(PriorityChat, "#a", []byte("a2"))
+	q.push(PriorityChat, "#a", []byte("a3"))
+	q.push(PriorityChat, "#b", []byte("b1"))
+	q.push(PriorityChat, "#c", []byte("c1"))
+	q.push(PriorityChat, "#b", []byte("b2"))
+
+	if q.Len() != 6 {
+		t.Errorf("Len() = %d, want 6", q.Len())
+	}
+
+	got := []string{}
+
+	for {
+		line, ok := q.pop()
+		if !ok {
+			break
+		}
+
+		got = append(got, string(line))
+		q.sent()
+	}
+
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queue order = %v, want %v", got, want)
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("Len() after drain = %d, want 0", q.Len())
+	}
+}
+
+func TestSendQueue_clear(t *testing.T) {
+	t.Parallel()
+
+	q := newSendQueue()
+	q.push(PriorityChat, "#a", []byte("a1"))
+	q.push(PriorityChat, "#b", []byte("b1"))
+
+	if dropped := q.clear(); dropped != 2 {
+		t.Errorf("clear() = %d, want 2", dropped)
+	}
+
+	if _, ok := q.pop(); ok {
+		t.Error("pop() returned a line after clear()")
+	}
+}
+
+func TestSendQueue_Priority(t *testing.T) {
+	t.Parallel()
+
+	q := newSendQueue()
+	q.push(PriorityChat, "#a", []byte("chat1"))
+	q.push(PriorityModeration, "#a", []byte("mode1"))
+	q.push(PriorityChat, "#b", []byte("chat2"))
+	q.push(PriorityModeration, "#b", []byte("mode2"))
+
+	got := []string{}
+
+	for {
+		line, ok := q.pop()
+		if !ok {
+			break
+		}
+
+		got = append(got, string(line))
+		q.sent()
+	}
+
+	want := []string{"mode1", "mode2", "chat1", "chat2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queue order = %v, want %v", got, want)
+	}
+}
+
+func TestConnection_WriteAfterDisconnect(t *testing.T) {
+	t.Parallel()
+
+	clientSide, serverSide := net.Pipe()
+	go func() { _, _ = io.Copy(io.Discard, serverSide) }()
+
+	conn := NewConnection(&Config{
+		Dial:  SingleConnDialer(clientSide),
+		Flood: &FloodConfig{Limiter: NewTokenBucket(1, time.Hour)},
+	})
+
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	for _, priority := range []Priority{PriorityChat, PriorityModeration, PriorityChat} {
+		if err := conn.WriteLineWithPriority(priority, nil, "PRIVMSG", "#chan", "hi"); err != nil {
+			t.Fatalf("WriteLineWithPriority() error = %s", err)
+		}
+	}
+
+	serverSide.Close()
+
+	select {
+	case <-conn.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the connection to close")
+	}
+
+	// The write loop may still be clearing the queue
+	for deadline := time.Now().Add(time.Second * 5); conn.QueueLen() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if n := conn.QueueLen(); n != 0 {
+		t.Errorf("QueueLen() after disconnecting = %d, want 0", n)
+	}
+
+	for _, priority := range []Priority{PriorityControl, PriorityModeration, PriorityChat} {
+		err := conn.WriteLineWithPriority(priority, nil, "PRIVMSG", "#chan", "hi")
+		if !errors.Is(err, ErrNotConnected) {
+			t.Errorf("WriteLineWithPriority(%s) after disconnecting error = %v, want %v", priority, err, ErrNotConnected)
+		}
+	}
+
+	if n := conn.QueueLen(); n != 0 {
+		t.Errorf("QueueLen() after writing to a closed connection = %d, want 0", n)
+	}
+}
+
+func TestSendQueue_close(t *testing.T) {
+	t.Parallel()
+
+	q := newSendQueue()
+	q.push(PriorityChat, "#a", []byte("chat"))
+	q.push(PriorityModeration, "#a", []byte("mode"))
+
+	if dropped := q.close(); dropped != 2 {
+		t.Errorf("close() = %d, want 2", dropped)
+	}
+
+	if q.push(PriorityChat, "#a", []byte("late")) {
+		t.Error("push() after close() returned true")
+	}
+
+	if n := q.Len(); n != 0 {
+		t.Errorf("Len() after close() = %d, want 0", n)
+	}
+}
+
+func TestConnection_PriorityFor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		flood   *FloodConfig
+		command string
+		want    Priority
+	}{
+		{name: "pong", flood: &FloodConfig{}, command: "PONG", want: PriorityControl},
+		{name: "kick", flood: &FloodConfig{}, command: "kick", want: PriorityModeration},
+		{name: "privmsg", flood: &FloodConfig{}, command: "PRIVMSG", want: PriorityChat},
+		{name: "no flood config", command: "CAP", want: PriorityControl},
+		{
+			name:    "custom moderation",
+			flood:   &FloodConfig{Moderation: []string{"REMOVE"}},
+			command: "REMOVE",
+			want:    PriorityModeration,
+		},
+		{name: "custom bypass", flood: &FloodConfig{Bypass: []string{}}, command: "PONG", want: PriorityChat},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewConnection(&Config{Flood: tt.flood})
+			if got := s.PriorityFor(tt.command); got != tt.want {
+				t.Errorf("PriorityFor(%q) = %s, want %s", tt.command, got, tt.want)
+			}
+		})
+	}
+}
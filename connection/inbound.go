@@ -0,0 +1,320 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"awesome-dragon.science/go/irc/numerics"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Defaults for InboundConfig
+const (
+	DefaultInboundQueueSize = 256  // Lines queued before the OverflowPolicy applies
+	DefaultInboundHardLimit = 4096 // Lines queued before the connection is closed, whatever the OverflowPolicy
+)
+
+// OverflowPolicy decides what happens to incoming lines when the inbound queue is full. Whatever the policy, lines
+// are always read from the socket and given to the immediate handler as soon as they arrive, the policy only
+// decides what is queued for LineChan.
+//
+// Lines that change state, such as JOIN, NICK, MODE, and the numerics that describe channels, are never dropped,
+// as losing them would leave anything tracking channels or users out of date. They are queued even when the queue
+// is full, up to InboundConfig.HardLimit, at which point the connection is closed with ErrInboundOverflow.
+type OverflowPolicy int
+
+// Overflow policies
+const (
+	// OverflowBuffer keeps queueing lines past QueueSize, up to HardLimit, and then closes the connection with
+	// ErrInboundOverflow. Nothing is lost while a handler catches up with a burst of lines.
+	OverflowBuffer OverflowPolicy = iota
+	// OverflowDropNewest drops incoming lines while the queue is full
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest queued line to make room for each incoming line
+	OverflowDropOldest
+	// OverflowDisconnect closes the connection with ErrInboundOverflow
+	OverflowDisconnect
+)
+
+func (o OverflowPolicy) String() string {
+	switch o {
+	case OverflowBuffer:
+		return "buffer"
+	case OverflowDropNewest:
+		return "drop newest"
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowDisconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(o))
+	}
+}
+
+// ErrInboundOverflow is the connection error when the inbound queue fills up with OverflowDisconnect, or reaches
+// InboundConfig.HardLimit
+var ErrInboundOverflow = errors.New("inbound queue overflowed")
+
+// InboundConfig configures the queue of incoming lines waiting to be read from LineChan. Lines are passed to the
+// immediate handler (see SetImmediateHandler) as soon as they are read, before they are queued.
+type InboundConfig struct {
+	QueueSize int            // Number of lines to buffer, DefaultInboundQueueSize if 0
+	Overflow  OverflowPolicy // What to do when the queue is full
+	// HardLimit is the most lines that may ever be queued, including those kept past QueueSize by OverflowBuffer,
+	// and those that are never dropped. The connection is closed once it is reached. DefaultInboundHardLimit if
+	// 0, and never less than QueueSize.
+	HardLimit int
+}
+
+func (i *InboundConfig) queueSize() int {
+	if i == nil || i.QueueSize <= 0 {
+		return DefaultInboundQueueSize
+	}
+
+	return i.QueueSize
+}
+
+func (i *InboundConfig) hardLimit() int {
+	limit := DefaultInboundHardLimit
+	if i != nil && i.HardLimit > 0 {
+		limit = i.HardLimit
+	}
+
+	if size := i.queueSize(); limit < size {
+		return size
+	}
+
+	return limit
+}
+
+func (i *InboundConfig) overflow() OverflowPolicy {
+	if i == nil {
+		return OverflowBuffer
+	}
+
+	return i.Overflow
+}
+
+// InboundStats describes the state of the inbound queue
+type InboundStats struct {
+	Queued    int    // Lines currently waiting to be read from LineChan
+	Capacity  int    // Size of the queue
+	HighWater int    // The most lines that have been waiting at once
+	Received  uint64 // Lines received from the server
+	Dropped   uint64 // Lines dropped because the queue was full
+}
+
+// inboundStats is the live version of InboundStats, accessed atomically
+type inboundStats struct {
+	highWater int64
+	received  uint64
+	dropped   uint64
+}
+
+// stateCommands are the commands that are never dropped from the inbound queue, see OverflowPolicy
+var stateCommands = map[string]bool{ //nolint:gochecknoglobals // Its a constant table
+	"JOIN": true, "PART": true, "KICK": true, "QUIT": true, "NICK": true, "MODE": true, "TOPIC": true,
+	"ACCOUNT": true, "AWAY": true, "CHGHOST": true, "SETNAME": true, "BATCH": true, "ERROR": true,
+
+	numerics.RPL_WELCOME: true, numerics.RPL_ISUPPORT: true, numerics.RPL_CHANNELMODEIS: true,
+	numerics.RPL_CREATIONTIME: true, numerics.RPL_TOPIC: true, numerics.RPL_TOPICWHOTIME: true,
+	numerics.RPL_NAMREPLY: true, numerics.RPL_ENDOFNAMES: true, numerics.RPL_VISIBLEHOST: true,
+}
+
+// inboundQueue holds incoming lines until they are handed to LineChan by forwardLines. Reading from the socket
+// never waits on it, only the hand off to LineChan waits for handlers.
+type inboundQueue struct {
+	mu     sync.Mutex
+	lines  []*ircmsg.Message
+	closed bool          // Set once no more lines will be added
+	ready  chan struct{} // Signalled when lines are added or the queue is closed
+}
+
+func newInboundQueue() *inboundQueue {
+	return &inboundQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *inboundQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *inboundQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.lines)
+}
+
+func (q *inboundQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// pop waits for a line and returns it. ok is false once the queue is closed and empty, or ctx is done
+func (q *inboundQueue) pop(ctx context.Context) (msg *ircmsg.Message, ok bool) {
+	for {
+		q.mu.Lock()
+
+		if len(q.lines) > 0 {
+			msg, q.lines[0] = q.lines[0], nil
+			q.lines = q.lines[1:]
+			q.mu.Unlock()
+
+			return msg, true
+		}
+
+		closed := q.closed
+		q.mu.Unlock()
+
+		if closed {
+			return nil, false
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// SetImmediateHandler sets a function to be called from the read loop with every line, before it is queued
+// for LineChan. It is intended for protocol handling that must never wait behind other lines, such as
+// answering PINGs, and must not block.
+func (s *Connection) SetImmediateHandler(f func(msg *ircmsg.Message)) {
+	s.immediateMu.Lock()
+	defer s.immediateMu.Unlock()
+
+	s.immediate = f
+}
+
+func (s *Connection) immediateHandler() func(msg *ircmsg.Message) {
+	s.immediateMu.Lock()
+	defer s.immediateMu.Unlock()
+
+	return s.immediate
+}
+
+// InboundStats returns metrics about the inbound queue
+func (s *Connection) InboundStats() InboundStats {
+	return InboundStats{
+		Queued:    s.inboundQueue.len(),
+		Capacity:  s.config.Inbound.queueSize(),
+		HighWater: int(atomic.LoadInt64(&s.inbound.highWater)),
+		Received:  atomic.LoadUint64(&s.inbound.received),
+		Dropped:   atomic.LoadUint64(&s.inbound.dropped),
+	}
+}
+
+// enqueue adds msg to the inbound queue, applying the configured OverflowPolicy if it is full
+func (s *Connection) enqueue(msg *ircmsg.Message) {
+	atomic.AddUint64(&s.inbound.received, 1)
+
+	q := s.inboundQueue
+
+	q.mu.Lock()
+	defer q.signal()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	if len(q.lines) >= s.config.Inbound.hardLimit() {
+		s.overflowed(q)
+
+		return
+	}
+
+	if len(q.lines) < s.config.Inbound.queueSize() || stateCommands[msg.Command] {
+		q.lines = append(q.lines, msg)
+		s.updateHighWater(len(q.lines))
+
+		return
+	}
+
+	switch s.config.Inbound.overflow() {
+	case OverflowBuffer:
+		q.lines = append(q.lines, msg)
+		s.updateHighWater(len(q.lines))
+
+	case OverflowDropNewest:
+		s.dropped(msg)
+
+	case OverflowDropOldest:
+		for i, old := range q.lines {
+			if !stateCommands[old.Command] {
+				q.lines = append(q.lines[:i], q.lines[i+1:]...)
+				s.dropped(old)
+
+				break
+			}
+		}
+
+		q.lines = append(q.lines, msg)
+		s.updateHighWater(len(q.lines))
+
+	case OverflowDisconnect:
+		s.overflowed(q)
+	}
+}
+
+// overflowed closes the connection with ErrInboundOverflow, once. q.mu must be held
+func (s *Connection) overflowed(q *inboundQueue) {
+	if q.closed {
+		return
+	}
+
+	s.log.Error("Inbound queue is full, disconnecting", "queued", len(q.lines), "capacity",
+		s.config.Inbound.queueSize(), "limit", s.config.Inbound.hardLimit())
+
+	// No more lines are queued, the rest of the connection is going away
+	q.closed = true
+
+	s.closeWithError(ErrInboundOverflow)
+}
+
+// forwardLines hands queued lines to LineChan one at a time, until the queue is closed at the end of the connection.
+// Lines still queued once the connection has closed are delivered, unless ctx (the context given to Connect) is done.
+func (s *Connection) forwardLines(ctx context.Context) {
+	defer close(s.lineChan)
+
+	for {
+		msg, ok := s.inboundQueue.pop(ctx)
+		if !ok {
+			return
+		}
+
+		select {
+		case s.lineChan <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dropped counts a dropped line, logging only the first on the connection to avoid flooding the log
+func (s *Connection) dropped(msg *ircmsg.Message) {
+	if atomic.AddUint64(&s.inbound.dropped, 1) == 1 {
+		s.log.Warn("Inbound queue is full, dropping lines", "command", msg.Command,
+			"capacity", s.config.Inbound.queueSize())
+	}
+}
+
+func (s *Connection) updateHighWater(depth int) {
+	for {
+		current := atomic.LoadInt64(&s.inbound.highWater)
+		if int64(depth) <= current || atomic.CompareAndSwapInt64(&s.inbound.highWater, current, int64(depth)) {
+			return
+		}
+	}
+}
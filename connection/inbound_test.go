@@ -0,0 +1,200 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/logger"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// newInboundTestConnection creates a Connection that can have lines queued on it without a socket
+func newInboundTestConnection(t *testing.T, inbound *InboundConfig) *Connection {
+	t.Helper()
+
+	conn := NewConnection(&Config{Inbound: inbound, Logger: logger.Discard})
+	conn.connectionCtx, conn.cancelConnCtx = context.WithCancel(context.Background())
+
+	t.Cleanup(conn.cancelConnCtx)
+
+	return conn
+}
+
+func queued(conn *Connection) []string {
+	conn.inboundQueue.mu.Lock()
+	defer conn.inboundQueue.mu.Unlock()
+
+	out := []string{}
+	for _, msg := range conn.inboundQueue.lines {
+		out = append(out, msg.Params[0])
+	}
+
+	return out
+}
+
+func TestConnection_enqueue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		overflow    OverflowPolicy
+		want        []string
+		wantDropped uint64
+		wantErr     error
+	}{
+		{name: "drop newest", overflow: OverflowDropNewest, want: []string{"1", "2"}, wantDropped: 2},
+		{name: "drop oldest", overflow: OverflowDropOldest, want: []string{"3", "4"}, wantDropped: 2},
+		{name: "disconnect", overflow: OverflowDisconnect, want: []string{"1", "2"}, wantErr: ErrInboundOverflow},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := newInboundTestConnection(t, &InboundConfig{QueueSize: 2, Overflow: tt.overflow})
+
+			for _, p := range []string{"1", "2", "3", "4"} {
+				msg := ircmsg.MakeMessage(nil, "", "PRIVMSG", p)
+				conn.enqueue(&msg)
+			}
+
+			stats := conn.InboundStats()
+			if stats.Received != 4 || stats.Dropped != tt.wantDropped || stats.HighWater != 2 || stats.Capacity != 2 {
+				t.Errorf("InboundStats() = %+v", stats)
+			}
+
+			if got := queued(conn); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued lines = %q, want %q", got, tt.want)
+			}
+
+			if err := conn.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConnection_enqueueBuffer(t *testing.T) {
+	t.Parallel()
+
+	conn := newInboundTestConnection(t, &InboundConfig{QueueSize: 1})
+
+	// Nothing is reading LineChan, but enqueue must never wait on it
+	for _, p := range []string{"1", "2", "3"} {
+		msg := ircmsg.MakeMessage(nil, "", "PRIVMSG", p)
+		conn.enqueue(&msg)
+	}
+
+	if got := queued(conn); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("queued lines = %q, want every line", got)
+	}
+
+	if stats := conn.InboundStats(); stats.HighWater != 3 || stats.Dropped != 0 {
+		t.Errorf("InboundStats() = %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go conn.forwardLines(ctx)
+
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case line := <-conn.lineChan:
+			if line.Params[0] != want {
+				t.Errorf("got line %q, want %q", line.Params[0], want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for LineChan")
+		}
+	}
+
+	conn.inboundQueue.close()
+
+	if _, ok := <-conn.lineChan; ok {
+		t.Error("LineChan is still open after the queue was closed and drained")
+	}
+}
+
+func TestConnection_enqueueKeepsState(t *testing.T) {
+	t.Parallel()
+
+	for _, overflow := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		conn := newInboundTestConnection(t, &InboundConfig{QueueSize: 2, Overflow: overflow})
+
+		for _, line := range []string{"JOIN 1", "PRIVMSG 2", "NICK 3", "PRIVMSG 4", "MODE 5"} {
+			command, param, _ := strings.Cut(line, " ")
+			msg := ircmsg.MakeMessage(nil, "", command, param)
+			conn.enqueue(&msg)
+		}
+
+		want := map[OverflowPolicy][]string{
+			OverflowDropNewest: {"1", "2", "3", "5"},
+			OverflowDropOldest: {"1", "3", "4", "5"},
+		}[overflow]
+
+		if got := queued(conn); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: queued lines = %q, want %q", overflow, got, want)
+		}
+	}
+}
+
+func TestConnection_enqueueHardLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		command  string
+	}{
+		{name: "buffer", overflow: OverflowBuffer, command: "PRIVMSG"},
+		{name: "state lines with drop newest", overflow: OverflowDropNewest, command: "JOIN"},
+		{name: "state lines with drop oldest", overflow: OverflowDropOldest, command: "MODE"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := newInboundTestConnection(t, &InboundConfig{QueueSize: 2, HardLimit: 4, Overflow: tt.overflow})
+
+			for _, p := range []string{"1", "2", "3", "4", "5", "6"} {
+				msg := ircmsg.MakeMessage(nil, "", tt.command, p)
+				conn.enqueue(&msg)
+			}
+
+			if got := queued(conn); !reflect.DeepEqual(got, []string{"1", "2", "3", "4"}) {
+				t.Errorf("queued lines = %q, want the first 4", got)
+			}
+
+			if err := conn.Err(); !errors.Is(err, ErrInboundOverflow) {
+				t.Errorf("Err() = %v, want %v", err, ErrInboundOverflow)
+			}
+		})
+	}
+}
+
+func TestConnection_ImmediateHandler(t *testing.T) {
+	t.Parallel()
+
+	conn := newInboundTestConnection(t, &InboundConfig{QueueSize: 1, Overflow: OverflowDropNewest})
+	seen := []string{}
+
+	conn.SetImmediateHandler(func(msg *ircmsg.Message) { seen = append(seen, msg.Params[0]) })
+
+	for _, p := range []string{"1", "2", "3"} {
+		msg := ircmsg.MakeMessage(nil, "", "PING", p)
+		conn.onLine(&msg)
+	}
+
+	// Every line is seen immediately, even those dropped from the queue
+	if !reflect.DeepEqual(seen, []string{"1", "2", "3"}) {
+		t.Errorf("immediate handler saw %q, want every line", seen)
+	}
+}
@@ -0,0 +1,148 @@
+package connection
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Defaults for KeepaliveConfig
+const (
+	DefaultKeepaliveInterval = time.Minute
+	DefaultKeepaliveTimeout  = time.Second * 30
+)
+
+// keepaliveTokenPrefix prefixes the token on our PINGs, so that our PONGs can be told apart from anyone else's
+const keepaliveTokenPrefix = "keepalive-"
+
+// ErrPingTimeout is the reason given for a connection being closed due to a missed PONG
+var ErrPingTimeout = errors.New("ping timeout")
+
+// KeepaliveConfig configures client-side PINGs, used to measure lag and detect dead connections
+type KeepaliveConfig struct {
+	// Interval is how long the connection must be idle (nothing received) before a PING is sent
+	Interval time.Duration
+	// Timeout is how long to wait for a PONG before closing the connection
+	Timeout time.Duration
+}
+
+func (k *KeepaliveConfig) values() (interval, timeout time.Duration) {
+	interval, timeout = k.Interval, k.Timeout
+
+	if interval <= 0 {
+		interval = DefaultKeepaliveInterval
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultKeepaliveTimeout
+	}
+
+	return interval, timeout
+}
+
+// markActivity records that something was received from the server
+func (s *Connection) markActivity() {
+	s.keepaliveMu.Lock()
+	defer s.keepaliveMu.Unlock()
+
+	s.lastActivity = time.Now()
+}
+
+// Lag returns the round trip time measured by the most recent keepalive PING, or 0 if none has been measured
+func (s *Connection) Lag() time.Duration {
+	s.keepaliveMu.Lock()
+	defer s.keepaliveMu.Unlock()
+
+	return s.lag
+}
+
+// SetLagCallback sets a function to be called whenever a new lag measurement is made. It must not block.
+func (s *Connection) SetLagCallback(f func(lag time.Duration)) {
+	s.keepaliveMu.Lock()
+	defer s.keepaliveMu.Unlock()
+
+	s.onLag = f
+}
+
+// onKeepalivePong handles PONG responses to our keepalive PINGs, and returns true if msg was one
+func (s *Connection) onKeepalivePong(msg *ircmsg.Message) bool {
+	if msg.Command != "PONG" || len(msg.Params) == 0 {
+		return false
+	}
+
+	token := msg.Params[len(msg.Params)-1]
+	if !strings.HasPrefix(token, keepaliveTokenPrefix) {
+		return false
+	}
+
+	s.keepaliveMu.Lock()
+
+	if token != s.pingToken {
+		s.keepaliveMu.Unlock()
+
+		return true // Its ours, but stale. Nothing else is interested in it
+	}
+
+	s.lag = time.Since(s.pingSent)
+	s.pingSent = time.Time{}
+	s.pingToken = ""
+	lag, onLag := s.lag, s.onLag
+	s.keepaliveMu.Unlock()
+
+	if onLag != nil {
+		onLag(lag)
+	}
+
+	return true
+}
+
+// keepaliveLoop sends PINGs when the connection is idle, and closes it if they go unanswered
+func (s *Connection) keepaliveLoop() {
+	interval, timeout := s.config.Keepalive.values()
+
+	tickRate := interval
+	if timeout < tickRate {
+		tickRate = timeout
+	}
+
+	ticker := time.NewTicker(tickRate / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.connectionCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		s.keepaliveMu.Lock()
+		pingSent, lastActivity := s.pingSent, s.lastActivity
+		s.keepaliveMu.Unlock()
+
+		switch {
+		case !pingSent.IsZero() && now.Sub(pingSent) >= timeout:
+			s.log.Warn("No PONG received, closing connection", "timeout", timeout)
+			s.closeWithError(ErrPingTimeout)
+
+			return
+
+		case pingSent.IsZero() && now.Sub(lastActivity) >= interval:
+			token := keepaliveTokenPrefix + strconv.FormatInt(now.UnixNano(), 36)
+
+			s.keepaliveMu.Lock()
+			s.pingSent = now
+			s.pingToken = token
+			s.keepaliveMu.Unlock()
+
+			// Straight to the socket, a backed up send queue shouldn't look like a dead connection
+			if _, err := s.writeSocket([]byte("PING " + token + "\r\n")); err != nil {
+				s.log.Warn("Could not send keepalive PING", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pingServer starts a server that answers PINGs, if respond is true, and otherwise says nothing at all
+func pingServer(t *testing.T, respond bool) (host, port string) {
+	t.Helper()
+
+	listener := listen(t)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if respond && strings.HasPrefix(line, "PING ") {
+				_, _ = conn.Write([]byte(":irc.test PONG irc.test :" + strings.TrimSpace(line[5:]) + "\r\n"))
+			}
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+
+	return host, port
+}
+
+func TestConnection_KeepaliveLag(t *testing.T) {
+	t.Parallel()
+
+	host, port := pingServer(t, true)
+	conn := NewConnection(&Config{
+		Host:      host,
+		Port:      port,
+		Keepalive: &KeepaliveConfig{Interval: time.Millisecond * 20, Timeout: time.Second},
+	})
+
+	lags := make(chan time.Duration, 10)
+	conn.SetLagCallback(func(lag time.Duration) { lags <- lag })
+
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	defer conn.cancelConnCtx()
+
+	select {
+	case lag := <-lags:
+		if lag <= 0 || conn.Lag() <= 0 {
+			t.Errorf("got lag %s, Lag() = %s, want > 0", lag, conn.Lag())
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for lag measurement")
+	}
+
+	select {
+	case line := <-conn.LineChan():
+		t.Errorf("keepalive PONG was passed on: %v", line)
+	default:
+	}
+}
+
+func TestConnection_KeepaliveTimeout(t *testing.T) {
+	t.Parallel()
+
+	host, port := pingServer(t, false)
+	conn := NewConnection(&Config{
+		Host:      host,
+		Port:      port,
+		Keepalive: &KeepaliveConfig{Interval: time.Millisecond * 20, Timeout: time.Millisecond * 50},
+	})
+
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	select {
+	case <-conn.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("connection was not closed after missing PONG")
+	}
+
+	if err := conn.Err(); !errors.Is(err, ErrPingTimeout) {
+		t.Errorf("Err() = %v, want %v", err, ErrPingTimeout)
+	}
+}
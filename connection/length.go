@@ -0,0 +1,196 @@
+package connection
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"awesome-dragon.science/go/irc/util"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Line length limits, see https://modern.ircdocs.horse/#message-format
+// and https://ircv3.net/specs/extensions/message-tags
+const (
+	MaxLineLength = 512  // Maximum length of a line, excluding tags, including the trailing \r\n
+	MaxTagLength  = 8191 // Maximum length of the tags on a line, including the leading @ and trailing space
+)
+
+// Defaults used to estimate the length of our own prefix before the server has told us what it is
+const (
+	defaultNickLen = 30
+	defaultUserLen = 10
+	defaultHostLen = 63
+)
+
+// LongLinePolicy decides what happens to lines that are too long to send
+type LongLinePolicy int
+
+// Policies for lines that are too long
+const (
+	// LongLineReject causes writes of lines that are too long to fail with a LineTooLongError
+	LongLineReject LongLinePolicy = iota
+	// LongLineSplit causes PRIVMSGs and NOTICEs that are too long to be split into multiple lines. Any other
+	// lines that are too long are rejected.
+	LongLineSplit
+)
+
+// relayedCommands are commands that the server relays to others with our prefix added
+var relayedCommands = []string{ //nolint:gochecknoglobals // Its a constant list
+	"PRIVMSG", "NOTICE", "TAGMSG", "TOPIC", "PART", "KICK", "QUIT", "INVITE",
+}
+
+// splittableCommands are commands whose final parameter can be split over multiple lines
+var splittableCommands = []string{"PRIVMSG", "NOTICE"} //nolint:gochecknoglobals // Its a constant list
+
+// firstChunkTags are tags that identify a single message, so when it is split they are only kept on the first line.
+// Anything else, such as the label of a labelled command, would be duplicated or mean something else on the rest.
+var firstChunkTags = []string{"label", "msgid", "+draft/reply"} //nolint:gochecknoglobals // Its a constant list
+
+// ErrLineTooLong is wrapped by LineTooLongError, for use with errors.Is
+var ErrLineTooLong = errors.New("line too long")
+
+// LineTooLongError is returned when a line is too long to be sent without being truncated
+type LineTooLongError struct {
+	Command string
+	Tags    bool // Whether the tags were too long, rather than the line itself
+	Length  int  // The length of the line or tags, including our prefix if it is relayed
+	Limit   int
+}
+
+func (e *LineTooLongError) Error() string {
+	what := "line"
+	if e.Tags {
+		what = "tags"
+	}
+
+	return fmt.Sprintf("%s: %s %s is %d bytes, limit is %d", ErrLineTooLong, e.Command, what, e.Length, e.Limit)
+}
+
+func (e *LineTooLongError) Unwrap() error { return ErrLineTooLong }
+
+// SetSource sets the nick!user@host the server uses as the prefix for our messages. This is used to work out
+// how long a line will be when it is relayed to other clients.
+func (s *Connection) SetSource(source string) {
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+
+	s.source = source
+}
+
+// Source returns the prefix set with SetSource
+func (s *Connection) Source() string {
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+
+	return s.source
+}
+
+// prefixLength returns the length of the ":nick!user@host " prefix the server adds to lines we send.
+// If our source is not known, the longest one the server allows is assumed.
+func (s *Connection) prefixLength() int {
+	if source := s.Source(); source != "" {
+		return len(source) + 2
+	}
+
+	nickLen := s.ISupport.MaxNickLen()
+	if nickLen <= 0 {
+		nickLen = defaultNickLen
+	}
+
+	userLen := s.ISupport.NumericToken("USERLEN")
+	if userLen <= 0 {
+		userLen = defaultUserLen
+	}
+
+	hostLen := s.ISupport.MaxHostLen()
+	if hostLen <= 0 {
+		hostLen = defaultHostLen
+	}
+
+	// :nick!~user@host<space>
+	return 1 + nickLen + 2 + userLen + 1 + hostLen + 1
+}
+
+// LineLength returns how long msg will be once it has been sent on to other clients, both with and without
+// tags. For commands that the server does not relay, this is simply the length of the line we send.
+func (s *Connection) LineLength(msg *ircmsg.Message) (lineLength, tagLength int, err error) {
+	line, err := msg.LineBytes()
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not create IRC line: %w", err)
+	}
+
+	if line[0] == '@' {
+		tagLength = bytes.IndexByte(line, ' ') + 1
+	}
+
+	lineLength = len(line) - tagLength
+
+	if containsFold(relayedCommands, msg.Command) {
+		lineLength += s.prefixLength()
+	}
+
+	return lineLength, tagLength, nil
+}
+
+// CheckLength returns a LineTooLongError if msg is too long to be sent without being truncated
+func (s *Connection) CheckLength(msg *ircmsg.Message) error {
+	lineLength, tagLength, err := s.LineLength(msg)
+	if err != nil {
+		return err
+	}
+
+	if tagLength > MaxTagLength {
+		return &LineTooLongError{Command: msg.Command, Tags: true, Length: tagLength, Limit: MaxTagLength}
+	}
+
+	if lineLength > MaxLineLength {
+		return &LineTooLongError{Command: msg.Command, Length: lineLength, Limit: MaxLineLength}
+	}
+
+	return nil
+}
+
+// SplitMessage splits the final parameter of msg over as many lines as are needed for each to fit. Only
+// PRIVMSGs and NOTICEs are split, a LineTooLongError is returned for anything else that does not fit. Tags that
+// identify the message, such as label, are only kept on the first line.
+func (s *Connection) SplitMessage(msg *ircmsg.Message) ([]*ircmsg.Message, error) {
+	err := s.CheckLength(msg)
+	if err == nil {
+		return []*ircmsg.Message{msg}, nil
+	}
+
+	var tooLong *LineTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Tags || !containsFold(splittableCommands, msg.Command) ||
+		len(msg.Params) == 0 {
+		return nil, err
+	}
+
+	text := msg.Params[len(msg.Params)-1]
+	// Leave room for the : that may need to be added before the final parameter
+	available := len(text) - (tooLong.Length - tooLong.Limit) - 1
+
+	if available < utf8.UTFMax {
+		return nil, err
+	}
+
+	out := []*ircmsg.Message{}
+	tags := msg.AllTags()
+
+	for i, chunk := range util.SplitByteLength(text, available) {
+		params := append([]string{}, msg.Params...)
+		params[len(params)-1] = chunk
+
+		if i == 1 {
+			for _, tag := range firstChunkTags {
+				delete(tags, tag)
+			}
+		}
+
+		split := ircmsg.MakeMessage(tags, msg.Source, msg.Command, params...)
+		out = append(out, &split)
+	}
+
+	return out, nil
+}
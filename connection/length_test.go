@@ -0,0 +1,202 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const testSource = "nick!user@some.host.example"
+
+func TestConnection_CheckLength(t *testing.T) {
+	t.Parallel()
+
+	conn := NewConnection(&Config{})
+	conn.SetSource(testSource)
+
+	// The largest PRIVMSG that fits once our prefix is added
+	fits := MaxLineLength - len(":"+testSource+" PRIVMSG #chan \r\n")
+
+	tests := []struct {
+		name     string
+		msg      ircmsg.Message
+		wantTags bool
+		wantErr  bool
+	}{
+		{name: "short", msg: ircmsg.MakeMessage(nil, "", "PRIVMSG", "#chan", "hi")},
+		{name: "exact", msg: ircmsg.MakeMessage(nil, "", "PRIVMSG", "#chan", strings.Repeat("a", fits))},
+		{
+			name:    "one over",
+			msg:     ircmsg.MakeMessage(nil, "", "PRIVMSG", "#chan", strings.Repeat("a", fits+1)),
+			wantErr: true,
+		},
+		{
+			// Not relayed, so our prefix doesn't count
+			name: "not relayed",
+			msg:  ircmsg.MakeMessage(nil, "", "WHOIS", strings.Repeat("a", fits+1)),
+		},
+		{
+			name: "tags dont count",
+			msg:  ircmsg.MakeMessage(map[string]string{"+x": strings.Repeat("a", 4000)}, "", "PRIVMSG", "#chan", "hi"),
+		},
+		{
+			name:     "tags too long",
+			msg:      ircmsg.MakeMessage(map[string]string{"+x": strings.Repeat("a", MaxTagLength)}, "", "TAGMSG", "#chan"),
+			wantTags: true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := conn.CheckLength(&tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckLength() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var tooLong *LineTooLongError
+			if err != nil && (!errors.As(err, &tooLong) || tooLong.Tags != tt.wantTags) {
+				t.Errorf("CheckLength() error = %#v, want LineTooLongError with Tags = %t", err, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestConnection_prefixLengthUnknown(t *testing.T) {
+	t.Parallel()
+
+	conn := NewConnection(&Config{})
+	if got := conn.prefixLength(); got <= len(testSource)+2 {
+		t.Errorf("prefixLength() without a source = %d, want a conservative estimate", got)
+	}
+
+	conn.SetSource(testSource)
+
+	if got := conn.prefixLength(); got != len(testSource)+2 {
+		t.Errorf("prefixLength() = %d, want %d", got, len(testSource)+2)
+	}
+}
+
+func TestConnection_SplitMessage(t *testing.T) {
+	t.Parallel()
+
+	conn := NewConnection(&Config{})
+	conn.SetSource(testSource)
+
+	text := strings.TrimSpace(strings.Repeat("word ", 300))
+	msg := ircmsg.MakeMessage(nil, "", "PRIVMSG", "#chan", text)
+
+	split, err := conn.SplitMessage(&msg)
+	if err != nil {
+		t.Fatalf("SplitMessage() error = %s", err)
+	}
+
+	if len(split) < 3 {
+		t.Errorf("SplitMessage() returned %d lines, want at least 3", len(split))
+	}
+
+	joined := []string{}
+
+	for _, m := range split {
+		if err := conn.CheckLength(m); err != nil {
+			t.Errorf("split line is still too long: %s", err)
+		}
+
+		joined = append(joined, m.Params[1])
+	}
+
+	if got := strings.Join(joined, " "); got != text {
+		t.Errorf("split lines do not join back up to the original message")
+	}
+
+	kick := ircmsg.MakeMessage(nil, "", "KICK", "#chan", "someone", text)
+	if _, err := conn.SplitMessage(&kick); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("SplitMessage() on KICK error = %v, want %v", err, ErrLineTooLong)
+	}
+}
+
+func TestConnection_SplitMessageTags(t *testing.T) {
+	t.Parallel()
+
+	conn := NewConnection(&Config{})
+	conn.SetSource(testSource)
+
+	tags := map[string]string{"label": "abc", "msgid": "id1", "+draft/reply": "id0", "+draft/channel-context": "#chan"}
+	msg := ircmsg.MakeMessage(tags, "", "PRIVMSG", "#chan", strings.Repeat("a", 1000))
+
+	split, err := conn.SplitMessage(&msg)
+	if err != nil {
+		t.Fatalf("SplitMessage() error = %s", err)
+	}
+
+	if len(split) < 2 {
+		t.Fatalf("SplitMessage() returned %d lines, want at least 2", len(split))
+	}
+
+	if got := split[0].AllTags(); !reflect.DeepEqual(got, tags) {
+		t.Errorf("first line tags = %v, want %v", got, tags)
+	}
+
+	for i, m := range split[1:] {
+		want := map[string]string{"+draft/channel-context": "#chan"}
+		if got := m.AllTags(); !reflect.DeepEqual(got, want) {
+			t.Errorf("line %d tags = %v, want %v", i+1, got, want)
+		}
+	}
+
+	if got := msg.AllTags(); !reflect.DeepEqual(got, tags) {
+		t.Errorf("original message tags changed to %v", got)
+	}
+}
+
+func TestConnection_WriteLongLines(t *testing.T) {
+	t.Parallel()
+
+	clientSide, serverSide := net.Pipe()
+	t.Cleanup(func() { serverSide.Close() })
+
+	conn := NewConnection(&Config{Dial: SingleConnDialer(clientSide), LongLines: LongLineSplit})
+	conn.SetSource(testSource)
+
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	lines := make(chan string, 10)
+
+	go func() {
+		reader := bufio.NewReader(serverSide)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			lines <- line
+		}
+	}()
+
+	if err := conn.WriteLine("PRIVMSG", "#chan", strings.Repeat("a", 600)); err != nil {
+		t.Fatalf("WriteLine() error = %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if line := <-lines; len(line)+len(testSource)+2 > MaxLineLength {
+			t.Errorf("line %d is %d bytes once relayed", i, len(line)+len(testSource)+2)
+		}
+	}
+
+	if err := conn.WriteLine("TOPIC", "#chan", strings.Repeat("a", 600)); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("WriteLine() on TOPIC error = %v, want %v", err, ErrLineTooLong)
+	}
+}
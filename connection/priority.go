@@ -0,0 +1,109 @@
+package connection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Priority decides the order queued lines are sent in. Lines are always sent in priority order, and in the
+// order they were written within a priority. Priorities only matter when flood control is enabled, otherwise
+// every line is sent as soon as it is written.
+type Priority int
+
+// Line priorities, from most to least urgent
+const (
+	// PriorityDefault picks a priority based on the command being sent. See Connection.PriorityFor
+	PriorityDefault Priority = iota
+	// PriorityControl lines keep the connection alive or registered, and skip the send queue entirely
+	PriorityControl
+	// PriorityModeration lines are channel management actions, and are sent before any chat
+	PriorityModeration
+	// PriorityChat is everything else
+	PriorityChat
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityDefault:
+		return "default"
+	case PriorityControl:
+		return "control"
+	case PriorityModeration:
+		return "moderation"
+	case PriorityChat:
+		return "chat"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// DefaultModerationCommands is the set of commands sent at PriorityModeration unless
+// FloodConfig.Moderation is set
+var DefaultModerationCommands = []string{"MODE", "KICK"} //nolint:gochecknoglobals // Its a default
+
+// PriorityFor returns the priority a line with the given command is sent at when written with PriorityDefault.
+// Commands in FloodConfig.Bypass are PriorityControl, those in FloodConfig.Moderation are PriorityModeration,
+// and everything else is PriorityChat.
+func (s *Connection) PriorityFor(command string) Priority {
+	flood := s.config.Flood
+	if flood == nil {
+		flood = &FloodConfig{}
+	}
+
+	switch {
+	case flood.bypasses(command):
+		return PriorityControl
+	case containsFold(flood.moderation(), command):
+		return PriorityModeration
+	default:
+		return PriorityChat
+	}
+}
+
+// WriteWithPriority is like Write, but queues b at the given priority. b is expected to contain a single IRC line.
+func (s *Connection) WriteWithPriority(priority Priority, b []byte) (int, error) {
+	var msg *ircmsg.Message
+
+	if parsed, err := ircmsg.ParseLine(string(b)); err == nil {
+		msg = &parsed
+	}
+
+	return s.write(priority, msg, b)
+}
+
+// WriteLineWithPriority is like WriteLineWithTags, but queues the line at the given priority
+func (s *Connection) WriteLineWithPriority(
+	priority Priority, tags map[string]string, command string, args ...string,
+) error {
+	for name := range tags {
+		if strings.HasPrefix(name, "+") && !s.ISupport.ClientTagAllowed(name) {
+			return fmt.Errorf("%w: %q", ErrTagDenied, name)
+		}
+	}
+
+	msg := ircmsg.MakeMessage(tags, "", command, args...)
+
+	bytes, err := msg.LineBytes()
+	if err != nil {
+		return fmt.Errorf("could not create IRC line: %w", err)
+	}
+
+	_, err = s.write(priority, &msg, bytes)
+	if err != nil {
+		return fmt.Errorf("WriteLine: Could not send line: %w", err)
+	}
+
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,263 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ProxyType is the protocol spoken to a proxy
+type ProxyType int
+
+// Supported proxy types
+const (
+	ProxySOCKS5 ProxyType = iota
+	ProxyHTTP             // HTTP CONNECT
+)
+
+func (p ProxyType) String() string {
+	switch p {
+	case ProxySOCKS5:
+		return "SOCKS5"
+	case ProxyHTTP:
+		return "HTTP"
+	default:
+		return "Unknown"
+	}
+}
+
+// ProxyConfig configures a proxy to open connections through. TLS, if enabled, is done end to end
+// with the IRC server, through the proxy.
+type ProxyConfig struct {
+	Type     ProxyType
+	Address  string // host:port of the proxy
+	Username string // Optional
+	Password string // Optional
+}
+
+// ErrProxyFailed is returned when a proxy refuses or fails to open a connection
+var ErrProxyFailed = errors.New("proxy failed")
+
+// dial opens a connection to address through the proxy, using dialTCP to connect to the proxy itself
+func (p *ProxyConfig) dial(
+	ctx context.Context, dialTCP func(context.Context, string) (net.Conn, error), address string,
+) (net.Conn, error) {
+	conn, err := dialTCP(ctx, p.Address)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial %s proxy: %w", p.Type, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	switch p.Type {
+	case ProxySOCKS5:
+		err = p.socks5Handshake(conn, address)
+	case ProxyHTTP:
+		conn, err = p.httpHandshake(conn, address)
+	default:
+		err = fmt.Errorf("%w: unknown proxy type %d", ErrProxyFailed, p.Type)
+	}
+
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// SOCKS5 constants, see RFC 1928 and RFC 1929
+const (
+	socks5Version        = 0x05
+	socks5AuthNone       = 0x00
+	socks5AuthPassword   = 0x02
+	socks5AuthNoAccept   = 0xFF
+	socks5PasswordVer    = 0x01
+	socks5CmdConnect     = 0x01
+	socks5AddrIPv4       = 0x01
+	socks5AddrDomain     = 0x03
+	socks5AddrIPv6       = 0x04
+	socks5ReplySucceeded = 0x00
+)
+
+func (p *ProxyConfig) socks5Handshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", address, err)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if p.Username != "" {
+		methods = append(methods, socks5AuthPassword)
+	}
+
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("could not write SOCKS5 greeting: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("could not read SOCKS5 greeting: %w", err)
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := p.socks5Auth(conn); err != nil {
+			return err
+		}
+
+	case socks5AuthNoAccept:
+		return fmt.Errorf("%w: SOCKS5 proxy accepted none of our authentication methods", ErrProxyFailed)
+
+	default:
+		return fmt.Errorf("%w: SOCKS5 proxy selected unknown authentication method %d", ErrProxyFailed, reply[1])
+	}
+
+	request := []byte{socks5Version, socks5CmdConnect, 0x00}
+
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("%w: hostname %q too long for SOCKS5", ErrProxyFailed, host)
+		}
+
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socks5AddrIPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socks5AddrIPv6)
+		request = append(request, ip.To16()...)
+	}
+
+	request = append(request, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("could not write SOCKS5 connect request: %w", err)
+	}
+
+	return socks5ReadReply(conn)
+}
+
+func (p *ProxyConfig) socks5Auth(conn net.Conn) error {
+	if len(p.Username) > 255 || len(p.Password) > 255 {
+		return fmt.Errorf("%w: SOCKS5 username or password too long", ErrProxyFailed)
+	}
+
+	request := []byte{socks5PasswordVer, byte(len(p.Username))}
+	request = append(request, p.Username...)
+	request = append(request, byte(len(p.Password)))
+	request = append(request, p.Password...)
+
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("could not write SOCKS5 authentication: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("could not read SOCKS5 authentication reply: %w", err)
+	}
+
+	if reply[1] != socks5ReplySucceeded {
+		return fmt.Errorf("%w: SOCKS5 authentication rejected", ErrProxyFailed)
+	}
+
+	return nil
+}
+
+func socks5ReadReply(conn net.Conn) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("could not read SOCKS5 connect reply: %w", err)
+	}
+
+	if header[1] != socks5ReplySucceeded {
+		return fmt.Errorf("%w: SOCKS5 proxy returned error %d", ErrProxyFailed, header[1])
+	}
+
+	// We dont care about the bound address, but it still needs to be read out of the way
+	var addrLen int
+
+	switch header[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fmt.Errorf("could not read SOCKS5 connect reply: %w", err)
+		}
+
+		addrLen = int(length[0])
+	default:
+		return fmt.Errorf("%w: SOCKS5 proxy returned unknown address type %d", ErrProxyFailed, header[3])
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return fmt.Errorf("could not read SOCKS5 connect reply: %w", err)
+	}
+
+	return nil
+}
+
+// bufferedConn is a net.Conn that reads through a bufio.Reader, so that nothing buffered while reading
+// the proxy's response is lost
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) { return b.reader.Read(p) } //nolint:wrapcheck // Its a passthrough
+
+func (p *ProxyConfig) httpHandshake(conn net.Conn, address string) (net.Conn, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if p.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		request.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := request.Write(conn); err != nil {
+		return conn, fmt.Errorf("could not write HTTP CONNECT request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return conn, fmt.Errorf("could not read HTTP CONNECT response: %w", err)
+	}
+
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("%w: HTTP proxy returned %q", ErrProxyFailed, response.Status)
+	}
+
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
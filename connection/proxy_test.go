@@ -0,0 +1,297 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	return listener
+}
+
+// ircServer starts a server that sends a single line to anything that connects
+func ircServer(t *testing.T, useTLS bool) string {
+	t.Helper()
+
+	listener := listen(t)
+
+	if useTLS {
+		// Easiest way to get our hands on a certificate
+		httpServer := httptest.NewTLSServer(http.NotFoundHandler())
+		certs := httpServer.TLS.Certificates
+		httpServer.Close()
+
+		listener = tls.NewListener(listener, &tls.Config{Certificates: certs}) //nolint:gosec // Its a test
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			_, _ = conn.Write([]byte(":irc.test NOTICE * :hello\r\n"))
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// pipe connects two connections together until one closes
+func pipe(a, b net.Conn) {
+	go func() { _, _ = io.Copy(a, b); a.Close() }()
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+// socks5Proxy starts a minimal SOCKS5 proxy that requires the given username and password if not empty
+func socks5Proxy(t *testing.T, username, password string) string {
+	t.Helper()
+
+	listener := listen(t)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSOCKS5(conn, username, password)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, username, password string) { //nolint:cyclop // Its a test
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 2)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return
+	}
+
+	if username == "" {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthNone})
+	} else {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthPassword})
+
+		ver, _ := reader.ReadByte()
+		userLen, _ := reader.ReadByte()
+		gotUser := make([]byte, userLen)
+		_, _ = io.ReadFull(reader, gotUser)
+		passLen, _ := reader.ReadByte()
+		gotPass := make([]byte, passLen)
+		_, _ = io.ReadFull(reader, gotPass)
+
+		if ver != socks5PasswordVer || string(gotUser) != username || string(gotPass) != password {
+			_, _ = conn.Write([]byte{socks5PasswordVer, 0x01})
+
+			return
+		}
+
+		_, _ = conn.Write([]byte{socks5PasswordVer, socks5ReplySucceeded})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil || request[3] != socks5AddrDomain && request[3] != socks5AddrIPv4 {
+		return
+	}
+
+	var host string
+
+	if request[3] == socks5AddrIPv4 {
+		ip := make([]byte, net.IPv4len)
+		_, _ = io.ReadFull(reader, ip)
+		host = net.IP(ip).String()
+	} else {
+		length, _ := reader.ReadByte()
+		name := make([]byte, length)
+		_, _ = io.ReadFull(reader, name)
+		host = string(name)
+	}
+
+	port := make([]byte, 2)
+	_, _ = io.ReadFull(reader, port)
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		_, _ = conn.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+
+		return
+	}
+
+	_, _ = conn.Write([]byte{socks5Version, socks5ReplySucceeded, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+
+	pipe(&bufferedConn{Conn: conn, reader: reader}, target)
+}
+
+// httpProxy starts a minimal HTTP CONNECT proxy that requires the given Proxy-Authorization header if not empty
+func httpProxy(t *testing.T, wantAuth string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		if wantAuth != "" && r.Header.Get("Proxy-Authorization") != wantAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		pipe(&bufferedConn{Conn: conn, reader: buffered.Reader}, target)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server.Listener.Addr().String()
+}
+
+func connectAndReadLine(t *testing.T, config *Config) error {
+	t.Helper()
+
+	conn := NewConnection(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := conn.Connect(ctx); err != nil {
+		return err
+	}
+
+	defer conn.cancelConnCtx()
+
+	select {
+	case line := <-conn.LineChan():
+		if line == nil || line.Command != "NOTICE" {
+			t.Errorf("got unexpected line %v", line)
+		}
+
+	case <-ctx.Done():
+		t.Error("timed out waiting for line")
+	}
+
+	return nil
+}
+
+func TestConnection_Proxy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		tls     bool
+		proxy   func(t *testing.T) *ProxyConfig
+		wantErr bool
+	}{
+		{
+			name: "socks5",
+			proxy: func(t *testing.T) *ProxyConfig {
+				return &ProxyConfig{Type: ProxySOCKS5, Address: socks5Proxy(t, "", "")}
+			}, //nolint:lll // test
+		},
+		{
+			name: "socks5 auth",
+			tls:  true,
+			proxy: func(t *testing.T) *ProxyConfig {
+				return &ProxyConfig{Type: ProxySOCKS5, Address: socks5Proxy(t, "user", "pass"), Username: "user", Password: "pass"}
+			},
+		},
+		{
+			name: "socks5 bad auth",
+			proxy: func(t *testing.T) *ProxyConfig {
+				return &ProxyConfig{Type: ProxySOCKS5, Address: socks5Proxy(t, "user", "pass"), Username: "user", Password: "nope"}
+			},
+			wantErr: true,
+		},
+		{
+			name:  "http",
+			tls:   true,
+			proxy: func(t *testing.T) *ProxyConfig { return &ProxyConfig{Type: ProxyHTTP, Address: httpProxy(t, "")} },
+		},
+		{
+			name: "http auth",
+			proxy: func(t *testing.T) *ProxyConfig {
+				return &ProxyConfig{Type: ProxyHTTP, Address: httpProxy(t, "Basic dXNlcjpwYXNz"), Username: "user", Password: "pass"}
+			},
+		},
+		{
+			name: "http bad auth",
+			proxy: func(t *testing.T) *ProxyConfig {
+				return &ProxyConfig{Type: ProxyHTTP, Address: httpProxy(t, "Basic dXNlcjpwYXNz"), Username: "user"}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The proxy should be resolving this, not us
+			_, port, _ := net.SplitHostPort(ircServer(t, tt.tls))
+
+			err := connectAndReadLine(t, &Config{
+				Host:                  "localhost",
+				Port:                  port,
+				TLS:                   tt.tls,
+				InsecureSkipVerifyTLS: true,
+				Proxy:                 tt.proxy(t),
+			})
+
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Connect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr && !errors.Is(err, ErrProxyFailed) {
+				t.Errorf("Connect() error = %v, want %v", err, ErrProxyFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesome-dragon.science/go/irc/logger"
+	"awesome-dragon.science/go/irc/numerics"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Direction is the direction a raw line travelled in
+type Direction string
+
+// Directions for RawLogRecord
+const (
+	DirectionIn  Direction = "in"  // From the server
+	DirectionOut Direction = "out" // To the server
+)
+
+// Arrow returns the traditional arrow used when logging lines in this direction
+func (d Direction) Arrow() string {
+	if d == DirectionIn {
+		return ">>"
+	}
+
+	return "<<"
+}
+
+// RawLogRecord is a single line sent to or received from the server. The line has already had any
+// secrets redacted, and has no trailing \r\n
+type RawLogRecord struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	Line      string    `json:"line"`
+}
+
+// RawLogSink receives raw log records. Sinks may be shared between connections, and so must be
+// safe for concurrent use.
+type RawLogSink interface {
+	WriteRecord(record *RawLogRecord) error
+}
+
+// Redacted is what secrets are replaced with in raw logs
+const Redacted = "<redacted>"
+
+// saslMechanisms are AUTHENTICATE parameters that are not secret
+var saslMechanisms = []string{ //nolint:gochecknoglobals // Its a constant list
+	"+", "*", "PLAIN", "EXTERNAL", "SCRAM-SHA-1", "SCRAM-SHA-256", "SCRAM-SHA-512", "ECDSA-NIST256P-CHALLENGE",
+}
+
+// nickServIdentify are the NickServ commands whose arguments are passwords
+var nickServIdentify = []string{ //nolint:gochecknoglobals // Its a constant list
+	"IDENTIFY", "ID", "LOGIN", "REGISTER", "GHOST", "RECOVER", "REGAIN",
+}
+
+// RedactLine removes secrets from a raw IRC line. Currently this covers PASS, OPER and AUTHENTICATE
+// parameters, CHALLENGE responses and challenges, and passwords sent to NickServ.
+func RedactLine(line string) string {
+	msg, err := ircmsg.ParseLine(line)
+	if err != nil {
+		return strings.TrimRight(line, "\r\n")
+	}
+
+	if !redactMessage(&msg) {
+		return strings.TrimRight(line, "\r\n")
+	}
+
+	out, err := msg.Line()
+	if err != nil {
+		return Redacted
+	}
+
+	return strings.TrimRight(out, "\r\n")
+}
+
+// redactMessage redacts msg in place, returning whether or not anything was changed
+func redactMessage(msg *ircmsg.Message) bool {
+	redactFrom := -1
+
+	switch strings.ToUpper(msg.Command) {
+	case "PASS":
+		redactFrom = 0
+	case "OPER":
+		redactFrom = 1
+	case "AUTHENTICATE":
+		if len(msg.Params) > 0 && !containsFold(saslMechanisms, msg.Params[0]) {
+			redactFrom = 0
+		}
+	case "CHALLENGE":
+		if len(msg.Params) > 0 && strings.HasPrefix(msg.Params[0], "+") {
+			redactFrom = 0
+		}
+	case numerics.RPL_RSACHALLENGE2:
+		redactFrom = 1
+	case "PRIVMSG":
+		return redactNickServ(msg)
+	case "NS", "NICKSERV":
+		if len(msg.Params) > 0 && containsFold(nickServIdentify, msg.Params[0]) {
+			redactFrom = 1
+		}
+	}
+
+	if redactFrom < 0 || redactFrom >= len(msg.Params) {
+		return false
+	}
+
+	for i := redactFrom; i < len(msg.Params); i++ {
+		msg.Params[i] = Redacted
+	}
+
+	return true
+}
+
+// redactNickServ redacts passwords sent to NickServ in a PRIVMSG, returning whether or not anything was changed
+func redactNickServ(msg *ircmsg.Message) bool {
+	if len(msg.Params) < 2 || !strings.EqualFold(strings.SplitN(msg.Params[0], "@", 2)[0], "NickServ") {
+		return false
+	}
+
+	words := strings.Fields(msg.Params[1])
+	if len(words) < 2 || !containsFold(nickServIdentify, words[0]) {
+		return false
+	}
+
+	msg.Params[1] = words[0] + " " + Redacted
+
+	return true
+}
+
+// logRaw sends line to Config.Record, and to the configured raw log sinks if raw logging is enabled
+func (s *Connection) logRaw(direction Direction, line string) {
+	enabled := s.RawLogEnabled()
+	if !enabled && s.config.Record == nil {
+		return
+	}
+
+	record := &RawLogRecord{Time: time.Now(), Direction: direction, Line: RedactLine(line)}
+
+	if s.config.Record != nil {
+		if err := s.config.Record.WriteRecord(record); err != nil {
+			s.log.Warn("Could not record line", "error", err)
+		}
+	}
+
+	if !enabled {
+		return
+	}
+
+	sinks := s.config.RawLogSinks
+	if len(sinks) == 0 {
+		sinks = []RawLogSink{LoggerSink{Logger: s.log}}
+	}
+
+	for _, sink := range sinks {
+		if err := sink.WriteRecord(record); err != nil {
+			s.log.Warn("Could not write raw log record", "error", err)
+		}
+	}
+}
+
+// SetRawLog enables or disables raw logging. It is safe to call at any time.
+func (s *Connection) SetRawLog(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+
+	atomic.StoreInt32(&s.rawLog, v)
+}
+
+// RawLogEnabled returns whether or not raw logging is currently enabled
+func (s *Connection) RawLogEnabled() bool { return atomic.LoadInt32(&s.rawLog) == 1 }
+
+// LoggerSink is a RawLogSink that writes to a Logger. It is used when RawLog is enabled without any RawLogSinks
+type LoggerSink struct {
+	Logger logger.Logger // If nil, logger.Default() is used
+}
+
+// WriteRecord implements RawLogSink
+func (l LoggerSink) WriteRecord(record *RawLogRecord) error {
+	logger.Or(l.Logger).Info("Raw line", "direction", record.Direction, "line", record.Line)
+
+	return nil
+}
+
+// WriterSink is a RawLogSink that writes timestamped, human readable records to an io.Writer
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a WriterSink writing to w
+func NewWriterSink(w io.Writer) *WriterSink { return &WriterSink{w: w} }
+
+// WriteRecord implements RawLogSink
+func (w *WriterSink) WriteRecord(record *RawLogRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	timestamp := record.Time.Format(time.RFC3339Nano)
+	if _, err := fmt.Fprintf(w.w, "%s %s %s\n", timestamp, record.Direction.Arrow(), record.Line); err != nil {
+		return fmt.Errorf("could not write record: %w", err)
+	}
+
+	return nil
+}
+
+// JSONSink is a RawLogSink that writes records as JSON lines to an io.Writer
+type JSONSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONSink creates a JSONSink writing to w
+func NewJSONSink(w io.Writer) *JSONSink { return &JSONSink{encoder: json.NewEncoder(w)} }
+
+// WriteRecord implements RawLogSink
+func (j *JSONSink) WriteRecord(record *RawLogRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.encoder.Encode(record); err != nil {
+		return fmt.Errorf("could not write record: %w", err)
+	}
+
+	return nil
+}
+
+// RotatingFile is an io.WriteCloser that writes to a file, rotating it once it reaches a maximum size.
+// Rotated files have .1, .2, and so on appended to their names, with .1 being the most recent.
+// Use it with NewWriterSink or NewJSONSink.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile opens path for appending, creating it if needed. Once it would grow past maxSize bytes, it is
+// rotated, and at most maxBackups old files are kept. A maxSize of 0 or less disables rotation.
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	out := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := out.open(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o700); err != nil {
+		return fmt.Errorf("could not create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return fmt.Errorf("could not stat log file: %w", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("could not close log file: %w", err)
+	}
+
+	if r.maxBackups > 0 {
+		for i := r.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return fmt.Errorf("could not rotate log file: %w", err)
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return fmt.Errorf("could not rotate log file: %w", err)
+	}
+
+	return r.open()
+}
+
+// Write implements io.Writer
+func (r *RotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(b)
+	r.size += int64(n)
+
+	if err != nil {
+		return n, fmt.Errorf("could not write to log file: %w", err)
+	}
+
+	return n, nil
+}
+
+// Close implements io.Closer
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	if err != nil {
+		return fmt.Errorf("could not close log file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedactLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{name: "pass", line: "PASS hunter2\r\n", want: "PASS <redacted>"},
+		{name: "oper", line: "OPER admin hunter2", want: "OPER admin <redacted>"},
+		{name: "authenticate mech", line: "AUTHENTICATE PLAIN", want: "AUTHENTICATE PLAIN"},
+		{name: "authenticate continue", line: "AUTHENTICATE +", want: "AUTHENTICATE +"},
+		{name: "authenticate payload", line: "AUTHENTICATE dGVzdAB0ZXN0AGh1bnRlcjI=", want: "AUTHENTICATE <redacted>"},
+		{name: "challenge start", line: "CHALLENGE admin", want: "CHALLENGE admin"},
+		{name: "challenge response", line: "CHALLENGE +c2VjcmV0", want: "CHALLENGE <redacted>"},
+		{name: "challenge text", line: ":irc.test 740 nick :c2VjcmV0", want: ":irc.test 740 nick <redacted>"},
+		{name: "nickserv", line: "PRIVMSG NickServ :IDENTIFY account hunter2", want: "PRIVMSG NickServ :IDENTIFY <redacted>"},
+		{name: "ns", line: "NS IDENTIFY hunter2", want: "NS IDENTIFY <redacted>"},
+		{name: "nickserv help", line: "PRIVMSG NickServ :HELP", want: "PRIVMSG NickServ :HELP"},
+		{name: "normal", line: ":nick!u@h PRIVMSG #chan :hello there\r\n", want: ":nick!u@h PRIVMSG #chan :hello there"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := RedactLine(tt.line); got != tt.want {
+				t.Errorf("RedactLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSinks(t *testing.T) {
+	t.Parallel()
+
+	record := &RawLogRecord{
+		Time:      time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		Direction: DirectionOut,
+		Line:      "PRIVMSG #chan hi",
+	}
+
+	text := &bytes.Buffer{}
+	if err := NewWriterSink(text).WriteRecord(record); err != nil {
+		t.Fatalf("WriterSink.WriteRecord() error = %s", err)
+	}
+
+	if want := "2022-01-02T03:04:05Z << PRIVMSG #chan hi\n"; text.String() != want {
+		t.Errorf("WriterSink wrote %q, want %q", text.String(), want)
+	}
+
+	jsonOut := &bytes.Buffer{}
+	if err := NewJSONSink(jsonOut).WriteRecord(record); err != nil {
+		t.Fatalf("JSONSink.WriteRecord() error = %s", err)
+	}
+
+	decoded := &RawLogRecord{}
+	if err := json.Unmarshal(jsonOut.Bytes(), decoded); err != nil {
+		t.Fatalf("JSONSink wrote invalid JSON %q: %s", jsonOut.String(), err)
+	}
+
+	if *decoded != *record {
+		t.Errorf("JSONSink wrote %+v, want %+v", decoded, record)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "raw.log")
+
+	file, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %s", err)
+	}
+
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := file.Write([]byte(s)); err != nil {
+			t.Fatalf("Write() error = %s", err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() error = %s", err)
+	}
+
+	for suffix, want := range map[string]string{"": "dddddd\n", ".1": "cccccc\n", ".2": "bbbbbb\n"} {
+		got, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatalf("could not read %s: %s", path+suffix, err)
+		}
+
+		if string(got) != want {
+			t.Errorf("%s contains %q, want %q", path+suffix, got, want)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("more backups were kept than asked for")
+	}
+}
+
+type recordingSink struct {
+	mu      sync.Mutex
+	records []RawLogRecord
+}
+
+func (r *recordingSink) WriteRecord(record *RawLogRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, *record)
+
+	return nil
+}
+
+func TestConnection_SetRawLog(t *testing.T) {
+	t.Parallel()
+
+	sink := &recordingSink{}
+	conn := NewConnection(&Config{RawLogSinks: []RawLogSink{sink}})
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			conn.SetRawLog(i%2 == 0)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			conn.logRaw(DirectionIn, "PING :test")
+		}
+	}()
+
+	wg.Wait()
+
+	conn.SetRawLog(true)
+	conn.logRaw(DirectionOut, "PASS hunter2")
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if last := sink.records[len(sink.records)-1]; last.Direction != DirectionOut || strings.Contains(last.Line, "hunter2") {
+		t.Errorf("last record = %+v, want a redacted outgoing line", last)
+	}
+}
@@ -0,0 +1,181 @@
+package connection
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"awesome-dragon.science/go/irc/numerics"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Server is a single IRC server to connect to
+type Server struct {
+	Host                  string // Hostname of the server
+	Port                  string // Server port
+	TLS                   bool   // Use TLS
+	InsecureSkipVerifyTLS bool   // Skip verifying TLS Certificates
+	TLSCertPath           string
+	TLSKeyPath            string
+}
+
+// Address returns the Host and Port of the server, joined
+func (s Server) Address() string { return net.JoinHostPort(s.Host, s.Port) }
+
+// ErrNoServers is returned when connecting without any servers configured
+var ErrNoServers = errors.New("no servers configured")
+
+// ServerList returns the servers to connect to. This is Servers if it is set, otherwise a single server
+// made from Host, Port, and the TLS options.
+func (c *Config) ServerList() []Server {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+
+	return []Server{{
+		Host:                  c.Host,
+		Port:                  c.Port,
+		TLS:                   c.TLS,
+		InsecureSkipVerifyTLS: c.InsecureSkipVerifyTLS,
+		TLSCertPath:           c.TLSCertPath,
+		TLSKeyPath:            c.TLSKeyPath,
+	}}
+}
+
+// ServerRotation keeps track of which server to connect to next. Servers are tried in order, moving on when one
+// cannot be connected to, or when it closes the connection with an ERROR. Redirects sent by servers with
+// RPL_BOUNCE are tried before moving on to the next server in the list.
+//
+// A ServerRotation is safe for concurrent use, and can be shared between Connections so that reconnecting
+// carries on where the last connection left off.
+type ServerRotation struct {
+	mu        sync.Mutex
+	servers   []Server
+	index     int
+	redirects []Server
+}
+
+// NewServerRotation creates a ServerRotation over servers, shuffling them first if randomise is set
+func NewServerRotation(servers []Server, randomise bool) *ServerRotation {
+	out := &ServerRotation{servers: append([]Server(nil), servers...)}
+
+	if randomise {
+		//nolint:gosec // Its not for security
+		rand.Shuffle(len(out.servers), func(i, j int) { out.servers[i], out.servers[j] = out.servers[j], out.servers[i] })
+	}
+
+	return out
+}
+
+// Len returns the number of servers in the rotation, including any redirects
+func (r *ServerRotation) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.servers) + len(r.redirects)
+}
+
+// Current returns the server that should be connected to next. If there are no servers, false is returned
+func (r *ServerRotation) Current() (Server, bool) {
+	server, _, ok := r.current()
+
+	return server, ok
+}
+
+// current is like Current, but also returns whether or not the server is a redirect
+func (r *ServerRotation) current() (server Server, redirect, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.redirects) > 0 {
+		return r.redirects[0], true, true
+	}
+
+	if len(r.servers) == 0 {
+		return Server{}, false, false
+	}
+
+	return r.servers[r.index], false, true
+}
+
+// Next moves on from the current server. Redirects are discarded once moved on from, and the list of servers
+// wraps around.
+func (r *ServerRotation) Next() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.redirects) > 0 {
+		r.redirects = r.redirects[1:]
+
+		return
+	}
+
+	if len(r.servers) > 0 {
+		r.index = (r.index + 1) % len(r.servers)
+	}
+}
+
+// Redirect makes server the next one to be connected to. It is only tried once, whether or not the connection
+// to it succeeds.
+func (r *ServerRotation) Redirect(server Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.redirects = append([]Server{server}, r.redirects...)
+}
+
+// Server returns the server the connection was made to. It is only valid once Connect has succeeded
+func (s *Connection) Server() Server {
+	s.serverMu.Lock()
+	defer s.serverMu.Unlock()
+
+	return s.server
+}
+
+// quitSent returns whether or not a QUIT has been sent on this connection
+func (s *Connection) quitSent() bool { return atomic.LoadInt32(&s.quit) == 1 }
+
+// onRotationMessage moves the server rotation along when the server closes the connection on us, or
+// redirects us elsewhere
+func (s *Connection) onRotationMessage(msg *ircmsg.Message) {
+	switch msg.Command {
+	case "ERROR":
+		if s.quitSent() || len(msg.Params) == 0 || !strings.Contains(msg.Params[0], "Closing Link") {
+			return
+		}
+
+		// Servers that redirect us usually close the link straight after, moving on would skip the redirect
+		if s.redirected {
+			return
+		}
+
+		s.log.Info("Server closed the connection, moving on to the next server", "reason", msg.Params[0])
+		s.rotation.Next()
+
+	case numerics.RPL_BOUNCE:
+		// :server 010 nick host port :info
+		if len(msg.Params) < 3 {
+			return
+		}
+
+		redirect := s.Server()
+		redirect.Host = msg.Params[1]
+		redirect.Port = msg.Params[2]
+
+		if strings.HasPrefix(redirect.Port, "+") {
+			redirect.TLS = true
+			redirect.Port = redirect.Port[1:]
+		}
+
+		if redirect.Host == "" || redirect.Port == "" {
+			return
+		}
+
+		s.log.Info("Server redirected us", "redirect", redirect.Address())
+		s.rotation.Redirect(redirect)
+		s.redirected = true
+	}
+}
@@ -0,0 +1,193 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"awesome-dragon.science/go/irc/logger"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestServerRotation(t *testing.T) {
+	t.Parallel()
+
+	a, b := Server{Host: "a.test", Port: "6667"}, Server{Host: "b.test", Port: "6697", TLS: true}
+	redirect := Server{Host: "r.test", Port: "6667"}
+	rotation := NewServerRotation([]Server{a, b}, false)
+
+	steps := []struct {
+		name   string
+		action func()
+		want   Server
+	}{
+		{name: "start", action: func() {}, want: a},
+		{name: "next", action: rotation.Next, want: b},
+		{name: "wrap", action: rotation.Next, want: a},
+		{name: "redirect", action: func() { rotation.Redirect(redirect) }, want: redirect},
+		{name: "after redirect", action: rotation.Next, want: a},
+	}
+
+	for _, step := range steps {
+		step.action()
+
+		if got, ok := rotation.Current(); !ok || got != step.want {
+			t.Errorf("%s: Current() = %v, %t, want %v", step.name, got, ok, step.want)
+		}
+	}
+
+	if _, ok := NewServerRotation(nil, true).Current(); ok {
+		t.Error("Current() on an empty rotation returned a server")
+	}
+}
+
+func TestConfig_ServerList(t *testing.T) {
+	t.Parallel()
+
+	single := (&Config{Host: "irc.test", Port: "6697", TLS: true}).ServerList()
+	if len(single) != 1 || single[0] != (Server{Host: "irc.test", Port: "6697", TLS: true}) {
+		t.Errorf("ServerList() = %v, want a single server from Host and Port", single)
+	}
+
+	servers := []Server{{Host: "a.test"}, {Host: "b.test"}}
+	if got := (&Config{Host: "irc.test", Servers: servers}).ServerList(); len(got) != 2 || got[0] != servers[0] {
+		t.Errorf("ServerList() = %v, want %v", got, servers)
+	}
+}
+
+func TestConnection_Failover(t *testing.T) {
+	t.Parallel()
+
+	dead := listen(t)
+	deadHost, deadPort, _ := net.SplitHostPort(dead.Addr().String())
+	dead.Close()
+
+	aliveHost, alivePort, _ := net.SplitHostPort(ircServer(t, false))
+	alive := Server{Host: aliveHost, Port: alivePort}
+
+	conn := NewConnection(&Config{
+		Servers: []Server{{Host: deadHost, Port: deadPort}, alive},
+		Logger:  logger.Discard,
+	})
+
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	defer conn.cancelConnCtx()
+
+	if got := conn.Server(); got != alive {
+		t.Errorf("Server() = %v, want %v", got, alive)
+	}
+
+	if got, _ := conn.rotation.Current(); got != alive {
+		t.Errorf("rotation is on %v, want %v", got, alive)
+	}
+
+	empty := NewConnection(&Config{Rotation: NewServerRotation(nil, false), Logger: logger.Discard})
+	if err := empty.Connect(context.Background()); !errors.Is(err, ErrNoServers) {
+		t.Errorf("Connect() with no servers error = %v, want %v", err, ErrNoServers)
+	}
+}
+
+func TestConnection_onRotationMessage(t *testing.T) {
+	t.Parallel()
+
+	current := Server{Host: "a.test", Port: "6667"}
+	next := Server{Host: "b.test", Port: "6667"}
+
+	tests := []struct {
+		name string
+		line string
+		quit bool
+		want Server
+	}{
+		{name: "closing link", line: "ERROR :Closing Link: a.test (Killed)", want: next},
+		{name: "closing link after quit", line: "ERROR :Closing Link: a.test (Quit: bye)", quit: true, want: current},
+		{name: "other error", line: "ERROR :Something else", want: current},
+		{name: "bounce", line: ":a.test 010 nick r.test 7000 :Please use this server", want: Server{Host: "r.test", Port: "7000"}},
+		{
+			name: "bounce tls",
+			line: ":a.test 010 nick r.test +6697 :Please use this server",
+			want: Server{Host: "r.test", Port: "6697", TLS: true},
+		},
+		{name: "bounce missing port", line: ":a.test 010 nick r.test", want: current},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := NewConnection(&Config{Servers: []Server{current, next}, Logger: logger.Discard})
+			conn.server = current
+
+			if tt.quit {
+				atomic.StoreInt32(&conn.quit, 1)
+			}
+
+			msg, err := ircmsg.ParseLine(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn.onRotationMessage(&msg)
+
+			if got, _ := conn.rotation.Current(); got != tt.want {
+				t.Errorf("rotation is on %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnection_onRotationMessageRedirectThenError(t *testing.T) {
+	t.Parallel()
+
+	current := Server{Host: "a.test", Port: "6667"}
+	conn := NewConnection(&Config{Servers: []Server{current, {Host: "b.test", Port: "6667"}}, Logger: logger.Discard})
+	conn.server = current
+
+	for _, line := range []string{
+		":a.test 010 nick r.test 7000 :Please use this server",
+		"ERROR :Closing Link: a.test (Redirected)",
+	} {
+		msg, err := ircmsg.ParseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.onRotationMessage(&msg)
+	}
+
+	if got, _ := conn.rotation.Current(); got != (Server{Host: "r.test", Port: "7000"}) {
+		t.Errorf("rotation is on %v, want the redirect", got)
+	}
+}
+
+func TestConnection_RedirectTriedOnce(t *testing.T) {
+	t.Parallel()
+
+	host, port, _ := net.SplitHostPort(ircServer(t, false))
+	redirect := Server{Host: host, Port: port}
+	home := Server{Host: "home.test", Port: "6667"}
+
+	rotation := NewServerRotation([]Server{home}, false)
+	rotation.Redirect(redirect)
+
+	conn := NewConnection(&Config{Rotation: rotation, Logger: logger.Discard})
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %s", err)
+	}
+
+	defer conn.cancelConnCtx()
+
+	if got := conn.Server(); got != redirect {
+		t.Errorf("Server() = %v, want the redirect %v", got, redirect)
+	}
+
+	if got, _ := rotation.Current(); got != home {
+		t.Errorf("rotation is on %v after connecting to the redirect, want %v", got, home)
+	}
+}
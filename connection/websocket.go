@@ -0,0 +1,149 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// IRCv3 WebSocket subprotocols, see https://ircv3.net/specs/extensions/websocket
+const (
+	WebSocketTextProtocol   = "text.ircv3.net"
+	WebSocketBinaryProtocol = "binary.ircv3.net"
+)
+
+// WebSocketConfig configures a Connection to connect over WebSocket rather than a raw socket.
+// The servers, TLS options, and Proxy on Config are all still used.
+type WebSocketConfig struct {
+	Path   string      // Path to request, defaults to /
+	Binary bool        // Prefer binary.ircv3.net over text.ircv3.net. The server has the final say.
+	Header http.Header // Extra headers to send with the handshake, such as Origin
+}
+
+func (w *WebSocketConfig) url(server Server) string {
+	scheme := "ws"
+	if server.TLS {
+		scheme = "wss"
+	}
+
+	path := w.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return (&url.URL{Scheme: scheme, Host: server.Address(), Path: path}).String()
+}
+
+func (w *WebSocketConfig) subprotocols() []string {
+	if w.Binary {
+		return []string{WebSocketBinaryProtocol, WebSocketTextProtocol}
+	}
+
+	return []string{WebSocketTextProtocol, WebSocketBinaryProtocol}
+}
+
+func (s *Connection) openWebSocket(ctx context.Context, server Server) (net.Conn, error) {
+	wsConfig := s.config.WebSocket
+	target := wsConfig.url(server)
+
+	s.log.Debug("Opening WebSocket connection", "url", target)
+
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, _, addr string) (net.Conn, error) { return s.dial(ctx, addr) },
+		Subprotocols:   wsConfig.subprotocols(),
+	}
+
+	if server.TLS {
+		tlsConfig, err := tlsConfig(server)
+		if err != nil {
+			return nil, err
+		}
+
+		dialer.TLSClientConfig = tlsConfig
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, target, wsConfig.Header)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open WebSocket: %w", err)
+	}
+
+	// Servers that dont pick a subprotocol are assumed to be speaking text, as that's what existed first
+	return &webSocketConn{Conn: conn, binary: conn.Subprotocol() == WebSocketBinaryProtocol}, nil
+}
+
+// webSocketConn adapts a WebSocket to a net.Conn that looks like a raw IRC socket:
+// every WebSocket message is a single line, without a line ending.
+type webSocketConn struct {
+	*websocket.Conn
+	binary  bool
+	readBuf []byte
+}
+
+var _ net.Conn = (*webSocketConn)(nil)
+
+// Read implements io.Reader, returning incoming messages with line endings added
+func (w *webSocketConn) Read(p []byte) (int, error) {
+	for len(w.readBuf) == 0 {
+		_, data, err := w.Conn.ReadMessage()
+		if err != nil {
+			return 0, fmt.Errorf("websocket read: %w", err)
+		}
+
+		w.readBuf = append(data, '\r', '\n')
+	}
+
+	n := copy(p, w.readBuf)
+	w.readBuf = w.readBuf[n:]
+
+	return n, nil
+}
+
+// Write implements io.Writer, sending each line in p as its own message
+func (w *webSocketConn) Write(p []byte) (int, error) {
+	messageType := websocket.TextMessage
+	if w.binary {
+		messageType = websocket.BinaryMessage
+	}
+
+	for _, line := range bytes.Split(p, []byte{'\n'}) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+
+		if !w.binary {
+			// Text frames MUST be valid UTF-8
+			line = bytes.ToValidUTF8(line, []byte("�"))
+		}
+
+		if err := w.Conn.WriteMessage(messageType, line); err != nil {
+			return 0, fmt.Errorf("websocket write: %w", err)
+		}
+	}
+
+	return len(p), nil
+}
+
+// SetDeadline implements net.Conn
+func (w *webSocketConn) SetDeadline(t time.Time) error {
+	if err := w.Conn.SetReadDeadline(t); err != nil {
+		return fmt.Errorf("websocket: %w", err)
+	}
+
+	if err := w.Conn.SetWriteDeadline(t); err != nil {
+		return fmt.Errorf("websocket: %w", err)
+	}
+
+	return nil
+}
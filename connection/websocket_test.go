@@ -0,0 +1,140 @@
+package connection //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsResult struct {
+	messageType int
+	data        string
+}
+
+// webSocketServer starts a WebSocket server that offers the given subprotocols, sends a single line,
+// and reports the first message it receives
+func webSocketServer(t *testing.T, useTLS bool, protocols []string) (*httptest.Server, <-chan wsResult) {
+	t.Helper()
+
+	results := make(chan wsResult, 1)
+	upgrader := &websocket.Upgrader{Subprotocols: protocols}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/webirc" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		messageType := websocket.TextMessage
+		if conn.Subprotocol() == WebSocketBinaryProtocol {
+			messageType = websocket.BinaryMessage
+		}
+
+		_ = conn.WriteMessage(messageType, []byte(":irc.test NOTICE * :hello"))
+
+		gotType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		results <- wsResult{messageType: gotType, data: string(data)}
+	})
+
+	var server *httptest.Server
+	if useTLS {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+
+	t.Cleanup(server.Close)
+
+	return server, results
+}
+
+func TestConnection_WebSocket(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		tls       bool
+		binary    bool
+		protocols []string
+		wantType  int
+	}{
+		{name: "text", protocols: []string{WebSocketTextProtocol, WebSocketBinaryProtocol}, wantType: websocket.TextMessage},
+		{name: "binary", binary: true, protocols: []string{WebSocketBinaryProtocol}, wantType: websocket.BinaryMessage},
+		{name: "server only text", binary: true, protocols: []string{WebSocketTextProtocol}, wantType: websocket.TextMessage},
+		{name: "no subprotocol", wantType: websocket.TextMessage},
+		{name: "tls", tls: true, protocols: []string{WebSocketTextProtocol}, wantType: websocket.TextMessage},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server, results := webSocketServer(t, tt.tls, tt.protocols)
+			host, port, _ := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(server.URL, "http://"), "https://"))
+
+			conn := NewConnection(&Config{
+				Host:                  host,
+				Port:                  port,
+				TLS:                   tt.tls,
+				InsecureSkipVerifyTLS: true,
+				WebSocket:             &WebSocketConfig{Path: "webirc", Binary: tt.binary},
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			if err := conn.Connect(ctx); err != nil {
+				t.Fatalf("Connect() error = %s", err)
+			}
+
+			defer conn.cancelConnCtx()
+
+			select {
+			case line := <-conn.LineChan():
+				if line == nil || line.Command != "NOTICE" || line.Params[1] != "hello" {
+					t.Errorf("got unexpected line %#v", line)
+				}
+
+			case <-ctx.Done():
+				t.Fatal("timed out waiting for line")
+			}
+
+			if err := conn.WriteLine("PRIVMSG", "#test", "hello there"); err != nil {
+				t.Fatalf("WriteLine() error = %s", err)
+			}
+
+			select {
+			case res := <-results:
+				if res.data != "PRIVMSG #test :hello there" {
+					t.Errorf("server got %q, want %q", res.data, "PRIVMSG #test :hello there")
+				}
+
+				if res.messageType != tt.wantType {
+					t.Errorf("server got message type %d, want %d", res.messageType, tt.wantType)
+				}
+
+			case <-ctx.Done():
+				t.Fatal("timed out waiting for server to receive line")
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package event
+
+import "github.com/ergochat/irc-go/ircmsg"
+
+// Batch types that are commonly handled. https://ircv3.net/specs/extensions/batch
+const (
+	BatchNetsplit        = "netsplit"         // Users quitting due to a netsplit, params are the two servers
+	BatchNetjoin         = "netjoin"          // Users rejoining after a netsplit, params are the two servers
+	BatchChathistory     = "chathistory"      // Messages from CHATHISTORY, the param is the target
+	BatchLabeledResponse = "labeled-response" // The reply to a labelled command
+)
+
+// Batch is an IRCv3 batch, a group of lines the server has marked as belonging together.
+type Batch struct {
+	Ref    string            // The reference tag, only unique while the batch is open
+	Type   string            // The batch type, such as BatchNetsplit
+	Params []string          // Any parameters after the type
+	Tags   map[string]string // The tags on the line that opened the batch
+
+	Messages []*ircmsg.Message // Lines in this batch, not including BATCH lines or lines in nested batches
+	Batches  []*Batch          // Batches nested in this one
+	Parent   *Batch            // The batch this one is nested in, if any
+
+	// Lines is every line in this batch and those nested in it, including the BATCH lines, in the order they
+	// arrived, as they would have been given to OnMessage. It is filled in by the client as lines are handled.
+	Lines []*Message
+}
+
+// AllMessages returns Messages, followed by the messages in every nested batch
+func (b *Batch) AllMessages() []*ircmsg.Message {
+	out := append([]*ircmsg.Message{}, b.Messages...)
+	for _, nested := range b.Batches {
+		out = append(out, nested.AllMessages()...)
+	}
+
+	return out
+}
+
+// Historical returns whether or not b is, or is nested in, a chathistory batch. Lines in these batches were sent
+// some time ago, and are replayed, not happening now.
+func (b *Batch) Historical() bool {
+	for ; b != nil; b = b.Parent {
+		if b.Type == BatchChathistory {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BatchHandler is implemented by handlers that want whole batches. Each line is given to a handler once:
+//   - Handlers implementing BatchHandler get lines outside of batches with OnMessage, and each top level batch with
+//     OnBatch once it has ended, with Message.Raw set to the line that ended it. Lines in the batch are not given
+//     to OnMessage, they are in Batch.Lines instead.
+//   - Handlers only implementing MessageHandler get every line with OnMessage as it arrives, with Message.Batch
+//     set for those in a batch, except for lines in chathistory batches, which are replayed rather than live. See
+//     Message.Historical.
+type BatchHandler interface {
+	OnBatch(message *Message) error
+}
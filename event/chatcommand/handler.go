@@ -8,13 +8,11 @@ import (
 	"sync"
 
 	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/logger"
 	"awesome-dragon.science/go/irc/permissions"
 	"awesome-dragon.science/go/irc/user"
-	"github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger("irc") //nolint:gochecknoglobals // logger is fine :P
-
 // Handler implements a chat message command system. You must provide a prefix and MessageFunc for replies
 type Handler struct {
 	Prefix    string
@@ -24,6 +22,8 @@ type Handler struct {
 	MessageFunc func(string, string) error
 	// A permission system to use. If nil, no permission checks take place
 	PermissionHandler permissions.Handler
+	// Logger receives the Handler's logs. If nil, logger.Default() is used
+	Logger logger.Logger
 }
 
 // AddCommand errors
@@ -208,9 +208,11 @@ func (h *Handler) DoHelp(commandName string) string {
 	return out.String()
 }
 
+func (h *Handler) log() logger.Logger { return logger.Or(h.Logger) }
+
 func (h *Handler) reply(target, message string) {
 	if err := h.MessageFunc(target, message); err != nil {
-		log.Errorf("Failed to send message %q to %q: %s", target, message, err)
+		h.log().Error("Failed to send message", "target", target, "message", message, "error", err)
 	}
 }
 
@@ -227,7 +229,7 @@ func (h *Handler) callOK(cmd *command, replyTarget string, sourceUser *user.Ephe
 		// Next up, permissions
 		allowed, err := h.PermissionHandler.IsAuthorised(sourceUser, cmd.requiredPermissions)
 		if err != nil {
-			log.Infof("Permission check for %#v on command %q errored: %s", sourceUser, cmd.name, err)
+			h.log().Info("Permission check errored", "command", cmd.name, "user", sourceUser, "error", err)
 		}
 
 		if !allowed {
@@ -236,7 +238,7 @@ func (h *Handler) callOK(cmd *command, replyTarget string, sourceUser *user.Ephe
 			return false
 		}
 	} else {
-		log.Debug("Permissions handler is nil. Skipping all permissions checks.")
+		h.log().Debug("Permissions handler is nil. Skipping all permissions checks.")
 	}
 
 	if cmd.requiredArgs != -1 && len(args) < cmd.requiredArgs {
@@ -269,7 +271,7 @@ func (h *Handler) executeCommandIfExists(
 
 	defer func() {
 		if res := recover(); res != nil {
-			log.Criticalf("Caught panic while running command! %#v", res)
+			h.log().Error("Caught panic while running command", "command", cmd.name, "panic", fmt.Sprintf("%#v", res))
 
 			outErr = &CommandPanicedError{
 				CommandName: cmd.name,
@@ -279,10 +281,10 @@ func (h *Handler) executeCommandIfExists(
 		}
 	}()
 
-	log.Infof("Executing command %q for user %s", cmd.name, ev.SourceUser.Mask())
+	h.log().Info("Executing command", "command", cmd.name, "user", ev.SourceUser.Mask())
 
 	if err := cmd.callback(argsToSend); err != nil {
-		log.Errorf("Error while running command %q's callback: %s", cmd.name, err)
+		h.log().Error("Error while running command callback", "command", cmd.name, "error", err)
 
 		return err
 	}
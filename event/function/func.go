@@ -8,3 +8,12 @@ type FuncHandler func(msg *event.Message) error
 
 // OnMessage redirects the incoming message to the func on FuncHandler
 func (f FuncHandler) OnMessage(msg *event.Message) error { return f(msg) }
+
+// BatchFuncHandler is a thin wrapper around a function that is given every whole batch, and no individual lines
+type BatchFuncHandler func(msg *event.Message) error
+
+// OnMessage ignores the incoming message, BatchFuncHandler only wants whole batches
+func (f BatchFuncHandler) OnMessage(*event.Message) error { return nil }
+
+// OnBatch redirects the finished batch to the func on BatchFuncHandler
+func (f BatchFuncHandler) OnBatch(msg *event.Message) error { return f(msg) }
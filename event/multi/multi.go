@@ -33,6 +33,46 @@ func (m *Handler) OnMessage(msg *event.Message) error {
 	return outErr
 }
 
+// OnBatch implements event.BatchHandler, passing the batch on to every handler that also implements it. As a
+// Handler is given lines in batches with OnBatch, handlers that do not implement it are given each of those lines
+// with OnMessage here instead, skipping replayed history.
+func (m *Handler) OnBatch(msg *event.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	outErr := &event.MultiError{}
+
+	for _, h := range m.handlers {
+		if batchHandler, ok := h.(event.BatchHandler); ok {
+			if err := batchHandler.OnBatch(msg); err != nil {
+				outErr.Errors = append(outErr.Errors, err)
+			}
+
+			continue
+		}
+
+		if msg.Batch == nil {
+			continue
+		}
+
+		for _, line := range msg.Batch.Lines {
+			if line.Historical() {
+				continue
+			}
+
+			if err := h.OnMessage(line); err != nil {
+				outErr.Errors = append(outErr.Errors, err)
+			}
+		}
+	}
+
+	if len(outErr.Errors) == 0 {
+		return nil
+	}
+
+	return outErr
+}
+
 // AddHandlers adds a handler to the MultiHandler instance
 func (m *Handler) AddHandlers(h ...event.MessageHandler) {
 	m.mu.Lock()
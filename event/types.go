@@ -12,6 +12,20 @@ type Message struct {
 	SourceUser    *user.EphemeralUser
 	CurrentNick   string
 	AvailableCaps []capab.Capability
+
+	// Batch is the batch this line is part of, if any. On the BATCH lines that start and end a batch, it is that
+	// batch, and once it has ended, it contains every line in it.
+	Batch *Batch
+}
+
+// InBatch returns whether or not the message is part of a batch, including the BATCH lines around it
+func (m *Message) InBatch() bool {
+	return m.Batch != nil
+}
+
+// Historical returns whether or not the message is part of a chathistory batch, and so is being replayed
+func (m *Message) Historical() bool {
+	return m.Batch.Historical()
 }
 
 // MessageHandler represents anything that can deal with an IRC message
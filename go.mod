@@ -4,8 +4,9 @@ go 1.17
 
 require (
 	github.com/ergochat/irc-go v0.1.0
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
+	github.com/gorilla/websocket v1.5.0
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a
+	golang.org/x/text v0.13.0
 )
 
 require golang.org/x/crypto v0.0.0-20220214200702-86341886e292 // indirect
@@ -0,0 +1,63 @@
+package isupport
+
+import "strings"
+
+// Casemappings that Casefold knows about.
+// https://modern.ircdocs.horse/#casemapping-parameter
+const (
+	CaseMappingASCII         = "ascii"
+	CaseMappingRFC1459       = "rfc1459"
+	CaseMappingStrictRFC1459 = "strict-rfc1459"
+	CaseMappingRFC7613       = "rfc7613"
+)
+
+// Casefold folds name using the given casemapping, so that two names that the server considers to be the same
+// fold to the same string. Unknown casemappings, and rfc7613, are folded with strings.ToLower. An empty
+// casemapping is treated as rfc1459, as that is what servers default to.
+func Casefold(casemapping, name string) string {
+	switch strings.ToLower(casemapping) {
+	case CaseMappingASCII:
+		return strings.Map(foldASCII, name)
+
+	case CaseMappingRFC1459, "":
+		return strings.Map(foldRFC1459, name)
+
+	case CaseMappingStrictRFC1459:
+		return strings.Map(foldStrictRFC1459, name)
+
+	default:
+		return strings.ToLower(name)
+	}
+}
+
+func foldASCII(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + ('a' - 'A')
+	}
+
+	return r
+}
+
+func foldRFC1459(r rune) rune {
+	if r == '~' {
+		return '^'
+	}
+
+	return foldStrictRFC1459(r)
+}
+
+func foldStrictRFC1459(r rune) rune {
+	switch r {
+	case '[':
+		return '{'
+	case ']':
+		return '}'
+	case '\\':
+		return '|'
+	default:
+		return foldASCII(r)
+	}
+}
+
+// Casefold folds name using the server's CASEMAPPING, see the Casefold function
+func (i *ISupport) Casefold(name string) string { return Casefold(i.CaseMapping(), name) }
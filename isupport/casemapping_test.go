@@ -0,0 +1,48 @@
+package isupport_test
+
+import (
+	"testing"
+
+	"awesome-dragon.science/go/irc/isupport"
+)
+
+func TestCasefold(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		casemapping string
+		arg         string
+		want        string
+	}{
+		{name: "ascii", casemapping: "ascii", arg: "Foo[]\\~Bar", want: "foo[]\\~bar"},
+		{name: "rfc1459", casemapping: "rfc1459", arg: "Foo[]\\~Bar", want: "foo{}|^bar"},
+		{name: "strict-rfc1459", casemapping: "strict-rfc1459", arg: "Foo[]\\~Bar", want: "foo{}|~bar"},
+		{name: "unset is rfc1459", casemapping: "", arg: "#Chan[1]", want: "#chan{1}"},
+		{name: "rfc7613", casemapping: "rfc7613", arg: "ÀBC", want: "àbc"},
+		{name: "ascii leaves unicode", casemapping: "ascii", arg: "ÀBC", want: "Àbc"},
+		{name: "unknown", casemapping: "something-new", arg: "ABC[", want: "abc["},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := isupport.Casefold(tt.casemapping, tt.arg); got != tt.want {
+				t.Errorf("Casefold(%q, %q) = %q, want %q", tt.casemapping, tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestISupport_Casefold(t *testing.T) {
+	t.Parallel()
+
+	if got := iSupport.Casefold("Nick[Away]"); got != "nick{away}" {
+		t.Errorf("ISupport.Casefold() = %q, want %q", got, "nick{away}")
+	}
+
+	if got := makeIS("CASEMAPPING=ascii").Casefold("Nick[Away]"); got != "nick[away]" {
+		t.Errorf("ISupport.Casefold() = %q, want %q", got, "nick[away]")
+	}
+}
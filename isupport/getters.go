@@ -60,6 +60,45 @@ func (i *ISupport) MaxChanLen() int { return i.NumericToken("CHANNELLEN") }
 // https://modern.ircdocs.horse/#chantypes-parameter
 func (i *ISupport) ChanTypes() []string { return i.listToken("CHANTYPES") }
 
+// MaxChathistory returns the maximum number of messages that can be asked for in a single CHATHISTORY command,
+// 0 if unlimited, or -1 if unset
+// https://ircv3.net/specs/extensions/chathistory#isupport-tokens
+func (i *ISupport) MaxChathistory() int { return i.NumericToken("CHATHISTORY") }
+
+// ClientTagDeny returns the list of client-only tags (without their + prefix) that the server will block.
+// An entry of "*" denies all tags, in which case entries prefixed with - are allowed.
+// https://ircv3.net/specs/extensions/message-tags#rpl_isupport-tokens
+func (i *ISupport) ClientTagDeny() []string {
+	res := i.getTokenDontCare("CLIENTTAGDENY")
+	if res == "" {
+		return nil
+	}
+
+	return strings.Split(res, ",")
+}
+
+// ClientTagAllowed returns whether or not the given client-only tag (with or without its + prefix) may be sent,
+// according to CLIENTTAGDENY
+func (i *ISupport) ClientTagAllowed(tag string) bool {
+	tag = strings.TrimPrefix(tag, "+")
+	allowed := true
+
+	for _, deny := range i.ClientTagDeny() {
+		switch deny {
+		case "*":
+			allowed = false
+
+		case "-" + tag:
+			return true
+
+		case tag:
+			return false
+		}
+	}
+
+	return allowed
+}
+
 // EList returns the supported extensions to the LIST command
 // https://modern.ircdocs.horse/#elist-parameter
 func (i *ISupport) EList() []string { return i.listToken("ELIST") }
@@ -176,6 +215,21 @@ func (i *ISupport) prefix(locked bool) map[rune]rune {
 // https://modern.ircdocs.horse/#prefix-parameter
 func (i *ISupport) Prefix() map[rune]rune { return i.prefix(false) }
 
+// PrefixModes returns the prefix mode characters in order of rank, highest first, or "" if PREFIX is unset
+func (i *ISupport) PrefixModes() string {
+	res, exists := i.GetToken("PREFIX")
+	if !exists || !strings.HasPrefix(res, "(") {
+		return ""
+	}
+
+	end := strings.IndexByte(res, ')')
+	if end == -1 {
+		return ""
+	}
+
+	return res[1:end]
+}
+
 // SafeList returns whether or not LIST usage promises to not RECVQ (disconnect
 // due to large buffer of sent data server side)
 // https://modern.ircdocs.horse/#safelist-parameter
@@ -221,3 +275,7 @@ func (i *ISupport) MaxCommandTargets() map[string]int {
 // MaxTopicLen returns the maximum topic size the client should send to the server
 // https://modern.ircdocs.horse/#topiclen-parameter
 func (i *ISupport) MaxTopicLen() int { return i.NumericToken("TOPICLEN") }
+
+// UTF8Only returns whether or not the server only allows UTF-8 to be sent to it, and promises to only send UTF-8
+// https://ircv3.net/specs/extensions/utf8-only
+func (i *ISupport) UTF8Only() bool { return i.HasToken("UTF8ONLY") }
@@ -125,6 +125,48 @@ func TestISupport_ChanTypes(t *testing.T) {
 	}
 }
 
+func TestISupport_ClientTagDeny(t *testing.T) {
+	t.Parallel()
+
+	if res := iSupport.ClientTagDeny(); res != nil {
+		t.Errorf("ISupport.ClientTagDeny() = %#v, want nil", res)
+	}
+
+	is := makeIS("CLIENTTAGDENY=*,-draft/reply")
+	if want, res := []string{"*", "-draft/reply"}, is.ClientTagDeny(); !reflect.DeepEqual(want, res) {
+		t.Errorf("ISupport.ClientTagDeny() = %#v, want %#v", res, want)
+	}
+}
+
+func TestISupport_ClientTagAllowed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		is   *isupport.ISupport
+		tag  string
+		want bool
+	}{
+		{name: "none", is: iSupport, tag: "+typing", want: true},
+		{name: "denied", is: makeIS("CLIENTTAGDENY=typing"), tag: "+typing", want: false},
+		{name: "other denied", is: makeIS("CLIENTTAGDENY=typing"), tag: "+draft/reply", want: true},
+		{name: "all denied", is: makeIS("CLIENTTAGDENY=*"), tag: "+typing", want: false},
+		{name: "exempt", is: makeIS("CLIENTTAGDENY=*,-draft/reply"), tag: "+draft/reply", want: true},
+		{name: "not exempt", is: makeIS("CLIENTTAGDENY=*,-draft/reply"), tag: "typing", want: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.is.ClientTagAllowed(tt.tag); got != tt.want {
+				t.Errorf("ISupport.ClientTagAllowed(%q) = %v, want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestISupport_EList(t *testing.T) {
 	t.Parallel()
 
@@ -395,6 +437,30 @@ func TestISupport_MaxNickLen(t *testing.T) {
 	}
 }
 
+func TestISupport_MaxChathistory(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		is   *isupport.ISupport
+		want int
+	}{
+		{name: "limited", is: makeIS("CHATHISTORY=100"), want: 100},
+		{name: "unlimited", is: makeIS("CHATHISTORY=0"), want: 0},
+		{name: "unset", is: makeIS(), want: -1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.is.MaxChathistory(); got != tt.want {
+				t.Errorf("ISupport.MaxChathistory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestISupport_Prefix(t *testing.T) {
 	t.Parallel()
 
@@ -418,6 +484,31 @@ func TestISupport_Prefix(t *testing.T) {
 	}
 }
 
+func TestISupport_PrefixModes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		is   *isupport.ISupport
+		want string
+	}{
+		{name: "libera", is: iSupport, want: "ov"},
+		{name: "many", is: makeIS("PREFIX=(qaohv)~&@%+"), want: "qaohv"},
+		{name: "broken", is: makeIS("PREFIX=ov"), want: ""},
+		{name: "none", is: makeIS(), want: ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.is.PrefixModes(); got != tt.want {
+				t.Errorf("ISupport.PrefixModes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestISupport_SafeList(t *testing.T) {
 	t.Parallel()
 
@@ -546,3 +637,27 @@ func TestISupport_MaxTopicLen(t *testing.T) {
 		})
 	}
 }
+
+func TestISupport_UTF8Only(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		is   *isupport.ISupport
+		want bool
+	}{
+		{name: "libera", is: iSupport, want: false},
+		{name: "exists", is: makeIS("UTF8ONLY"), want: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.is.UTF8Only(); got != tt.want {
+				t.Errorf("ISupport.UTF8Only() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -64,7 +64,7 @@ func (i *ISupport) Parse(msg *ircmsg.Message) {
 
 func (i *ISupport) setupPrefixModes(prefixModes map[rune]rune) {
 outer:
-	for p, m := range prefixModes {
+	for m, p := range prefixModes {
 		for idx, real := range i.channelModes {
 			// just in case
 			if real.Char == m {
@@ -59,7 +59,7 @@ func TestISupport_Modes(t *testing.T) {
 	}
 
 	is := isupport.New()
-	is.Parse(parseLineMust(":a.b.c 005 test CHANMODES=a,b,c,d PREFIX=(@)o :are supported by this server"))
+	is.Parse(parseLineMust(":a.b.c 005 test CHANMODES=a,b,c,d PREFIX=(o)@ :are supported by this server"))
 
 	if res := is.Modes(); !reflect.DeepEqual(res, wantModes) {
 		t.Errorf("is.Modes() = %v, want %v", res, wantModes)
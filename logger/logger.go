@@ -0,0 +1,204 @@
+// Package logger defines the small logging interface used throughout the library.
+//
+// Logger is a subset of *slog.Logger, so a *slog.Logger can be used anywhere a Logger is expected. Messages are
+// short and constant, with any details given as alternating key/value pairs, in the same way as log/slog.
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is a structured, levelled logger. args are alternating keys and values. *slog.Logger implements Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level is the severity of a log message
+type Level int
+
+// Log levels. These have the same values as their log/slog counterparts
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// Or returns l if it is not nil, and Default() otherwise
+func Or(l Logger) Logger {
+	if l == nil {
+		return Default()
+	}
+
+	return l
+}
+
+// With returns a Logger that adds args to every message logged with it
+func With(l Logger, args ...interface{}) Logger {
+	if len(args) == 0 {
+		return l
+	}
+
+	if w, ok := l.(*withLogger); ok {
+		return &withLogger{parent: w.parent, args: concat(w.args, args)}
+	}
+
+	return &withLogger{parent: l, args: args}
+}
+
+type withLogger struct {
+	parent Logger
+	args   []interface{}
+}
+
+func concat(a, b []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(a)+len(b))
+
+	return append(append(out, a...), b...)
+}
+
+func (w *withLogger) Debug(msg string, args ...interface{}) {
+	w.parent.Debug(msg, concat(w.args, args)...)
+}
+
+func (w *withLogger) Info(msg string, args ...interface{}) {
+	w.parent.Info(msg, concat(w.args, args)...)
+}
+
+func (w *withLogger) Warn(msg string, args ...interface{}) {
+	w.parent.Warn(msg, concat(w.args, args)...)
+}
+
+func (w *withLogger) Error(msg string, args ...interface{}) {
+	w.parent.Error(msg, concat(w.args, args)...)
+}
+
+// Discard is a Logger that drops everything logged to it
+var Discard Logger = discard{} //nolint:gochecknoglobals // Its stateless
+
+type discard struct{}
+
+func (discard) Debug(string, ...interface{}) {}
+func (discard) Info(string, ...interface{})  {}
+func (discard) Warn(string, ...interface{})  {}
+func (discard) Error(string, ...interface{}) {}
+
+var (
+	defaultMu     sync.Mutex //nolint:gochecknoglobals // Protects defaultLogger
+	defaultLogger Logger     //nolint:gochecknoglobals // Used when nothing else is configured
+)
+
+// Default returns the Logger used when none is configured. Unless changed with SetDefault, this is a TextLogger
+// writing everything to stderr.
+func Default() Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultLogger == nil {
+		defaultLogger = NewTextLogger(os.Stderr, LevelDebug)
+	}
+
+	return defaultLogger
+}
+
+// SetDefault changes the Logger returned by Default. It only affects things created after it is called.
+func SetDefault(l Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultLogger = l
+}
+
+// TextLogger is a Logger that writes human readable lines to an io.Writer
+type TextLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewTextLogger creates a TextLogger that writes messages at level or above to w
+func NewTextLogger(w io.Writer, level Level) *TextLogger {
+	return &TextLogger{w: w, level: level}
+}
+
+// Debug implements Logger
+func (t *TextLogger) Debug(msg string, args ...interface{}) { t.log(LevelDebug, msg, args) }
+
+// Info implements Logger
+func (t *TextLogger) Info(msg string, args ...interface{}) { t.log(LevelInfo, msg, args) }
+
+// Warn implements Logger
+func (t *TextLogger) Warn(msg string, args ...interface{}) { t.log(LevelWarn, msg, args) }
+
+// Error implements Logger
+func (t *TextLogger) Error(msg string, args ...interface{}) { t.log(LevelError, msg, args) }
+
+func (t *TextLogger) log(level Level, msg string, args []interface{}) {
+	if level < t.level {
+		return
+	}
+
+	out := &strings.Builder{}
+	out.WriteString(time.Now().Format("15:04:05.000"))
+	out.WriteByte(' ')
+	out.WriteString(level.String())
+	out.WriteByte(' ')
+	out.WriteString(msg)
+
+	for i := 0; i < len(args); i += 2 {
+		out.WriteByte(' ')
+
+		if i+1 == len(args) {
+			// Same as slog does with a dangling value
+			out.WriteString("!BADKEY=")
+			out.WriteString(formatValue(args[i]))
+
+			break
+		}
+
+		out.WriteString(fmt.Sprint(args[i]))
+		out.WriteByte('=')
+		out.WriteString(formatValue(args[i+1]))
+	}
+
+	out.WriteByte('\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, _ = io.WriteString(t.w, out.String())
+}
+
+// formatValue formats v, quoting it if it would otherwise be ambiguous
+func formatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\\") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
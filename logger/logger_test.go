@@ -0,0 +1,99 @@
+package logger //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		level Level
+		log   func(l Logger)
+		want  string
+	}{
+		{
+			name:  "plain",
+			level: LevelDebug,
+			log:   func(l Logger) { l.Info("Hello") },
+			want:  "INFO Hello\n",
+		},
+		{
+			name:  "fields",
+			level: LevelDebug,
+			log:   func(l Logger) { l.Warn("Something broke", "command", "PRIVMSG", "error", errors.New("oh no")) },
+			want:  `WARN Something broke command=PRIVMSG error="oh no"` + "\n",
+		},
+		{
+			name:  "empty value",
+			level: LevelDebug,
+			log:   func(l Logger) { l.Error("Bad", "nick", "") },
+			want:  `ERROR Bad nick=""` + "\n",
+		},
+		{
+			name:  "dangling value",
+			level: LevelDebug,
+			log:   func(l Logger) { l.Debug("Odd", "key", 1, "extra") },
+			want:  "DEBUG Odd key=1 !BADKEY=extra\n",
+		},
+		{
+			name:  "below level",
+			level: LevelWarn,
+			log:   func(l Logger) { l.Info("Hidden") },
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := &bytes.Buffer{}
+			tt.log(NewTextLogger(out, tt.level))
+
+			got := out.String()
+			if got != "" {
+				// Strip the timestamp
+				got = got[strings.IndexByte(got, ' ')+1:]
+			}
+
+			if got != tt.want {
+				t.Errorf("TextLogger wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWith(t *testing.T) {
+	t.Parallel()
+
+	out := &bytes.Buffer{}
+	l := With(With(NewTextLogger(out, LevelDebug), "network", "Test"), "nick", "bot")
+
+	l.Info("Hi", "command", "JOIN")
+
+	if got, want := out.String(), "INFO Hi network=Test nick=bot command=JOIN\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("With wrote %q, want suffix %q", got, want)
+	}
+
+	if With(Discard) != Discard {
+		t.Error("With() with no args should return the logger unchanged")
+	}
+}
+
+func TestOr(t *testing.T) {
+	t.Parallel()
+
+	if Or(nil) == nil {
+		t.Error("Or(nil) returned nil")
+	}
+
+	if Or(Discard) != Discard {
+		t.Error("Or(Discard) did not return Discard")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build go1.21
+// +build go1.21
+
+package logger //nolint:testpackage // Needed to test internal stuff
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	t.Parallel()
+
+	out := &bytes.Buffer{}
+
+	var l Logger = slog.New(slog.NewTextHandler(out, nil))
+
+	With(l, "network", "Test").Info("Hi", "command", "JOIN")
+
+	if got, want := out.String(), `msg=Hi network=Test command=JOIN`; !strings.Contains(got, want) {
+		t.Errorf("slog wrote %q, want it to contain %q", got, want)
+	}
+}
@@ -269,17 +269,21 @@ func (m Set) ParseModeSequence(sequence string) Sequence {
 		param = ""
 
 		mode := m.GetMode(r)
-		switch mode.Type {
-		case TypeUnknown, TypeD:
+		switch {
+		case mode.Prefix != "":
+			// prefix modes always have a nick as their parameter
+			param, split = popLeft(split)
+
+		case mode.Type == TypeUnknown, mode.Type == TypeD:
 			// we dont know what this is, thus we assume it does *not* have
 			// a parameter
 			// or its TypeD, which also never has a parameter
 
-		case TypeA, TypeB:
+		case mode.Type == TypeA, mode.Type == TypeB:
 			// always has a parameter
 			param, split = popLeft(split)
 
-		case TypeC:
+		case mode.Type == TypeC:
 			// only has a parameter when setting
 			if adding {
 				param, split = popLeft(split)
@@ -97,6 +97,16 @@ func TestModeSet_ParseModeSequence(t *testing.T) { //nolint:funlen // Its a test
 				{Adding: true, Mode: modeset.GetMode('z')},
 			},
 		},
+		{
+			name: "prefix modes take nicks",
+			args: "+ov-o+m nick1 nick2 nick3",
+			want: mode.Sequence{
+				{Adding: true, Mode: modeset.GetMode('o'), Parameter: "nick1"},
+				{Adding: true, Mode: modeset.GetMode('v'), Parameter: "nick2"},
+				{Adding: false, Mode: modeset.GetMode('o'), Parameter: "nick3"},
+				{Adding: true, Mode: modeset.GetMode('m')},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -11,6 +11,7 @@ const (
 	RPL_WELCOME     = "001"
 	RPL_MYINFO      = "004"
 	RPL_ISUPPORT    = "005"
+	RPL_BOUNCE      = "010"
 	RPL_MOTD        = "372"
 	RPL_MOTDSTART   = "375"
 	RPL_ENDOFMOTD   = "376"
@@ -20,8 +21,11 @@ const (
 	RPL_TRYAGAIN    = "263"
 	RPL_YOUREOPER   = "381"
 
-	ERR_NOSUCHNICK   = "401"
-	ERR_NOSUCHSERVER = "402"
+	ERR_NOSUCHNICK     = "401"
+	ERR_NOSUCHSERVER   = "402"
+	ERR_WASNOSUCHNICK  = "406"
+	ERR_UNKNOWNCOMMAND = "421"
+	ERR_NOTREGISTERED  = "451"
 
 	RPL_CHANNELMODEIS = "324"
 	RPL_CREATIONTIME  = "329"
@@ -57,6 +61,7 @@ const (
 	RPL_WHOISIDLE     = "317"
 	RPL_WHOISCHANNELS = "319"
 	RPL_WHOISACCOUNT  = "330"
+	RPL_WHOISACTUALLY = "338"
 	RPL_WHOISHOST     = "378"
 	RPL_WHOISMODES    = "379"
 	RPL_WHOISSECURE   = "671"
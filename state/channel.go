@@ -0,0 +1,170 @@
+package state
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"awesome-dragon.science/go/irc/isupport"
+	"awesome-dragon.science/go/irc/mode"
+)
+
+// Topic is the topic of a channel
+type Topic struct {
+	Text  string
+	SetBy string    // Nick or n!u@h of whoever set the topic, if known
+	SetAt time.Time // When the topic was set, if known
+}
+
+// Member is a user in a channel
+type Member struct {
+	Nick     string
+	Modes    string // Prefix modes the member has, highest ranked first, eg "ov"
+	Prefixes string // The prefixes for Modes, eg "@+"
+}
+
+// Prefix returns the prefix of the member's highest ranked mode, or "" if they have none
+func (m Member) Prefix() string {
+	for _, p := range m.Prefixes {
+		return string(p)
+	}
+
+	return ""
+}
+
+// HasMode returns whether or not the member has the given prefix mode
+func (m Member) HasMode(char rune) bool { return strings.ContainsRune(m.Modes, char) }
+
+// Channel is a snapshot of the state of a channel
+type Channel struct {
+	Name      string
+	Topic     Topic
+	Modes     mode.Sequence // Modes set on the channel, other than list modes and prefix modes
+	CreatedAt time.Time     // When the channel was created, if known
+	Members   []Member      // Sorted by nick
+
+	casemapping string
+}
+
+// Member looks up a member of the channel by nick
+func (c *Channel) Member(nick string) (Member, bool) {
+	folded := isupport.Casefold(c.casemapping, nick)
+	for _, m := range c.Members {
+		if isupport.Casefold(c.casemapping, m.Nick) == folded {
+			return m, true
+		}
+	}
+
+	return Member{}, false
+}
+
+// HasMode returns whether or not the given mode is set on the channel. List modes and prefix modes are not
+// tracked, and are never reported as set.
+func (c *Channel) HasMode(char rune) bool {
+	_, ok := c.ModeParameter(char)
+
+	return ok
+}
+
+// ModeParameter returns the parameter of a mode set on the channel, and whether or not it is set at all
+func (c *Channel) ModeParameter(char rune) (string, bool) {
+	for _, m := range c.Modes {
+		if m.Char == char {
+			return m.Parameter, true
+		}
+	}
+
+	return "", false
+}
+
+// channel is the live version of Channel, protected by Tracker.mu
+type channel struct {
+	name      string
+	topic     Topic
+	modes     mode.Sequence
+	createdAt time.Time
+	members   map[string]*member // Keyed by casefolded nick
+	names     map[string]*member // Members from an RPL_NAMREPLY that hasn't finished yet
+}
+
+func newChannel(name string) *channel {
+	return &channel{name: name, members: make(map[string]*member)}
+}
+
+// setMode applies a change to a mode that isn't a list or prefix mode
+func (c *channel) setMode(change mode.SequenceEntry) {
+	for i, m := range c.modes {
+		if m.Char == change.Char {
+			c.modes = append(c.modes[:i:i], c.modes[i+1:]...)
+
+			break
+		}
+	}
+
+	if change.Adding {
+		c.modes = append(c.modes, change)
+	}
+}
+
+func (c *channel) snapshot(casemapping string, prefixes map[rune]rune) Channel {
+	out := Channel{
+		Name:        c.name,
+		Topic:       c.topic,
+		Modes:       append(mode.Sequence(nil), c.modes...),
+		CreatedAt:   c.createdAt,
+		Members:     make([]Member, 0, len(c.members)),
+		casemapping: casemapping,
+	}
+
+	for _, m := range c.members {
+		out.Members = append(out.Members, m.snapshot(prefixes))
+	}
+
+	sort.Slice(out.Members, func(i, j int) bool {
+		return isupport.Casefold(casemapping, out.Members[i].Nick) < isupport.Casefold(casemapping, out.Members[j].Nick)
+	})
+
+	return out
+}
+
+// member is the live version of Member, protected by Tracker.mu
+type member struct {
+	nick  string
+	modes string
+}
+
+// setMode adds or removes a prefix mode, keeping modes in the order given by rank
+func (m *member) setMode(char rune, adding bool, rank string) {
+	has := strings.ContainsRune(m.modes, char)
+
+	switch {
+	case adding && !has:
+		modes := []rune(m.modes + string(char))
+		sort.SliceStable(modes, func(i, j int) bool { return rankOf(modes[i], rank) < rankOf(modes[j], rank) })
+		m.modes = string(modes)
+
+	case !adding && has:
+		m.modes = strings.Replace(m.modes, string(char), "", 1)
+	}
+}
+
+func (m *member) snapshot(prefixes map[rune]rune) Member {
+	out := Member{Nick: m.nick, Modes: m.modes}
+
+	for _, char := range m.modes {
+		if p, ok := prefixes[char]; ok {
+			out.Prefixes += string(p)
+		}
+	}
+
+	return out
+}
+
+// rankOf returns where char is in rank, with unknown modes ranked last
+func rankOf(char rune, rank string) int {
+	if idx := strings.IndexRune(rank, char); idx != -1 {
+		return idx
+	}
+
+	return len(rank)
+}
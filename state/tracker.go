@@ -0,0 +1,343 @@
+// Package state tracks the state of the network a client is connected to, as told to it by the server
+package state
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/isupport"
+	"awesome-dragon.science/go/irc/mode"
+	"awesome-dragon.science/go/irc/numerics"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Tracker keeps track of the channels we are in, their members, modes, and topics, and the users we share them
+// with. It is fed every message from the server with OnMessage, and only knows about channels that it has seen
+// us join.
+//
+// All lookups are casemapping aware, and everything returned is a copy, so a Tracker is safe for concurrent use.
+type Tracker struct {
+	mu       sync.RWMutex
+	isupport *isupport.ISupport
+	channels map[string]*channel   // Keyed by casefolded name
+	users    map[string]*user.User // Keyed by casefolded nick
+}
+
+// NewTracker creates a Tracker that uses is for casemapping, prefixes, and channel modes
+func NewTracker(is *isupport.ISupport) *Tracker {
+	return &Tracker{isupport: is, channels: make(map[string]*channel), users: make(map[string]*user.User)}
+}
+
+func (t *Tracker) fold(name string) string { return t.isupport.Casefold(name) }
+
+// Channel returns a snapshot of the named channel, if we are in it
+func (t *Tracker) Channel(name string) (Channel, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	c, ok := t.channels[t.fold(name)]
+	if !ok {
+		return Channel{}, false
+	}
+
+	return c.snapshot(t.isupport.CaseMapping(), t.isupport.Prefix()), true
+}
+
+// Channels returns the names of all the channels we are in, sorted
+func (t *Tracker) Channels() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := make([]string, 0, len(t.channels))
+	for _, c := range t.channels {
+		out = append(out, c.name)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+// Member returns a channel member by nick
+func (t *Tracker) Member(channel, nick string) (Member, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	c, ok := t.channels[t.fold(channel)]
+	if !ok {
+		return Member{}, false
+	}
+
+	m, ok := c.members[t.fold(nick)]
+	if !ok {
+		return Member{}, false
+	}
+
+	return m.snapshot(t.isupport.Prefix()), true
+}
+
+// IsOn returns whether or not nick is in the given channel
+func (t *Tracker) IsOn(channel, nick string) bool {
+	_, ok := t.Member(channel, nick)
+
+	return ok
+}
+
+// OnMessage implements event.MessageHandler. msg.CurrentNick must be our nick before msg was received, so that
+// our own JOINs, PARTs, and KICKs can be recognised.
+func (t *Tracker) OnMessage(msg *event.Message) error {
+	raw := msg.Raw
+	source, _ := ircmsg.ParseNUH(raw.Source)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	us := t.fold(msg.CurrentNick)
+	isUs := func(nick string) bool { return t.fold(nick) == us }
+
+	t.updateChannels(raw, source, isUs)
+	t.updateUsers(raw, source, isUs)
+
+	return nil
+}
+
+// updateChannels applies msg to the channels we are in
+func (t *Tracker) updateChannels(raw *ircmsg.Message, source ircmsg.NUH, isUs func(string) bool) {
+	switch raw.Command {
+	case "JOIN":
+		// :nick!user@host JOIN #channel [account :realname]
+		if len(raw.Params) < 1 {
+			return
+		}
+
+		if isUs(source.Name) {
+			t.channels[t.fold(raw.Params[0])] = newChannel(raw.Params[0])
+		}
+
+		if c := t.channel(raw.Params[0]); c != nil {
+			c.members[t.fold(source.Name)] = &member{nick: source.Name}
+		}
+
+	case "PART":
+		// :nick!user@host PART #channel [:reason]
+		if len(raw.Params) < 1 {
+			return
+		}
+
+		t.removeMember(raw.Params[0], source.Name, isUs(source.Name))
+
+	case "KICK":
+		// :nick!user@host KICK #channel target [:reason]
+		if len(raw.Params) < 2 {
+			return
+		}
+
+		t.removeMember(raw.Params[0], raw.Params[1], isUs(raw.Params[1]))
+
+	case "QUIT":
+		for _, c := range t.channels {
+			delete(c.members, t.fold(source.Name))
+		}
+
+	case numerics.NICK:
+		// :old!user@host NICK new
+		if len(raw.Params) < 1 {
+			return
+		}
+
+		t.renameMember(source.Name, raw.Params[0])
+
+	case "MODE":
+		// :nick!user@host MODE #channel modes [params...]
+		if len(raw.Params) < 2 {
+			return
+		}
+
+		if c := t.channel(raw.Params[0]); c != nil {
+			t.applyModes(c, raw.Params[1:])
+		}
+
+	case "TOPIC":
+		// :nick!user@host TOPIC #channel :topic
+		if len(raw.Params) < 2 {
+			return
+		}
+
+		if c := t.channel(raw.Params[0]); c != nil {
+			c.topic = Topic{Text: raw.Params[1], SetBy: raw.Source, SetAt: messageTime(raw)}
+		}
+
+	default:
+		t.onNumeric(raw)
+	}
+}
+
+// onNumeric handles the numerics that describe channels
+func (t *Tracker) onNumeric(raw *ircmsg.Message) {
+	// All of these start with our nick and the channel
+	if len(raw.Params) < 3 {
+		return
+	}
+
+	switch raw.Command {
+	case numerics.RPL_NAMREPLY:
+		// :server 353 us = #channel :[prefixes]nick[!user@host] ...
+		if len(raw.Params) < 4 {
+			return
+		}
+
+		if c := t.channel(raw.Params[2]); c != nil {
+			if c.names == nil {
+				c.names = make(map[string]*member)
+			}
+
+			for _, name := range strings.Fields(raw.Params[3]) {
+				m, nuh := t.parseName(name)
+				c.names[t.fold(m.nick)] = m
+				t.seeUser(nuh)
+			}
+		}
+
+	case numerics.RPL_ENDOFNAMES:
+		// :server 366 us #channel :End of /NAMES list.
+		if c := t.channel(raw.Params[1]); c != nil && c.names != nil {
+			c.members, c.names = c.names, nil
+		}
+
+	case numerics.RPL_TOPIC:
+		// :server 332 us #channel :topic
+		if c := t.channel(raw.Params[1]); c != nil {
+			c.topic.Text = raw.Params[2]
+		}
+
+	case numerics.RPL_TOPICWHOTIME:
+		// :server 333 us #channel setter timestamp
+		if c := t.channel(raw.Params[1]); c != nil && len(raw.Params) > 3 {
+			c.topic.SetBy = raw.Params[2]
+			c.topic.SetAt = parseUnix(raw.Params[3])
+		}
+
+	case numerics.RPL_CHANNELMODEIS:
+		// :server 324 us #channel modes [params...]
+		if c := t.channel(raw.Params[1]); c != nil {
+			c.modes = nil
+			t.applyModes(c, raw.Params[2:])
+		}
+
+	case numerics.RPL_CREATIONTIME:
+		// :server 329 us #channel timestamp
+		if c := t.channel(raw.Params[1]); c != nil {
+			c.createdAt = parseUnix(raw.Params[2])
+		}
+	}
+}
+
+// channel returns the named channel, or nil if we are not in it
+func (t *Tracker) channel(name string) *channel { return t.channels[t.fold(name)] }
+
+func (t *Tracker) removeMember(channel, nick string, isUs bool) {
+	if isUs {
+		delete(t.channels, t.fold(channel))
+
+		return
+	}
+
+	if c := t.channel(channel); c != nil {
+		delete(c.members, t.fold(nick))
+	}
+}
+
+func (t *Tracker) renameMember(oldNick, newNick string) {
+	oldFolded, newFolded := t.fold(oldNick), t.fold(newNick)
+
+	for _, c := range t.channels {
+		m, ok := c.members[oldFolded]
+		if !ok {
+			continue
+		}
+
+		delete(c.members, oldFolded)
+
+		m.nick = newNick
+		c.members[newFolded] = m
+	}
+}
+
+// applyModes applies a MODE change, given as the mode string followed by its parameters
+func (t *Tracker) applyModes(c *channel, modes []string) {
+	rank := t.isupport.PrefixModes()
+
+	for _, change := range t.isupport.Modes().ParseModeSequence(strings.Join(modes, " ")) {
+		switch {
+		case change.Prefix != "":
+			if m, ok := c.members[t.fold(change.Parameter)]; ok {
+				m.setMode(change.Char, change.Adding, rank)
+			}
+
+		case change.Type == mode.TypeA:
+			// List modes can be huge, and are not sent on join, so they are not tracked
+
+		default:
+			c.setMode(change)
+		}
+	}
+}
+
+// parseName parses a name from RPL_NAMREPLY, which may have multiple prefixes with multi-prefix, and a full
+// n!u@h with userhost-in-names
+func (t *Tracker) parseName(name string) (*member, ircmsg.NUH) {
+	prefixes := t.isupport.Prefix()
+	modeFor := make(map[rune]rune, len(prefixes))
+
+	for m, p := range prefixes {
+		modeFor[p] = m
+	}
+
+	rank := t.isupport.PrefixModes()
+	out := &member{}
+
+	for len(name) > 0 {
+		m, ok := modeFor[rune(name[0])]
+		if !ok {
+			break
+		}
+
+		out.setMode(m, true, rank)
+		name = name[1:]
+	}
+
+	nuh, err := ircmsg.ParseNUH(name)
+	if err != nil {
+		nuh = ircmsg.NUH{Name: name}
+	}
+
+	out.nick = nuh.Name
+
+	return out, nuh
+}
+
+// messageTime returns the time a message was sent, using server-time if it is available
+func messageTime(msg *ircmsg.Message) time.Time {
+	if ok, value := msg.GetTag("time"); ok {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t
+		}
+	}
+
+	return time.Now()
+}
+
+// parseUnix parses a unix timestamp, returning the zero time if it is invalid
+func parseUnix(timestamp string) time.Time {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(secs, 0)
+}
@@ -0,0 +1,320 @@
+package state_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/isupport"
+	"awesome-dragon.science/go/irc/state"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const isupportLine = ":irc.example.com 005 me CHANTYPES=# CHANMODES=beI,k,l,imnpst PREFIX=(qaohv)~&@%+ " +
+	"CASEMAPPING=rfc1459 :are supported by this server"
+
+func newTracker(t *testing.T) *state.Tracker {
+	t.Helper()
+
+	is := isupport.New()
+	is.Parse(mustParse(t, isupportLine))
+
+	return state.NewTracker(is)
+}
+
+func mustParse(t *testing.T, line string) *ircmsg.Message {
+	t.Helper()
+
+	msg, err := ircmsg.ParseLine(line)
+	if err != nil {
+		t.Fatalf("could not parse %q: %v", line, err)
+	}
+
+	return &msg
+}
+
+// feed sends lines to tracker, as if our nick was "me"
+func feed(t *testing.T, tracker *state.Tracker, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		if err := tracker.OnMessage(&event.Message{Raw: mustParse(t, line), CurrentNick: "me"}); err != nil {
+			t.Fatalf("OnMessage(%q) returned an error: %v", line, err)
+		}
+	}
+}
+
+var joinLines = []string{ //nolint:gochecknoglobals // Used by most tests
+	":me!u@h JOIN #Chan",
+	":irc.example.com 353 me = #Chan :@me ~&Owner +voiced[] plain",
+	":irc.example.com 366 me #Chan :End of /NAMES list.",
+	":irc.example.com 332 me #Chan :The topic",
+	":irc.example.com 333 me #Chan setter!u@h 1600000000",
+	":irc.example.com 324 me #Chan +ntlk 10 secret",
+	":irc.example.com 329 me #Chan 1500000000",
+}
+
+func nicks(c state.Channel) []string {
+	out := make([]string, 0, len(c.Members))
+	for _, m := range c.Members {
+		out = append(out, m.Nick)
+	}
+
+	return out
+}
+
+func TestTracker_Join(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTracker(t)
+	feed(t, tracker, joinLines...)
+
+	c, ok := tracker.Channel("#chan")
+	if !ok {
+		t.Fatal("Channel(#chan) not found after joining #Chan")
+	}
+
+	if c.Name != "#Chan" {
+		t.Errorf("Name = %q, want %q", c.Name, "#Chan")
+	}
+
+	if want := []string{"me", "Owner", "plain", "voiced[]"}; !reflect.DeepEqual(nicks(c), want) {
+		t.Errorf("members = %v, want %v", nicks(c), want)
+	}
+
+	wantTopic := state.Topic{Text: "The topic", SetBy: "setter!u@h", SetAt: time.Unix(1600000000, 0)}
+	if c.Topic != wantTopic {
+		t.Errorf("Topic = %+v, want %+v", c.Topic, wantTopic)
+	}
+
+	if !c.CreatedAt.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("CreatedAt = %v, want %v", c.CreatedAt, time.Unix(1500000000, 0))
+	}
+
+	if key, ok := c.ModeParameter('k'); !ok || key != "secret" {
+		t.Errorf("ModeParameter('k') = %q, %t, want %q, true", key, ok, "secret")
+	}
+
+	if !c.HasMode('n') || !c.HasMode('t') || c.HasMode('m') {
+		t.Errorf("Modes = %v, want +ntlk", c.Modes)
+	}
+
+	owner, ok := c.Member("OWNER")
+	if !ok || owner.Modes != "qa" || owner.Prefixes != "~&" || owner.Prefix() != "~" {
+		t.Errorf("Member(OWNER) = %+v, %t, want modes qa", owner, ok)
+	}
+
+	if voiced, ok := tracker.Member("#CHAN", "VOICED{}"); !ok || !voiced.HasMode('v') {
+		t.Errorf("Member(#CHAN, VOICED{}) = %+v, %t, want voiced member found by rfc1459 casemapping", voiced, ok)
+	}
+
+	if got := tracker.Channels(); !reflect.DeepEqual(got, []string{"#Chan"}) {
+		t.Errorf("Channels() = %v, want [#Chan]", got)
+	}
+}
+
+func TestTracker_OnMessage(t *testing.T) { //nolint:funlen // Its a test
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		lines []string
+		check func(t *testing.T, tracker *state.Tracker)
+	}{
+		{
+			name:  "other join",
+			lines: []string{":new!u@h JOIN #chan"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if !tracker.IsOn("#chan", "NEW") {
+					t.Error("new should be on #chan")
+				}
+			},
+		},
+		{
+			name:  "other part",
+			lines: []string{":plain!u@h PART #chan :bye"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if tracker.IsOn("#chan", "plain") {
+					t.Error("plain should have left #chan")
+				}
+			},
+		},
+		{
+			name:  "our part",
+			lines: []string{":me!u@h PART #chan"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if _, ok := tracker.Channel("#chan"); ok {
+					t.Error("#chan should be forgotten after parting it")
+				}
+			},
+		},
+		{
+			name:  "kicked",
+			lines: []string{":Owner!u@h KICK #chan ME :go away"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if len(tracker.Channels()) != 0 {
+					t.Errorf("Channels() = %v, want none after being kicked", tracker.Channels())
+				}
+			},
+		},
+		{
+			name:  "kick other",
+			lines: []string{":me!u@h KICK #chan plain"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if tracker.IsOn("#chan", "plain") {
+					t.Error("plain should have been kicked")
+				}
+			},
+		},
+		{
+			name:  "quit",
+			lines: []string{":Owner!u@h QUIT :Quit: bye"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if tracker.IsOn("#chan", "Owner") {
+					t.Error("Owner should have quit")
+				}
+			},
+		},
+		{
+			name:  "nick change keeps modes",
+			lines: []string{":Owner!u@h NICK Boss"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if tracker.IsOn("#chan", "Owner") {
+					t.Error("Owner should have been renamed")
+				}
+
+				if m, ok := tracker.Member("#chan", "boss"); !ok || m.Nick != "Boss" || m.Modes != "qa" {
+					t.Errorf("Member(boss) = %+v, %t, want Boss with qa", m, ok)
+				}
+			},
+		},
+		{
+			name:  "prefix modes",
+			lines: []string{":me!u@h MODE #chan +o-v+h voiced[] voiced[] plain"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if m, _ := tracker.Member("#chan", "voiced[]"); m.Modes != "o" || m.Prefixes != "@" {
+					t.Errorf("voiced[] = %+v, want +o", m)
+				}
+
+				if m, _ := tracker.Member("#chan", "plain"); m.Modes != "h" {
+					t.Errorf("plain = %+v, want +h", m)
+				}
+			},
+		},
+		{
+			name:  "prefix modes are ranked",
+			lines: []string{":me!u@h MODE #chan +vo plain plain"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if m, _ := tracker.Member("#chan", "plain"); m.Modes != "ov" || m.Prefix() != "@" {
+					t.Errorf("plain = %+v, want modes ov", m)
+				}
+			},
+		},
+		{
+			name:  "channel modes",
+			lines: []string{":me!u@h MODE #chan -k+m-l+b secret *!*@bad"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				c, _ := tracker.Channel("#chan")
+				if c.HasMode('k') || c.HasMode('l') || !c.HasMode('m') || c.HasMode('b') {
+					t.Errorf("Modes = %v, want +ntm", c.Modes)
+				}
+			},
+		},
+		{
+			name:  "topic",
+			lines: []string{"@time=2021-01-01T00:00:00.000Z :Owner!u@h TOPIC #chan :New topic"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				c, _ := tracker.Channel("#chan")
+				want := state.Topic{
+					Text: "New topic", SetBy: "Owner!u@h", SetAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				}
+				if c.Topic != want {
+					t.Errorf("Topic = %+v, want %+v", c.Topic, want)
+				}
+			},
+		},
+		{
+			name: "names replaces members",
+			lines: []string{
+				":irc.example.com 353 me = #chan :@me",
+				":irc.example.com 353 me = #chan :other!u@h",
+				":irc.example.com 366 me #chan :End of /NAMES list.",
+			},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				c, _ := tracker.Channel("#chan")
+				if want := []string{"me", "other"}; !reflect.DeepEqual(nicks(c), want) {
+					t.Errorf("members = %v, want %v", nicks(c), want)
+				}
+			},
+		},
+		{
+			name:  "channels we're not in are ignored",
+			lines: []string{":new!u@h JOIN #other", ":irc.example.com 332 me #other :topic"},
+			check: func(t *testing.T, tracker *state.Tracker) {
+				t.Helper()
+				if _, ok := tracker.Channel("#other"); ok {
+					t.Error("#other should not be tracked")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracker := newTracker(t)
+			feed(t, tracker, joinLines...)
+			feed(t, tracker, tt.lines...)
+			tt.check(t, tracker)
+		})
+	}
+}
+
+func TestTracker_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTracker(t)
+	feed(t, tracker, joinLines...)
+
+	var messages []*event.Message
+	for _, line := range []string{":a!u@h JOIN #chan", ":me!u@h MODE #chan +o a", ":a!u@h PART #chan"} {
+		messages = append(messages, &event.Message{Raw: mustParse(t, line), CurrentNick: "me"})
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			for _, msg := range messages {
+				_ = tracker.OnMessage(msg)
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if c, ok := tracker.Channel("#chan"); ok {
+			_, _ = c.Member("a")
+		}
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,236 @@
+package state
+
+import (
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/numerics"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// User returns a snapshot of a user we share a channel with, looked up by nick
+func (t *Tracker) User(nick string) (user.User, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	u, ok := t.users[t.fold(nick)]
+	if !ok {
+		return user.User{}, false
+	}
+
+	return *u, true
+}
+
+// Sender returns a snapshot of the user that sent msg, if we share a channel with them. As the Tracker is fed
+// before handlers are called, this includes anything msg itself changed, such as a new nick or host.
+func (t *Tracker) Sender(msg *event.Message) (user.User, bool) {
+	nick := msg.Raw.Nick()
+	if msg.Raw.Command == numerics.NICK && len(msg.Raw.Params) > 0 {
+		nick = msg.Raw.Params[0]
+	}
+
+	return t.User(nick)
+}
+
+// Users returns snapshots of every user we share a channel with
+func (t *Tracker) Users() []user.User {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := make([]user.User, 0, len(t.users))
+	for _, u := range t.users {
+		out = append(out, *u)
+	}
+
+	return out
+}
+
+// Learn merges what is known about u, such as from a WHO reply, into the user with the same nick. Empty fields
+// in u are ignored, and nothing happens if we don't share a channel with them.
+func (t *Tracker) Learn(u user.User) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known, ok := t.users[t.fold(u.Name)]
+	if !ok {
+		return
+	}
+
+	if u.User != "" && u.Host != "" {
+		known.User, known.Host = u.User, u.Host
+	}
+
+	if u.RealIP != nil {
+		known.RealIP = u.RealIP
+	}
+
+	if u.RealHost != "" {
+		known.RealHost = u.RealHost
+	}
+
+	if u.RealName != "" {
+		known.RealName = u.RealName
+	}
+
+	if u.Account != "" {
+		known.Account = u.Account
+	}
+}
+
+// updateUsers applies msg to the users we share channels with. It is called after updateChannels, so channel
+// membership is already up to date
+func (t *Tracker) updateUsers(raw *ircmsg.Message, source ircmsg.NUH, isUs func(string) bool) {
+	switch raw.Command {
+	case "JOIN":
+		if !t.inAnyChannel(source.Name) {
+			return
+		}
+
+		u := t.seeUser(source)
+
+		// extended-join: :nick!user@host JOIN #channel account :realname
+		if len(raw.Params) == 3 {
+			u.Account = accountName(raw.Params[1])
+			u.RealName = raw.Params[2]
+		}
+
+	case "PART":
+		t.pruneUser(source.Name, isUs(source.Name))
+
+	case "KICK":
+		if len(raw.Params) > 1 {
+			t.pruneUser(raw.Params[1], isUs(raw.Params[1]))
+		}
+
+	case numerics.RPL_ENDOFNAMES:
+		t.pruneUsers()
+
+	case "QUIT":
+		delete(t.users, t.fold(source.Name))
+
+		return
+
+	case numerics.NICK:
+		if len(raw.Params) < 1 {
+			return
+		}
+
+		if u, ok := t.users[t.fold(source.Name)]; ok {
+			delete(t.users, t.fold(source.Name))
+
+			u.Name = raw.Params[0]
+			t.users[t.fold(u.Name)] = u
+		}
+
+		source.Name = raw.Params[0]
+	}
+
+	u, ok := t.users[t.fold(source.Name)]
+	if !ok {
+		return
+	}
+
+	updateUser(u, raw, source)
+}
+
+// updateUser applies the IRCv3 notifications and tags in raw, which was sent by u
+func updateUser(u *user.User, raw *ircmsg.Message, source ircmsg.NUH) {
+	if source.User != "" && source.Host != "" {
+		u.User, u.Host = source.User, source.Host
+	}
+
+	if ok, account := raw.GetTag("account"); ok {
+		u.Account = account
+	}
+
+	switch raw.Command {
+	case "ACCOUNT":
+		// account-notify: :nick!user@host ACCOUNT account
+		if len(raw.Params) > 0 {
+			u.Account = accountName(raw.Params[0])
+		}
+
+	case "AWAY":
+		// away-notify: :nick!user@host AWAY [:message]
+		u.Away = len(raw.Params) > 0
+		u.AwayMessage = ""
+
+		if u.Away {
+			u.AwayMessage = raw.Params[0]
+		}
+
+	case "CHGHOST":
+		// chghost: :nick!user@host CHGHOST newuser newhost
+		if len(raw.Params) > 1 {
+			u.User, u.Host = raw.Params[0], raw.Params[1]
+		}
+
+	case "SETNAME":
+		// setname: :nick!user@host SETNAME :realname
+		if len(raw.Params) > 0 {
+			u.RealName = raw.Params[0]
+		}
+	}
+}
+
+// seeUser returns the user with nuh's nick, adding them if they are new. Their user and host are updated from
+// nuh if it has them
+func (t *Tracker) seeUser(nuh ircmsg.NUH) *user.User {
+	folded := t.fold(nuh.Name)
+
+	u, ok := t.users[folded]
+	if !ok {
+		u = &user.User{NUH: ircmsg.NUH{Name: nuh.Name}}
+		t.users[folded] = u
+	}
+
+	if nuh.User != "" && nuh.Host != "" {
+		u.User, u.Host = nuh.User, nuh.Host
+	}
+
+	return u
+}
+
+// inAnyChannel returns whether or not nick is in any of the channels we are in
+func (t *Tracker) inAnyChannel(nick string) bool {
+	folded := t.fold(nick)
+
+	for _, c := range t.channels {
+		if _, ok := c.members[folded]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneUser forgets about nick if we no longer share any channels with them. If nick is us, we have left a
+// channel, and everyone is checked
+func (t *Tracker) pruneUser(nick string, isUs bool) {
+	if isUs {
+		t.pruneUsers()
+
+		return
+	}
+
+	if !t.inAnyChannel(nick) {
+		delete(t.users, t.fold(nick))
+	}
+}
+
+// pruneUsers forgets about users that we no longer share any channels with
+func (t *Tracker) pruneUsers() {
+	for folded, u := range t.users {
+		if !t.inAnyChannel(u.Name) {
+			delete(t.users, folded)
+		}
+	}
+}
+
+// accountName converts an account name as sent by the server to what we store, where "*" means logged out
+func accountName(account string) string {
+	if account == "*" {
+		return ""
+	}
+
+	return account
+}
@@ -0,0 +1,194 @@
+package state_test
+
+import (
+	"testing"
+
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/state"
+	"awesome-dragon.science/go/irc/user"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestTracker_Users(t *testing.T) { //nolint:funlen // Its a test
+	t.Parallel()
+
+	setup := []string{
+		":me!u@h JOIN #a",
+		":irc.example.com 353 me = #a :@me!u@h alice!al@alice.host bob",
+		":irc.example.com 366 me #a :End of /NAMES list.",
+		":me!u@h JOIN #b",
+		":irc.example.com 353 me = #b :@me bob",
+		":irc.example.com 366 me #b :End of /NAMES list.",
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		nick  string
+		want  *user.User // nil if the user should not be known
+	}{
+		{
+			name: "from names",
+			nick: "ALICE",
+			want: &user.User{NUH: ircmsg.NUH{Name: "alice", User: "al", Host: "alice.host"}},
+		},
+		{
+			name:  "extended join",
+			lines: []string{":carol!c@carol.host JOIN #a carolacct :Carol Real"},
+			nick:  "carol",
+			want: &user.User{
+				NUH: ircmsg.NUH{Name: "carol", User: "c", Host: "carol.host"}, Account: "carolacct", RealName: "Carol Real",
+			},
+		},
+		{
+			name:  "host learnt from a message",
+			lines: []string{":bob!b@bob.host PRIVMSG #a :hi"},
+			nick:  "bob",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "bob", User: "b", Host: "bob.host"}},
+		},
+		{
+			name:  "nick change",
+			lines: []string{":alice!al@alice.host NICK alicia"},
+			nick:  "alicia",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "alicia", User: "al", Host: "alice.host"}},
+		},
+		{
+			name:  "old nick forgotten",
+			lines: []string{":alice!al@alice.host NICK alicia"},
+			nick:  "alice",
+		},
+		{
+			name:  "account-notify",
+			lines: []string{":alice!al@alice.host ACCOUNT alice", ":alice!al@alice.host ACCOUNT *"},
+			nick:  "alice",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "alice", User: "al", Host: "alice.host"}},
+		},
+		{
+			name:  "account tag",
+			lines: []string{"@account=alice :alice!al@alice.host PRIVMSG #a :hi"},
+			nick:  "alice",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "alice", User: "al", Host: "alice.host"}, Account: "alice"},
+		},
+		{
+			name:  "away-notify",
+			lines: []string{":alice!al@alice.host AWAY :Gone fishing"},
+			nick:  "alice",
+			want: &user.User{
+				NUH: ircmsg.NUH{Name: "alice", User: "al", Host: "alice.host"}, Away: true, AwayMessage: "Gone fishing",
+			},
+		},
+		{
+			name:  "back from away",
+			lines: []string{":alice!al@alice.host AWAY :Gone fishing", ":alice!al@alice.host AWAY"},
+			nick:  "alice",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "alice", User: "al", Host: "alice.host"}},
+		},
+		{
+			name:  "chghost",
+			lines: []string{":alice!al@alice.host CHGHOST alice new.host"},
+			nick:  "alice",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "alice", User: "alice", Host: "new.host"}},
+		},
+		{
+			name:  "setname",
+			lines: []string{":alice!al@alice.host SETNAME :Alice Liddell"},
+			nick:  "alice",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "alice", User: "al", Host: "alice.host"}, RealName: "Alice Liddell"},
+		},
+		{
+			name:  "dropped when leaving the only shared channel",
+			lines: []string{":alice!al@alice.host PART #a"},
+			nick:  "alice",
+		},
+		{
+			name:  "kept while in another shared channel",
+			lines: []string{":me!u@h KICK #a bob"},
+			nick:  "bob",
+			want:  &user.User{NUH: ircmsg.NUH{Name: "bob"}},
+		},
+		{
+			name:  "dropped when we leave",
+			lines: []string{":me!u@h PART #a"},
+			nick:  "alice",
+		},
+		{
+			name:  "quit",
+			lines: []string{":bob!b@h QUIT :bye"},
+			nick:  "bob",
+		},
+		{
+			name:  "joins to channels we're not in are ignored",
+			lines: []string{":dave!d@h JOIN #elsewhere"},
+			nick:  "dave",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracker := newTracker(t)
+			feed(t, tracker, setup...)
+			feed(t, tracker, tt.lines...)
+
+			got, ok := tracker.User(tt.nick)
+
+			switch {
+			case tt.want == nil && ok:
+				t.Errorf("User(%q) = %+v, want no user", tt.nick, got)
+			case tt.want != nil && !ok:
+				t.Errorf("User(%q) not found, want %+v", tt.nick, *tt.want)
+			case tt.want != nil && (got.NUH != tt.want.NUH || got.Account != tt.want.Account ||
+				got.RealName != tt.want.RealName || got.Away != tt.want.Away || got.AwayMessage != tt.want.AwayMessage):
+				t.Errorf("User(%q) = %+v, want %+v", tt.nick, got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestTracker_Sender(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTracker(t)
+	feed(t, tracker, ":me!u@h JOIN #a", ":alice!al@alice.host JOIN #a")
+
+	msg := &event.Message{Raw: mustParse(t, ":alice!al@alice.host NICK alicia"), CurrentNick: "me"}
+	if err := tracker.OnMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, ok := tracker.Sender(msg); !ok || u.Name != "alicia" {
+		t.Errorf("Sender() = %+v, %t, want alicia", u, ok)
+	}
+
+	msg = &event.Message{Raw: mustParse(t, ":stranger!s@h PRIVMSG me :hi"), CurrentNick: "me"}
+	if u, ok := tracker.Sender(msg); ok {
+		t.Errorf("Sender() = %+v, want no user for someone we dont share a channel with", u)
+	}
+
+	if users := tracker.Users(); len(users) != 2 {
+		t.Errorf("Users() = %v, want me and alicia", users)
+	}
+}
+
+func TestTracker_Learn(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTracker(t)
+	feed(t, tracker, ":me!u@h JOIN #a", ":alice!al@alice.host JOIN #a")
+
+	tracker.Learn(user.User{NUH: ircmsg.NUH{Name: "ALICE"}, Account: "aliceacct", RealName: "Alice"})
+	tracker.Learn(user.User{NUH: ircmsg.NUH{Name: "stranger"}, Account: "someone"})
+
+	u, ok := tracker.User("alice")
+	if !ok || u.Account != "aliceacct" || u.RealName != "Alice" || u.Mask() != "alice!al@alice.host" {
+		t.Errorf("User() = %+v, %t, want alice with an account and real name", u, ok)
+	}
+
+	if u, ok := tracker.User("stranger"); ok {
+		t.Errorf("User() = %+v, want nothing learnt about someone we dont share a channel with", u)
+	}
+}
+
+var _ event.MessageHandler = (*state.Tracker)(nil)
@@ -0,0 +1,242 @@
+package transcript
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"awesome-dragon.science/go/irc/connection"
+	"awesome-dragon.science/go/irc/logger"
+)
+
+// DefaultReplayTimeout is how long a Replayer waits for the client to send a recorded line by default
+const DefaultReplayTimeout = time.Second * 5
+
+// ErrMismatch is returned by Replayer.Wait when the client did not send the lines that were recorded
+var ErrMismatch = errors.New("replay did not match transcript")
+
+// ReplayConfig configures a Replayer
+type ReplayConfig struct {
+	// Speed scales the delays between recorded server lines. 1 replays in real time, 2 twice as fast, and so on.
+	// 0 replays without any delays.
+	Speed float64
+	// Timeout is how long to wait for the client to send each recorded line. If 0, DefaultReplayTimeout is used
+	Timeout time.Duration
+	// Logger receives a warning for every mismatch. If nil, logger.Default() is used
+	Logger logger.Logger
+}
+
+// Diff is a difference between a recorded client line and what the client sent on replay
+type Diff struct {
+	Index int    // Index of the recorded line in the Transcript, or -1 for lines sent after it ended
+	Want  string // The recorded line, empty if the client sent a line that was not recorded
+	Got   string // The line that was sent, empty if the client did not send anything
+}
+
+func (d Diff) String() string {
+	switch {
+	case d.Want == "":
+		return fmt.Sprintf("unexpected line %q", d.Got)
+	case d.Got == "":
+		return fmt.Sprintf("line %d: missing %q", d.Index, d.Want)
+	default:
+		return fmt.Sprintf("line %d: want %q, got %q", d.Index, d.Want, d.Got)
+	}
+}
+
+// Replayer replays a Transcript over an in memory connection. Create one with NewReplayer, and use its Dial
+// method as connection.Config.Dial. Keepalive PINGs should be disabled on the replayed connection, as they
+// will not match the recording.
+type Replayer struct {
+	transcript Transcript
+	config     ReplayConfig
+
+	mu    sync.Mutex
+	diffs []Diff
+	err   error
+	used  bool
+
+	done chan struct{}
+}
+
+// NewReplayer creates a new Replayer for the given transcript. If config is nil, lines are replayed
+// without delays
+func NewReplayer(transcript Transcript, config *ReplayConfig) *Replayer {
+	if config == nil {
+		config = &ReplayConfig{}
+	}
+
+	out := &Replayer{transcript: transcript, config: *config, done: make(chan struct{})}
+	if out.config.Timeout <= 0 {
+		out.config.Timeout = DefaultReplayTimeout
+	}
+
+	out.config.Logger = logger.Or(out.config.Logger)
+
+	return out
+}
+
+// Dial implements connection.DialFunc. It may only be used once, after which it returns
+// connection.ErrDialerUsed
+func (r *Replayer) Dial(context.Context, string) (net.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.used {
+		return nil, connection.ErrDialerUsed
+	}
+
+	r.used = true
+	clientSide, serverSide := net.Pipe()
+
+	go r.run(serverSide)
+
+	return clientSide, nil
+}
+
+// run does the actual replaying, acting as the server on conn
+func (r *Replayer) run(conn net.Conn) {
+	defer close(r.done)
+	defer conn.Close()
+
+	// The client must never block on writing to us, or we'll deadlock when we write to it
+	sent := make(chan string, 1024)
+
+	go func() {
+		defer close(sent)
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			sent <- connection.RedactLine(line)
+		}
+	}()
+
+	var last time.Time
+
+	for i, record := range r.transcript {
+		if record.Direction == connection.DirectionOut {
+			r.expect(i, record.Line, sent)
+
+			continue
+		}
+
+		r.wait(last, record.Time)
+		last = record.Time
+
+		if _, err := conn.Write([]byte(record.Line + "\r\n")); err != nil {
+			r.setErr(fmt.Errorf("could not write line %d: %w", i, err))
+
+			return
+		}
+	}
+
+	// Anything the client sent while we were replaying the last lines, that wasn't recorded
+	for {
+		select {
+		case line, ok := <-sent:
+			if !ok {
+				return
+			}
+
+			r.addDiff(Diff{Index: -1, Got: line})
+
+		default:
+			return
+		}
+	}
+}
+
+// wait sleeps for the time between two recorded lines, scaled by the configured speed
+func (r *Replayer) wait(last, next time.Time) {
+	if r.config.Speed <= 0 || last.IsZero() {
+		return
+	}
+
+	if delay := time.Duration(float64(next.Sub(last)) / r.config.Speed); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// expect waits for the client to send want, recording a Diff if it sends something else, or nothing
+func (r *Replayer) expect(index int, want string, sent <-chan string) {
+	timer := time.NewTimer(r.config.Timeout)
+	defer timer.Stop()
+
+	select {
+	case got, ok := <-sent:
+		if !ok {
+			r.addDiff(Diff{Index: index, Want: want})
+		} else if got != want {
+			r.addDiff(Diff{Index: index, Want: want, Got: got})
+		}
+
+	case <-timer.C:
+		r.addDiff(Diff{Index: index, Want: want})
+	}
+}
+
+func (r *Replayer) addDiff(diff Diff) {
+	r.config.Logger.Warn("Replay mismatch", "index", diff.Index, "want", diff.Want, "got", diff.Got)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.diffs = append(r.diffs, diff)
+}
+
+func (r *Replayer) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.err = err
+}
+
+// Done returns a channel that is closed once the whole transcript has been replayed
+func (r *Replayer) Done() <-chan struct{} { return r.done }
+
+// Diffs returns every difference found so far between the transcript and what the client sent
+func (r *Replayer) Diffs() []Diff {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Diff(nil), r.diffs...)
+}
+
+// Wait waits for the replay to finish, or ctx to be cancelled. It returns an error wrapping ErrMismatch if the
+// client did not send what was recorded.
+func (r *Replayer) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return fmt.Errorf("replay not finished: %w", ctx.Err())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if len(r.diffs) > 0 {
+		diffs := make([]string, 0, len(r.diffs))
+		for _, d := range r.diffs {
+			diffs = append(diffs, d.String())
+		}
+
+		return fmt.Errorf("%w: %d differences:\n%s", ErrMismatch, len(r.diffs), strings.Join(diffs, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package transcript_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"awesome-dragon.science/go/irc/capab"
+	"awesome-dragon.science/go/irc/client"
+	"awesome-dragon.science/go/irc/connection"
+	"awesome-dragon.science/go/irc/event"
+	"awesome-dragon.science/go/irc/event/function"
+	"awesome-dragon.science/go/irc/transcript"
+)
+
+const session = `
+{"time":"2022-01-01T00:00:00Z","direction":"out","line":"CAP LS 302"}
+{"time":"2022-01-01T00:00:00.01Z","direction":"in","line":":irc.test CAP * LS :"}
+{"time":"2022-01-01T00:00:00.02Z","direction":"out","line":"CAP END"}
+{"time":"2022-01-01T00:00:00.02Z","direction":"out","line":"NICK test"}
+{"time":"2022-01-01T00:00:00.02Z","direction":"out","line":"USER test * * :real name"}
+{"time":"2022-01-01T00:00:00.03Z","direction":"in","line":":irc.test 001 test :Welcome"}
+{"time":"2022-01-01T00:00:00.04Z","direction":"in","line":":someone!u@h PRIVMSG #chan :!ping"}
+{"time":"2022-01-01T00:00:00.05Z","direction":"out","line":"PRIVMSG #chan pong"}
+`
+
+// replay replays session into a client that replies to !ping with reply, returning the result of Wait
+func replay(t *testing.T, reply string, record connection.RawLogSink) error {
+	t.Helper()
+
+	script, err := transcript.Load(strings.NewReader(session))
+	if err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+
+	replayer := transcript.NewReplayer(script, &transcript.ReplayConfig{Speed: 10, Timeout: time.Millisecond * 500})
+
+	c := client.New(&client.Config{
+		Connection: connection.Config{Dial: replayer.Dial, Record: record},
+		Nick:       "test",
+		Username:   "test",
+		Realname:   "real name",
+		STSStore:   &capab.MemorySTSStore{},
+	})
+
+	c.SetMessageHandler(function.FuncHandler(func(msg *event.Message) error {
+		if msg.Raw.Command == "PRIVMSG" && msg.Raw.Params[1] == "!ping" {
+			return c.SendMessage(msg.Raw.Params[0], reply)
+		}
+
+		return nil
+	}))
+
+	go func() { _ = c.Run(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err = replayer.Wait(ctx)
+
+	c.WaitForExit()
+
+	return err
+}
+
+func TestReplayer(t *testing.T) {
+	t.Parallel()
+
+	recorded := &bytes.Buffer{}
+
+	if err := replay(t, "pong", connection.NewJSONSink(recorded)); err != nil {
+		t.Fatalf("replay failed: %s", err)
+	}
+
+	// What was recorded during the replay should be the same session again
+	script, err := transcript.Load(recorded)
+	if err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+
+	original, _ := transcript.Load(strings.NewReader(session))
+
+	if len(script) != len(original) {
+		t.Fatalf("recorded %d lines, want %d", len(script), len(original))
+	}
+
+	for i := range script {
+		if script[i].Direction != original[i].Direction || script[i].Line != original[i].Line {
+			t.Errorf("recorded line %d = %+v, want %+v", i, script[i], original[i])
+		}
+	}
+}
+
+func TestReplayer_Mismatch(t *testing.T) {
+	t.Parallel()
+
+	err := replay(t, "nope", nil)
+	if !errors.Is(err, transcript.ErrMismatch) {
+		t.Fatalf("replay error = %v, want %v", err, transcript.ErrMismatch)
+	}
+
+	if !strings.Contains(err.Error(), `want "PRIVMSG #chan pong", got "PRIVMSG #chan nope"`) {
+		t.Errorf("error did not contain the difference: %s", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	if _, err := transcript.Load(strings.NewReader("\n")); !errors.Is(err, transcript.ErrEmptyTranscript) {
+		t.Errorf("Load() on empty transcript error = %v, want %v", err, transcript.ErrEmptyTranscript)
+	}
+
+	if _, err := transcript.Load(strings.NewReader("not json")); err == nil {
+		t.Error("Load() on invalid transcript returned no error")
+	}
+}
@@ -0,0 +1,65 @@
+// Package transcript implements recording IRC sessions, and replaying them into a client with no network.
+//
+// To record a session, set connection.Config.Record to a connection.JSONSink writing to a file. Load the file
+// with Load, and use a Replayer's Dial method as connection.Config.Dial to replay it. The Replayer sends the
+// recorded server lines, and checks that the client sends the same lines it did when the session was recorded.
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"awesome-dragon.science/go/irc/connection"
+)
+
+// ErrEmptyTranscript is returned when loading a transcript with no lines in it
+var ErrEmptyTranscript = errors.New("empty transcript")
+
+// Transcript is a list of lines, in the order they were sent or received
+type Transcript []connection.RawLogRecord
+
+// Load reads a transcript written by a connection.JSONSink
+func Load(r io.Reader) (Transcript, error) {
+	out := Transcript{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, connection.MaxLineLength+connection.MaxTagLength+1024)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := connection.RawLogRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("could not parse transcript line %d: %w", lineNum, err)
+		}
+
+		out = append(out, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read transcript: %w", err)
+	}
+
+	if len(out) == 0 {
+		return nil, ErrEmptyTranscript
+	}
+
+	return out, nil
+}
+
+// LoadFile is like Load, but reads the transcript from the given file
+func LoadFile(path string) (Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open transcript: %w", err)
+	}
+
+	defer f.Close()
+
+	return Load(f)
+}
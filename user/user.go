@@ -22,10 +22,12 @@ func capAvailable(key string, s []capab.Capability) bool {
 // User represents an IRC user, with some optional bits of info, if known
 type User struct {
 	ircmsg.NUH
-	RealIP   net.IP
-	RealHost string
-	RealName string
-	Account  string
+	RealIP      net.IP
+	RealHost    string
+	RealName    string
+	Account     string
+	Away        bool
+	AwayMessage string
 }
 
 // Mask returns a n!u@h mask for the given User instance
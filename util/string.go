@@ -1,5 +1,10 @@
 package util
 
+import (
+	"strings"
+	"unicode/utf8"
+)
+
 func min(a, b int) int {
 	if a > b {
 		return b
@@ -28,3 +33,48 @@ func ChunkMessage(message string, chunkLength int) []string {
 
 	return out
 }
+
+// SplitByteLength splits message into chunks of at most maxBytes bytes. Chunks are split on the last space
+// that fits, if there is one, and never in the middle of a UTF-8 sequence. The space a chunk is split on is
+// dropped.
+func SplitByteLength(message string, maxBytes int) []string {
+	if maxBytes < utf8.UTFMax {
+		panic("SplitByteLength: max length too small")
+	}
+
+	out := []string{}
+
+	for len(message) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(message[cut]) {
+			cut--
+		}
+
+		if space := strings.LastIndexByte(message[:cut+1], ' '); space > 0 {
+			out = append(out, message[:space])
+			message = message[space+1:]
+
+			continue
+		}
+
+		out = append(out, message[:cut])
+		message = message[cut:]
+	}
+
+	return append(out, message)
+}
+
+// TruncateByteLength truncates message to at most maxBytes bytes, without cutting a UTF-8 sequence in half.
+// If maxBytes is zero or less, message is returned unchanged.
+func TruncateByteLength(message string, maxBytes int) string {
+	if maxBytes <= 0 || len(message) <= maxBytes {
+		return message
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+
+	return message[:cut]
+}
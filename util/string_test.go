@@ -46,3 +46,59 @@ func TestChunkMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitByteLength(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		message  string
+		maxBytes int
+		want     []string
+	}{
+		{name: "fits", message: "this is a test", maxBytes: 100, want: []string{"this is a test"}},
+		{name: "on spaces", message: "this is a test", maxBytes: 8, want: []string{"this is", "a test"}},
+		{name: "no spaces", message: "abcdefghij", maxBytes: 4, want: []string{"abcd", "efgh", "ij"}},
+		{name: "utf8", message: "ééééé", maxBytes: 5, want: []string{"éé", "éé", "é"}},
+		{name: "exact", message: "abcd efgh", maxBytes: 4, want: []string{"abcd", "efgh"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := SplitByteLength(tt.message, tt.maxBytes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitByteLength() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateByteLength(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		message  string
+		maxBytes int
+		want     string
+	}{
+		{name: "fits", message: "this is a test", maxBytes: 100, want: "this is a test"},
+		{name: "truncated", message: "this is a test", maxBytes: 7, want: "this is"},
+		{name: "utf8", message: "ééééé", maxBytes: 5, want: "éé"},
+		{name: "no limit", message: "this is a test", maxBytes: 0, want: "this is a test"},
+		{name: "unlimited", message: "this is a test", maxBytes: -1, want: "this is a test"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := TruncateByteLength(tt.message, tt.maxBytes); got != tt.want {
+				t.Errorf("TruncateByteLength() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}